@@ -26,6 +26,11 @@ const (
 	CacheEventTypeMiss = "cache_miss"
 	// CacheEventTypeHit is the event type for cache hits.
 	CacheEventTypeHit = "cache_hit"
+	// CacheEventTypeRevalidated is the event type for stale items revalidated
+	// as unchanged with their origin.
+	CacheEventTypeRevalidated = "cache_revalidated"
+	// CacheEventTypeUpdate is the event type for stale items updated from their origin.
+	CacheEventTypeUpdate = "cache_update"
 )
 
 // CacheRecorder is a recorder for cache events.
@@ -37,9 +42,10 @@ type CacheRecorder struct {
 // NewCacheRecorder returns a new CacheRecorder.
 // The configured labels are: event_type, name, namespace.
 // The event_type is one of:
-//   - "miss"
-//   - "hit"
-//   - "update"
+//   - "cache_miss"
+//   - "cache_hit"
+//   - "cache_revalidated"
+//   - "cache_update"
 //
 // The name is the name of the reconciled resource.
 // The namespace is the namespace of the reconciled resource.
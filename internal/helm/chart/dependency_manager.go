@@ -0,0 +1,358 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+	"k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// GetChartDownloaderCallback must return a Downloader for the
+// URL or an error describing why it could not be returned.
+type GetChartDownloaderCallback func(url string) (repository.Downloader, error)
+
+// DependencyManager manages dependencies for a Helm chart.
+type DependencyManager struct {
+	// downloaders contains a map of Downloader objects
+	// indexed by their repository.NormalizeURL.
+	// It is consulted as a lookup table for missing dependencies, based on
+	// the (repository) URL the dependency refers to.
+	downloaders map[string]repository.Downloader
+
+	// getChartDownloaderCallback can be set to an on-demand GetChartDownloaderCallback
+	// whose returned result is cached to downloaders.
+	getChartDownloaderCallback GetChartDownloaderCallback
+
+	// localPath is the directory of the chart on disk, used to resolve
+	// dependencies with a 'file://' repository. Empty if the chart was
+	// not loaded from a directory.
+	localPath string
+
+	// concurrent is the number of concurrent chart-add operations during
+	// Build. Defaults to 1 (non-concurrent).
+	concurrent int64
+
+	// mu contains the lock for chart writes.
+	mu sync.Mutex
+}
+
+// DependencyManagerOption configures an option on a DependencyManager.
+type DependencyManagerOption interface {
+	applyToDependencyManager(dm *DependencyManager)
+}
+
+// WithRepositories configures the Downloader objects of the DependencyManager,
+// indexed by their repository.NormalizeURL.
+type WithRepositories map[string]repository.Downloader
+
+func (o WithRepositories) applyToDependencyManager(dm *DependencyManager) {
+	dm.downloaders = o
+}
+
+// WithDownloaderCallback configures the callback used to get a Downloader
+// for a dependency repository URL which is not configured.
+type WithDownloaderCallback GetChartDownloaderCallback
+
+func (o WithDownloaderCallback) applyToDependencyManager(dm *DependencyManager) {
+	dm.getChartDownloaderCallback = GetChartDownloaderCallback(o)
+}
+
+// WithLocalPath configures the directory of the chart on disk,
+// which enables the resolution of local ('file://') dependencies.
+type WithLocalPath string
+
+func (o WithLocalPath) applyToDependencyManager(dm *DependencyManager) {
+	dm.localPath = string(o)
+}
+
+// WithConcurrent configures the number of concurrent chart-add operations.
+type WithConcurrent int64
+
+func (o WithConcurrent) applyToDependencyManager(dm *DependencyManager) {
+	dm.concurrent = int64(o)
+}
+
+// NewDependencyManager returns a new DependencyManager configured with the given
+// DependencyManagerOption list.
+func NewDependencyManager(opts ...DependencyManagerOption) *DependencyManager {
+	dm := &DependencyManager{}
+	for _, v := range opts {
+		v.applyToDependencyManager(dm)
+	}
+	return dm
+}
+
+// Clear iterates over the downloaders, calling Clear on all
+// items. It returns an aggregate error of all Clear errors.
+func (dm *DependencyManager) Clear() error {
+	var errs []error
+	for _, v := range dm.downloaders {
+		if v != nil {
+			errs = append(errs, v.Clear())
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+// Build compiles a set of missing dependencies from chart.Chart, and attempts to
+// resolve and add them to the chart. If the chart has a Chart.lock, the locked
+// versions are used, after checking the lock is in sync with Chart.yaml.
+// It returns the number of resolved local and remote dependencies, or an error.
+func (dm *DependencyManager) Build(ctx context.Context, chart *helmchart.Chart) (int, error) {
+	// Collect dependency metadata
+	var (
+		deps = chart.Dependencies()
+		reqs = chart.Metadata.Dependencies
+	)
+	// Lock file takes precedence
+	if lock := chart.Lock; lock != nil {
+		if err := VerifyLock(chart); err != nil {
+			return 0, err
+		}
+		reqs = lock.Dependencies
+	}
+
+	// Collect missing dependencies
+	missing := collectMissing(deps, reqs)
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	// Run the build for the missing dependencies
+	if err := dm.build(ctx, chart, missing); err != nil {
+		return 0, err
+	}
+	return len(missing), nil
+}
+
+// VerifyLock returns an error if the digest of the Chart.lock of the given chart
+// does not match its dependencies declared in Chart.yaml. Charts without a
+// Chart.lock, or with a Chart.lock without a digest, are always valid.
+func VerifyLock(chart *helmchart.Chart) error {
+	lock := chart.Lock
+	if lock == nil || lock.Digest == "" {
+		return nil
+	}
+	sum, err := HashReq(chart.Metadata.Dependencies, lock.Dependencies)
+	if err != nil {
+		return err
+	}
+	if sum != lock.Digest {
+		return fmt.Errorf("the lock file (Chart.lock) is out of sync with the dependencies file (Chart.yaml): digest '%s' does not match '%s'", lock.Digest, sum)
+	}
+	return nil
+}
+
+// HashReq generates a hash of the dependencies.
+//
+// This should be used only to compare against another hash generated by this
+// function, which is how Helm computes the digest of a Chart.lock.
+func HashReq(req, lock []*helmchart.Dependency) (string, error) {
+	data, err := json.Marshal([2][]*helmchart.Dependency{req, lock})
+	if err != nil {
+		return "", err
+	}
+	s, err := provenance.Digest(bytes.NewBuffer(data))
+	return "sha256:" + s, err
+}
+
+// chartWithLock holds a chart.Chart with a sync.Mutex to lock for writes.
+type chartWithLock struct {
+	*helmchart.Chart
+	mu sync.Mutex
+}
+
+// build adds the given list of deps to the chart with the configured number of
+// concurrent workers. If the chart.Chart references a local dependency but no
+// local path is configured, or any dependency could not be added, an error
+// is returned. The first error it encounters cancels all other workers.
+func (dm *DependencyManager) build(ctx context.Context, c *helmchart.Chart, deps map[string]*helmchart.Dependency) error {
+	current := dm.concurrent
+	if current <= 0 {
+		current = 1
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		sem := semaphore.NewWeighted(current)
+		c := &chartWithLock{Chart: c}
+		for name, dep := range deps {
+			name, dep := name, dep
+			if err := sem.Acquire(groupCtx, 1); err != nil {
+				return err
+			}
+			group.Go(func() (err error) {
+				defer sem.Release(1)
+				if isLocalDep(dep) {
+					if err = dm.addLocalDependency(c, dep); err != nil {
+						err = fmt.Errorf("failed to add local dependency '%s': %w", name, err)
+					}
+					return
+				}
+				if err = dm.addRemoteDependency(groupCtx, c, dep); err != nil {
+					err = fmt.Errorf("failed to add remote dependency '%s': %w", name, err)
+				}
+				return
+			})
+		}
+		return nil
+	})
+	return group.Wait()
+}
+
+// addLocalDependency attempts to resolve and add the given local chart.Dependency
+// to the chart.
+func (dm *DependencyManager) addLocalDependency(c *chartWithLock, dep *helmchart.Dependency) error {
+	if dm.localPath == "" {
+		return fmt.Errorf("dependencies with a local repository must be vendored in the 'charts/' directory of a packaged chart")
+	}
+
+	depPath := filepath.Join(dm.localPath, strings.TrimPrefix(dep.Repository, "file://"))
+	ch, err := loader.Load(depPath)
+	if err != nil {
+		return fmt.Errorf("failed to load chart from '%s': %w", depPath, err)
+	}
+
+	if dep.Version != "" {
+		constraint, err := semver.NewConstraint(dep.Version)
+		if err != nil {
+			return fmt.Errorf("invalid version/constraint format '%s': %w", dep.Version, err)
+		}
+		v, err := semver.NewVersion(ch.Metadata.Version)
+		if err != nil {
+			return err
+		}
+		if !constraint.Check(v) {
+			return fmt.Errorf("can't get a valid version for constraint '%s'", dep.Version)
+		}
+	}
+
+	c.mu.Lock()
+	c.AddDependency(ch)
+	c.mu.Unlock()
+	return nil
+}
+
+// addRemoteDependency attempts to resolve and add the given dependency to the chart.
+// If the repository declares a digest for the resolved chart version, the
+// downloaded chart archive must match it.
+func (dm *DependencyManager) addRemoteDependency(ctx context.Context, chart *chartWithLock, dep *helmchart.Dependency) error {
+	repo, err := dm.resolveRepository(dep.Repository)
+	if err != nil {
+		return err
+	}
+
+	ver, err := repo.GetChartVersion(dep.Name, dep.Version)
+	if err != nil {
+		return fmt.Errorf("failed to get chart '%s' version '%s' from '%s': %w", dep.Name, dep.Version, dep.Repository, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res, err := repo.DownloadChart(ver)
+	if err != nil {
+		return fmt.Errorf("chart download of version '%s' failed: %w", ver.Version, err)
+	}
+	if ver.Digest != "" {
+		sum := sha256.Sum256(res.Bytes())
+		if digest := hex.EncodeToString(sum[:]); digest != strings.TrimPrefix(ver.Digest, "sha256:") {
+			return fmt.Errorf("digest '%s' of chart version '%s' does not match the repository digest '%s'", digest, ver.Version, ver.Digest)
+		}
+	}
+	ch, err := loader.LoadArchive(res)
+	if err != nil {
+		return fmt.Errorf("failed to load downloaded archive of version '%s': %w", ver.Version, err)
+	}
+
+	chart.mu.Lock()
+	chart.AddDependency(ch)
+	chart.mu.Unlock()
+	return nil
+}
+
+// resolveRepository first attempts to resolve the url from the downloaders, falling back
+// to getDownloaderCallback if set. It returns the resolved Index, or an error.
+func (dm *DependencyManager) resolveRepository(url string) (repository.Downloader, error) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	nUrl := repository.NormalizeURL(url)
+	if err := repository.ValidateDepURL(nUrl); err != nil {
+		return nil, err
+	}
+	if repo, ok := dm.downloaders[nUrl]; ok {
+		return repo, nil
+	}
+	if dm.getChartDownloaderCallback == nil {
+		return nil, fmt.Errorf("no chart repository for URL '%s'", nUrl)
+	}
+
+	repo, err := dm.getChartDownloaderCallback(nUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chart repository for URL '%s': %w", nUrl, err)
+	}
+	if dm.downloaders == nil {
+		dm.downloaders = map[string]repository.Downloader{}
+	}
+	dm.downloaders[nUrl] = repo
+	return repo, nil
+}
+
+// collectMissing returns a map with dependencies from reqs that are missing
+// from current, indexed by their name. Aliased dependencies share the chart
+// of their name, as Helm copies it for every alias when rendering.
+// All dependencies of a chart are present if len of returned map == 0.
+func collectMissing(current []*helmchart.Chart, reqs []*helmchart.Dependency) map[string]*helmchart.Dependency {
+	existing := make(map[string]struct{}, len(current))
+	for _, c := range current {
+		existing[c.Name()] = struct{}{}
+	}
+
+	var missing map[string]*helmchart.Dependency
+	for _, dep := range reqs {
+		if _, ok := existing[dep.Name]; ok {
+			continue
+		}
+		if missing == nil {
+			missing = map[string]*helmchart.Dependency{}
+		}
+		missing[dep.Name] = dep
+	}
+	return missing
+}
+
+// isLocalDep returns true if the given chart.Dependency contains a local (file) path reference.
+func isLocalDep(dep *helmchart.Dependency) bool {
+	return dep.Repository == "" || strings.HasPrefix(dep.Repository, "file://")
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// mockDownloader serves charts named after the requested chart.
+type mockDownloader struct {
+	// digest is the digest reported for every chart version, if set.
+	digest  string
+	cleared bool
+}
+
+func (d *mockDownloader) GetChartVersion(name, version string) (*repo.ChartVersion, error) {
+	if name == "missing" {
+		return nil, errors.New("chart not found")
+	}
+	return &repo.ChartVersion{
+		Metadata: &helmchart.Metadata{Name: name, Version: version},
+		URLs:     []string{fmt.Sprintf("%s-%s.tgz", name, version)},
+		Digest:   d.digest,
+	}, nil
+}
+
+func (d *mockDownloader) DownloadChart(cv *repo.ChartVersion) (*bytes.Buffer, error) {
+	return packageChart(cv.Name, cv.Version)
+}
+
+func (d *mockDownloader) VerifyChart(_ context.Context, _ *repo.ChartVersion) error {
+	return nil
+}
+
+func (d *mockDownloader) Clear() error {
+	d.cleared = true
+	return nil
+}
+
+func packageChart(name, version string) (*bytes.Buffer, error) {
+	dir, err := os.MkdirTemp("", "chart-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := chartutil.Save(&helmchart.Chart{
+		Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: name, Version: version},
+	}, dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(b), nil
+}
+
+func chartWithDependencies(deps ...*helmchart.Dependency) *helmchart.Chart {
+	return &helmchart.Chart{
+		Metadata: &helmchart.Metadata{
+			APIVersion:   helmchart.APIVersionV2,
+			Name:         "parent",
+			Version:      "0.1.0",
+			Dependencies: deps,
+		},
+	}
+}
+
+func TestDependencyManager_Build(t *testing.T) {
+	const repoURL = "https://example.com/charts/"
+
+	t.Run("adds missing dependencies", func(t *testing.T) {
+		g := NewWithT(t)
+
+		vendored := &helmchart.Chart{Metadata: &helmchart.Metadata{Name: "vendored", Version: "1.0.0"}}
+		c := chartWithDependencies(
+			&helmchart.Dependency{Name: "vendored", Version: "1.0.0", Repository: repoURL},
+			&helmchart.Dependency{Name: "redis", Version: "1.2.3", Repository: repoURL},
+			&helmchart.Dependency{Name: "redis", Alias: "cache", Version: "1.2.3", Repository: repoURL},
+			&helmchart.Dependency{Name: "nginx", Version: "2.0.0", Repository: "https://example.com/charts"},
+		)
+		c.AddDependency(vendored)
+
+		d := &mockDownloader{}
+		dm := NewDependencyManager(WithRepositories{repoURL: d}, WithConcurrent(2))
+		n, err := dm.Build(context.TODO(), c)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(n).To(Equal(2))
+		g.Expect(c.Dependencies()).To(HaveLen(3))
+		g.Expect(dm.Clear()).To(Succeed())
+		g.Expect(d.cleared).To(BeTrue())
+	})
+
+	t.Run("uses the downloader callback", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var calls []string
+		dm := NewDependencyManager(WithDownloaderCallback(func(url string) (repository.Downloader, error) {
+			calls = append(calls, url)
+			return &mockDownloader{}, nil
+		}))
+		n, err := dm.Build(context.TODO(), chartWithDependencies(
+			&helmchart.Dependency{Name: "redis", Version: "1.2.3", Repository: "oci://example.com/charts/"},
+		))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(n).To(Equal(1))
+		g.Expect(calls).To(Equal([]string{"oci://example.com/charts"}))
+	})
+
+	t.Run("no repository", func(t *testing.T) {
+		g := NewWithT(t)
+		dm := NewDependencyManager()
+		_, err := dm.Build(context.TODO(), chartWithDependencies(
+			&helmchart.Dependency{Name: "redis", Version: "1.2.3", Repository: repoURL},
+		))
+		g.Expect(err).To(MatchError(ContainSubstring("no chart repository for URL")))
+	})
+
+	t.Run("aliased repository", func(t *testing.T) {
+		g := NewWithT(t)
+		dm := NewDependencyManager()
+		_, err := dm.Build(context.TODO(), chartWithDependencies(
+			&helmchart.Dependency{Name: "redis", Version: "1.2.3", Repository: "@stable"},
+		))
+		g.Expect(err).To(MatchError(ContainSubstring("aliased repository dependency is not supported")))
+	})
+
+	t.Run("unknown chart", func(t *testing.T) {
+		g := NewWithT(t)
+		dm := NewDependencyManager(WithRepositories{repoURL: &mockDownloader{}})
+		_, err := dm.Build(context.TODO(), chartWithDependencies(
+			&helmchart.Dependency{Name: "missing", Version: "1.2.3", Repository: repoURL},
+		))
+		g.Expect(err).To(MatchError(ContainSubstring("chart not found")))
+	})
+
+	t.Run("repository digest", func(t *testing.T) {
+		g := NewWithT(t)
+
+		b, err := packageChart("redis", "1.2.3")
+		g.Expect(err).ToNot(HaveOccurred())
+		sum := sha256.Sum256(b.Bytes())
+		dep := &helmchart.Dependency{Name: "redis", Version: "1.2.3", Repository: repoURL}
+
+		dm := NewDependencyManager(WithRepositories{repoURL: &mockDownloader{digest: hex.EncodeToString(sum[:])}})
+		_, err = dm.Build(context.TODO(), chartWithDependencies(dep))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		dm = NewDependencyManager(WithRepositories{repoURL: &mockDownloader{digest: "0000"}})
+		_, err = dm.Build(context.TODO(), chartWithDependencies(dep))
+		g.Expect(err).To(MatchError(ContainSubstring("does not match the repository digest")))
+	})
+
+	t.Run("lock takes precedence", func(t *testing.T) {
+		g := NewWithT(t)
+
+		req := []*helmchart.Dependency{{Name: "redis", Version: "~1.2", Repository: repoURL}}
+		lock := []*helmchart.Dependency{{Name: "redis", Version: "1.2.3", Repository: repoURL}}
+		digest, err := HashReq(req, lock)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		c := chartWithDependencies(req...)
+		c.Lock = &helmchart.Lock{Digest: digest, Dependencies: lock}
+		dm := NewDependencyManager(WithRepositories{repoURL: &mockDownloader{}})
+		_, err = dm.Build(context.TODO(), c)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.Dependencies()).To(HaveLen(1))
+		g.Expect(c.Dependencies()[0].Metadata.Version).To(Equal("1.2.3"))
+	})
+
+	t.Run("lock out of sync", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := chartWithDependencies(&helmchart.Dependency{Name: "redis", Version: "~1.3", Repository: repoURL})
+		c.Lock = &helmchart.Lock{
+			Digest:       "sha256:0000",
+			Dependencies: []*helmchart.Dependency{{Name: "redis", Version: "1.2.3", Repository: repoURL}},
+		}
+		dm := NewDependencyManager(WithRepositories{repoURL: &mockDownloader{}})
+		_, err := dm.Build(context.TODO(), c)
+		g.Expect(err).To(MatchError(ContainSubstring("out of sync")))
+	})
+
+	t.Run("local dependency", func(t *testing.T) {
+		g := NewWithT(t)
+
+		dir := t.TempDir()
+		g.Expect(chartutil.SaveDir(&helmchart.Chart{
+			Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: "common", Version: "0.3.0"},
+		}, dir)).To(Succeed())
+		dep := &helmchart.Dependency{Name: "common", Version: "~0.3", Repository: "file://../common"}
+
+		dm := NewDependencyManager(WithLocalPath(filepath.Join(dir, "parent")))
+		c := chartWithDependencies(dep)
+		_, err := dm.Build(context.TODO(), c)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.Dependencies()).To(HaveLen(1))
+
+		_, err = NewDependencyManager().Build(context.TODO(), chartWithDependencies(dep))
+		g.Expect(err).To(MatchError(ContainSubstring("must be vendored")))
+	})
+}
@@ -25,7 +25,6 @@ import (
 	"github.com/docker/cli/cli/config/credentials"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/tamalsaha/learn-helm-oci/internal/oci"
-	"helm.sh/helm/v3/pkg/registry"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -85,12 +84,12 @@ func LoginOptionFromSecret(registryURL string, secret corev1.Secret) (authn.Keyc
 	return authn.NewKeychainFromHelper(helper{registry: parsedURL.Host, username: username, password: password}), nil
 }
 
-// KeyChainAdaptHelper returns an ORAS credentials callback configured with the authorization data
+// KeychainAdaptHelper returns a callback resolving the authenticator of a registry URL
 // from the given authn keychain. This allows for example to make use of credential helpers from
 // cloud providers.
 // Ref: https://github.com/google/go-containerregistry/tree/main/pkg/authn
-func KeychainAdaptHelper(keyChain authn.Keychain) func(string) (registry.LoginOption, error) {
-	return func(registryURL string) (registry.LoginOption, error) {
+func KeychainAdaptHelper(keyChain authn.Keychain) func(string) (authn.Authenticator, error) {
+	return func(registryURL string) (authn.Authenticator, error) {
 		parsedURL, err := url.Parse(registryURL)
 		if err != nil {
 			return nil, fmt.Errorf("unable to parse registry URL '%s'", registryURL)
@@ -104,11 +103,11 @@ func KeychainAdaptHelper(keyChain authn.Keychain) func(string) (registry.LoginOp
 	}
 }
 
-// AuthAdaptHelper returns an ORAS credentials callback configured with the authorization data
-// from the given authn authenticator. This allows for example to make use of credential helpers from
-// cloud providers.
+// AuthAdaptHelper checks the authorization data of the given authn authenticator and returns
+// the authenticator, or nil if it holds no credentials. This allows for example to make use of
+// credential helpers from cloud providers.
 // Ref: https://github.com/google/go-containerregistry/tree/main/pkg/authn
-func AuthAdaptHelper(auth authn.Authenticator) (registry.LoginOption, error) {
+func AuthAdaptHelper(auth authn.Authenticator) (authn.Authenticator, error) {
 	authConfig, err := auth.Authorization()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get authentication data from OIDC: %w", err)
@@ -123,7 +122,7 @@ func AuthAdaptHelper(auth authn.Authenticator) (registry.LoginOption, error) {
 	case username == "" || password == "":
 		return nil, fmt.Errorf("invalid auth data: required fields 'username' and 'password'")
 	}
-	return registry.LoginOptBasicAuth(username, password), nil
+	return auth, nil
 }
 
 // stringResource is there to satisfy the github.com/google/go-containerregistry/pkg/authn.Resource interface.
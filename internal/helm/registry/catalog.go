@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fluxcd/pkg/oci"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// ErrNotChart means an OCI artifact is not a Helm chart.
+var ErrNotChart = errors.New("artifact is not a Helm chart")
+
+// Catalog returns the repositories of the given registry host, listed with its
+// '_catalog' endpoint, following the pagination of the registry. Registries
+// may not support the endpoint, or restrict it to some credentials.
+func (c *Client) Catalog(ctx context.Context, host string) ([]string, error) {
+	reg, err := name.NewRegistry(host, c.nameOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry host '%s': %w", host, err)
+	}
+	repos, err := remote.Catalog(ctx, reg, c.remoteOptions(reg)...)
+	if err != nil {
+		c.metrics.recordAuthFailure(reg.RegistryStr(), err)
+		return nil, fmt.Errorf("failed to list the repositories of '%s': %w", reg, err)
+	}
+	return repos, nil
+}
+
+// ChartArtifact describes a chart artifact of a registry, read from its
+// manifest and config blob, without downloading its chart layer.
+type ChartArtifact struct {
+	// Metadata of the chart, read from the config blob of the artifact.
+	Metadata *chart.Metadata
+	// ChartDigest is the digest of the chart layer, i.e. of the chart archive.
+	ChartDigest v1.Hash
+	// Created is the creation time of the artifact, read from the
+	// 'org.opencontainers.image.created' annotation of its manifest, if any.
+	Created time.Time
+}
+
+// Metadata returns the metadata of the chart artifact referenced by ref, with
+// or without the 'oci://' prefix, read from the config blob of the artifact,
+// without downloading the chart. It returns an error wrapping ErrNotChart if
+// the artifact is not a Helm chart.
+func (c *Client) Metadata(ctx context.Context, ref string) (*chart.Metadata, error) {
+	a, err := c.Describe(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return a.Metadata, nil
+}
+
+// Describe returns the description of the chart artifact referenced by ref,
+// with or without the 'oci://' prefix, like Metadata does.
+func (c *Client) Describe(ctx context.Context, ref string) (*ChartArtifact, error) {
+	r, err := parseChartReference(ref, c.nameOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	host := r.Context().RegistryStr()
+
+	start := time.Now()
+	img, err := remote.Image(r, append(c.remoteOptions(r.Context().Registry), remote.WithContext(ctx))...)
+	if err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to pull '%s': %w", r, err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to pull '%s': %w", r, err)
+	}
+	c.metrics.ObserveManifestResolution(host, start)
+	if manifest.Config.MediaType != registry.ConfigMediaType {
+		return nil, fmt.Errorf("%w: '%s' has config media type '%s'", ErrNotChart, r, manifest.Config.MediaType)
+	}
+
+	a := &ChartArtifact{}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == registry.ChartLayerMediaType || layer.MediaType == registry.LegacyChartLayerMediaType {
+			a.ChartDigest = layer.Digest
+			break
+		}
+	}
+	if a.ChartDigest == (v1.Hash{}) {
+		return nil, fmt.Errorf("%w: no chart layer found in '%s'", ErrNotChart, r)
+	}
+	if created, ok := manifest.Annotations[oci.CreatedAnnotation]; ok {
+		if a.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return nil, fmt.Errorf("invalid creation time of '%s': %w", r, err)
+		}
+	}
+
+	// The config blob is verified against its digest
+	config, err := img.RawConfigFile()
+	if err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to read config of '%s': %w", r, err)
+	}
+	if err := json.Unmarshal(config, &a.Metadata); err != nil {
+		return nil, fmt.Errorf("invalid chart metadata in config of '%s': %w", r, err)
+	}
+	if a.Metadata == nil {
+		return nil, fmt.Errorf("%w: no chart metadata in config of '%s'", ErrNotChart, r)
+	}
+	return a, nil
+}
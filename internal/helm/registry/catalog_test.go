@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// artifact returns the manifest of an artifact with the given config, of the
+// given media type, layers and annotations, and the config blob by digest.
+func artifact(t *testing.T, configMediaType string, config []byte, layers []v1.Descriptor, annotations map[string]string) ([]byte, map[string][]byte) {
+	t.Helper()
+
+	configDigest, _, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config: v1.Descriptor{
+			MediaType: types.MediaType(configMediaType),
+			Size:      int64(len(config)),
+			Digest:    configDigest,
+		},
+		Layers:      layers,
+		Annotations: annotations,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest, map[string][]byte{configDigest.String(): config}
+}
+
+func TestClient_Catalog(t *testing.T) {
+	g := NewWithT(t)
+
+	var unsupported bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/_catalog" && unsupported:
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/v2/_catalog" && r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/_catalog?last=charts%2Fhello>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": {"charts/hello"}})
+		case r.URL.Path == "/v2/_catalog":
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": {"charts/world", "images/app"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	c := NewClient(ClientOptInsecureHTTP(true))
+	repos, err := c.Catalog(context.TODO(), host)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(repos).To(Equal([]string{"charts/hello", "charts/world", "images/app"}))
+
+	unsupported = true
+	_, err = c.Catalog(context.TODO(), host)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to list the repositories")))
+}
+
+func TestClient_Metadata(t *testing.T) {
+	md := &chart.Metadata{
+		APIVersion:  chart.APIVersionV2,
+		Name:        "hello",
+		Version:     "1.0.0+build",
+		AppVersion:  "v1.2.3",
+		Description: "Hello world",
+		Icon:        "https://example.com/hello.png",
+	}
+	config, err := json.Marshal(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chartLayer := v1.Descriptor{
+		MediaType: registry.ChartLayerMediaType,
+		Size:      5,
+		Digest:    v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)},
+	}
+	chartManifest, blobs := artifact(t, registry.ConfigMediaType, config, []v1.Descriptor{chartLayer},
+		map[string]string{"org.opencontainers.image.created": "2023-08-18T10:00:00Z"})
+	imageManifest, imageBlobs := artifact(t, string(types.OCIConfigJSON), []byte("{}"), []v1.Descriptor{}, nil)
+	for k, v := range imageBlobs {
+		blobs[k] = v
+	}
+	manifests := map[string][]byte{
+		"/v2/charts/hello/manifests/1.0.0_build": chartManifest,
+		"/v2/images/app/manifests/1.0.0":         imageManifest,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if manifest, ok := manifests[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", string(types.OCIManifestSchema1))
+			_, _ = w.Write(manifest)
+			return
+		}
+		if i := strings.Index(r.URL.Path, "/blobs/"); i != -1 {
+			if blob, ok := blobs[r.URL.Path[i+len("/blobs/"):]]; ok {
+				_, _ = w.Write(blob)
+				return
+			}
+		}
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	g := NewWithT(t)
+	c := NewClient(ClientOptInsecureHTTP(true))
+
+	got, err := c.Metadata(context.TODO(), fmt.Sprintf("oci://%s/charts/hello:1.0.0+build", host))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(md))
+
+	a, err := c.Describe(context.TODO(), fmt.Sprintf("%s/charts/hello:1.0.0+build", host))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(a.Metadata).To(Equal(md))
+	g.Expect(a.ChartDigest).To(Equal(chartLayer.Digest))
+	g.Expect(a.Created).To(Equal(time.Date(2023, 8, 18, 10, 0, 0, 0, time.UTC)))
+
+	_, err = c.Metadata(context.TODO(), fmt.Sprintf("%s/images/app:1.0.0", host))
+	g.Expect(errors.Is(err, ErrNotChart)).To(BeTrue())
+
+	_, err = c.Metadata(context.TODO(), fmt.Sprintf("%s/charts/world:1.0.0", host))
+	g.Expect(err).To(HaveOccurred())
+}
@@ -17,44 +17,17 @@ limitations under the License.
 package registry
 
 import (
-	"io"
-	"os"
-
-	"helm.sh/helm/v3/pkg/registry"
-	"k8s.io/apimachinery/pkg/util/errors"
+	"crypto/tls"
 )
 
-// ClientGenerator generates a registry client and a temporary credential file.
+// ClientGenerator generates a registry client configured with the given TLS
+// configuration, if any, which connects to registries over plain HTTP if
+// insecureHTTP is true. The credentials of the client are kept in memory.
 // The client is meant to be used for a single reconciliation.
-// The file is meant to be used for a single reconciliation and deleted after.
-func ClientGenerator(isLogin bool) (*registry.Client, string, error) {
-	if isLogin {
-		// create a temporary file to store the credentials
-		// this is needed because otherwise the credentials are stored in ~/.docker/config.json.
-		credentialsFile, err := os.CreateTemp("", "credentials")
-		if err != nil {
-			return nil, "", err
-		}
-
-		var errs []error
-		rClient, err := registry.NewClient(registry.ClientOptWriter(io.Discard), registry.ClientOptCredentialsFile(credentialsFile.Name()))
-		if err != nil {
-			errs = append(errs, err)
-			// attempt to delete the temporary file
-			if credentialsFile != nil {
-				err := os.Remove(credentialsFile.Name())
-				if err != nil {
-					errs = append(errs, err)
-				}
-			}
-			return nil, "", errors.NewAggregate(errs)
-		}
-		return rClient, credentialsFile.Name(), nil
-	}
-
-	rClient, err := registry.NewClient(registry.ClientOptWriter(io.Discard))
-	if err != nil {
-		return nil, "", err
-	}
-	return rClient, "", nil
+// The given options are applied after the TLS and HTTP options.
+func ClientGenerator(tlsConfig *tls.Config, insecureHTTP bool, opts ...ClientOption) (*Client, error) {
+	return NewClient(append([]ClientOption{
+		ClientOptTLSConfig(tlsConfig),
+		ClientOptInsecureHTTP(insecureHTTP),
+	}, opts...)...), nil
 }
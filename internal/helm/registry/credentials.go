@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// CredentialStore is an in-memory store of the credentials of registry hosts.
+// Unlike a Docker config file, it never writes the credentials to disk, and it
+// keeps the authenticators as they are resolved, e.g. from a cloud provider,
+// so that tokens are refreshed by their authenticator.
+// It is safe for concurrent use.
+type CredentialStore struct {
+	mu    sync.RWMutex
+	auths map[string]authn.Authenticator
+}
+
+// NewCredentialStore returns an empty CredentialStore.
+func NewCredentialStore() *CredentialStore {
+	return &CredentialStore{auths: map[string]authn.Authenticator{}}
+}
+
+// Set stores the authenticator of the given registry host, replacing the
+// previous one, if any.
+func (s *CredentialStore) Set(host string, auth authn.Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auths[host] = auth
+}
+
+// Get returns the authenticator of the given registry host, or
+// authn.Anonymous if the store holds no credentials for the host.
+func (s *CredentialStore) Get(host string) authn.Authenticator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if auth, ok := s.auths[host]; ok {
+		return auth
+	}
+	return authn.Anonymous
+}
+
+// Delete removes the authenticator of the given registry host.
+func (s *CredentialStore) Delete(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.auths, host)
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrDigestMismatch means downloaded content does not match the digest of its descriptor.
+var ErrDigestMismatch = errors.New("content does not match its digest")
+
+// ReadLayer reads the content of the given layer, up to maxSize bytes if
+// maxSize is positive, and verifies it against the digest of the layer
+// descriptor. A content not matching the digest is returned as an error
+// wrapping ErrDigestMismatch.
+func ReadLayer(layer v1.Layer, maxSize int64) ([]byte, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r := io.Reader(rc)
+	if maxSize > 0 {
+		r = io.LimitReader(rc, maxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("size of layer exceeds maximum of %d bytes", maxSize)
+	}
+	// Remote layers are verified as they are read, and fail to read once
+	// complete if they do not match their digest
+	if size, serr := layer.Size(); err == nil || (serr == nil && size == int64(len(data))) {
+		if err := verifyDigest(digest, data); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyDigest returns an error wrapping ErrDigestMismatch if the sha256
+// digest of data is not the given digest.
+func verifyDigest(want v1.Hash, data []byte) error {
+	if want.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm '%s'", want.Algorithm)
+	}
+	got, _, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: got %s, expected %s", ErrDigestMismatch, got, want)
+	}
+	return nil
+}
+
+// SplitDigest splits a chart version pinned to a manifest digest, of the form
+// 'tag@sha256:...' or 'sha256:...', into its tag, which may be empty, and its
+// digest. It returns false if the version is not pinned to a digest.
+func SplitDigest(version string) (tag, digest string, ok bool) {
+	if tag, digest, ok := strings.Cut(version, "@"); ok {
+		return tag, digest, true
+	}
+	if strings.HasPrefix(version, "sha256:") {
+		return "", version, true
+	}
+	return "", "", false
+}
+
+// PinnedReference returns the reference of the given tag of a repository pinned
+// to the given manifest digest, i.e. 'repo:tag@digest', or 'repo@digest' if the
+// tag is empty. As '+' is not allowed in OCI tags, it is written as '_' in the
+// tag, like Helm does.
+func PinnedReference(repo, tag, digest string) string {
+	if tag == "" {
+		return fmt.Sprintf("%s@%s", repo, digest)
+	}
+	return fmt.Sprintf("%s:%s@%s", repo, strings.ReplaceAll(tag, "+", "_"), digest)
+}
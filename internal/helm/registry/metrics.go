@@ -0,0 +1,186 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// VerificationResultVerified is the result of a verified chart signature.
+	VerificationResultVerified = "verified"
+	// VerificationResultFailed is the result of a chart signature which
+	// could not be verified.
+	VerificationResultFailed = "failed"
+
+	// OperationListTags is the registry operation listing the tags of a repository.
+	OperationListTags = "list_tags"
+	// OperationResolve is the registry operation resolving the manifest digest of a chart.
+	OperationResolve = "resolve"
+	// OperationDownload is the registry operation downloading a chart.
+	OperationDownload = "download"
+	// OperationCatalog is the registry operation listing the repositories of a registry.
+	OperationCatalog = "catalog"
+	// OperationMetadata is the registry operation reading the metadata of a chart.
+	OperationMetadata = "metadata"
+	// OperationPush is the registry operation pushing a chart.
+	OperationPush = "push"
+)
+
+// MetricsRecorder is a recorder for the operations on registries, i.e. the
+// resolution and the download of charts. All metrics are labeled by registry
+// host. A nil MetricsRecorder records nothing.
+type MetricsRecorder struct {
+	tagListDuration            *prometheus.HistogramVec
+	manifestResolutionDuration *prometheus.HistogramVec
+	downloadDuration           *prometheus.HistogramVec
+	downloadSize               *prometheus.HistogramVec
+	authFailuresCounter        *prometheus.CounterVec
+	verificationsCounter       *prometheus.CounterVec
+	retriesCounter             *prometheus.CounterVec
+}
+
+// NewMetricsRecorder returns a new MetricsRecorder.
+// The configured labels are: host, and additionally:
+//   - result, for verifications: "verified" or "failed"
+//   - operation, for retries: "list_tags", "resolve", "download", "catalog", "metadata" or "push"
+func NewMetricsRecorder() *MetricsRecorder {
+	return &MetricsRecorder{
+		tagListDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "chartloader_tag_list_duration_seconds",
+				Help:    "The duration in seconds of listing the tags of a chart repository.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"host"},
+		),
+		manifestResolutionDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "chartloader_manifest_resolution_duration_seconds",
+				Help:    "The duration in seconds of resolving the manifest of a chart artifact.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"host"},
+		),
+		downloadDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "chartloader_download_duration_seconds",
+				Help:    "The duration in seconds of downloading a chart.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"host"},
+		),
+		downloadSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "chartloader_download_size_bytes",
+				Help:    "The size in bytes of the downloaded charts.",
+				Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+			},
+			[]string{"host"},
+		),
+		authFailuresCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "chartloader_auth_failures_total",
+				Help: "Total number of registry requests rejected as unauthorized or forbidden.",
+			},
+			[]string{"host"},
+		),
+		verificationsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "chartloader_verifications_total",
+				Help: "Total number of chart signature verifications, by result.",
+			},
+			[]string{"host", "result"},
+		),
+		retriesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "chartloader_retries_total",
+				Help: "Total number of retried registry operations, by operation.",
+			},
+			[]string{"host", "operation"},
+		),
+	}
+}
+
+// Collectors returns the metrics.Collector objects for the MetricsRecorder.
+func (r *MetricsRecorder) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.tagListDuration,
+		r.manifestResolutionDuration,
+		r.downloadDuration,
+		r.downloadSize,
+		r.authFailuresCounter,
+		r.verificationsCounter,
+		r.retriesCounter,
+	}
+}
+
+// ObserveTagList records the duration of listing the tags of a repository of the given host.
+func (r *MetricsRecorder) ObserveTagList(host string, start time.Time) {
+	if r == nil {
+		return
+	}
+	r.tagListDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+}
+
+// ObserveManifestResolution records the duration of resolving a manifest of the given host.
+func (r *MetricsRecorder) ObserveManifestResolution(host string, start time.Time) {
+	if r == nil {
+		return
+	}
+	r.manifestResolutionDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDownload records the duration and the size of downloading a chart of the given host.
+func (r *MetricsRecorder) ObserveDownload(host string, start time.Time, size int) {
+	if r == nil {
+		return
+	}
+	r.downloadDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+	r.downloadSize.WithLabelValues(host).Observe(float64(size))
+}
+
+// IncAuthFailures increments by 1 the auth failure count of the given host.
+func (r *MetricsRecorder) IncAuthFailures(host string) {
+	if r == nil {
+		return
+	}
+	r.authFailuresCounter.WithLabelValues(host).Inc()
+}
+
+// IncVerifications increments by 1 the verification count of the given host and result.
+func (r *MetricsRecorder) IncVerifications(host, result string) {
+	if r == nil {
+		return
+	}
+	r.verificationsCounter.WithLabelValues(host, result).Inc()
+}
+
+// IncRetries increments by 1 the retry count of the given host and operation.
+func (r *MetricsRecorder) IncRetries(host, operation string) {
+	if r == nil {
+		return
+	}
+	r.retriesCounter.WithLabelValues(host, operation).Inc()
+}
+
+// MustMakeMetrics creates a new MetricsRecorder, and registers the metrics collectors in the controller-runtime metrics registry.
+func MustMakeMetrics() *MetricsRecorder {
+	r := NewMetricsRecorder()
+	metrics.Registry.MustRegister(r.Collectors()...)
+
+	return r
+}
+
+// recordAuthFailure increments the auth failure count of the given host if
+// err is a registry response rejecting the credentials of the request.
+func (r *MetricsRecorder) recordAuthFailure(host string, err error) {
+	var terr *transport.Error
+	if errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden) {
+		r.IncAuthFailures(host)
+	}
+}
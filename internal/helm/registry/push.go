@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// PushOptions configures the chart artifact pushed by Client.Push.
+type PushOptions struct {
+	// Provenance is the provenance file of the chart archive, pushed as the
+	// provenance layer of the artifact, if set.
+	Provenance []byte
+	// Annotations are the annotations of the manifest of the artifact, e.g.
+	// its source, revision and creation time.
+	Annotations map[string]string
+}
+
+// PushResult describes a chart artifact pushed by Client.Push.
+type PushResult struct {
+	// Ref is the reference of the artifact, i.e. 'repo/name:version', with
+	// the '+' of the semantic version of the chart translated to '_'.
+	Ref string
+	// Digest is the digest of the manifest of the artifact.
+	Digest string
+	// Metadata of the chart, read from the chart archive.
+	Metadata *chart.Metadata
+}
+
+// Push uploads the given chart archive to the given repository, with or
+// without the 'oci://' prefix, as the artifact 'repo/name:version' with the
+// media types of Helm, like 'helm push' does. The config blob of the artifact
+// is the metadata of the chart, read from the archive.
+func (c *Client) Push(ctx context.Context, repo string, archive []byte, opts PushOptions) (*PushResult, error) {
+	ch, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart archive: %w", err)
+	}
+	md := ch.Metadata
+	ref, err := parseChartReference(fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(repo, "/"), md.Name, md.Version), c.nameOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	host := ref.Context().RegistryStr()
+
+	config, err := json.Marshal(md)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata of chart '%s': %w", md.Name, err)
+	}
+	configBlob := newBlob(registry.ConfigMediaType, config)
+	blobs := []*blob{newBlob(registry.ChartLayerMediaType, archive)}
+	if len(opts.Provenance) > 0 {
+		blobs = append(blobs, newBlob(registry.ProvLayerMediaType, opts.Provenance))
+	}
+
+	ropts := append(c.remoteOptions(ref.Context().Registry), remote.WithContext(ctx))
+	layers := make([]v1.Descriptor, 0, len(blobs))
+	for _, b := range append([]*blob{configBlob}, blobs...) {
+		if err := remote.WriteLayer(ref.Context(), b, ropts...); err != nil {
+			c.metrics.recordAuthFailure(host, err)
+			return nil, fmt.Errorf("failed to upload %s blob to '%s': %w", b.mediaType, ref.Context(), err)
+		}
+		if b != configBlob {
+			layers = append(layers, b.descriptor())
+		}
+	}
+
+	m := &rawManifest{}
+	m.content, err = json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        configBlob.descriptor(),
+		Layers:        layers,
+		Annotations:   opts.Annotations,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest of '%s': %w", ref, err)
+	}
+	if err := remote.Put(ref, m, ropts...); err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to push '%s': %w", ref, err)
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(m.content))
+	if err != nil {
+		return nil, err
+	}
+	return &PushResult{Ref: ref.String(), Digest: digest.String(), Metadata: md}, nil
+}
+
+// blob is a v1.Layer of the given content, uploaded as is, e.g. a config blob
+// or a chart archive, which is already compressed.
+type blob struct {
+	mediaType types.MediaType
+	content   []byte
+	digest    v1.Hash
+}
+
+// newBlob returns the blob of the given media type and content.
+func newBlob(mediaType string, content []byte) *blob {
+	// Hashing an in-memory reader does not fail
+	digest, _, _ := v1.SHA256(bytes.NewReader(content))
+	return &blob{mediaType: types.MediaType(mediaType), content: content, digest: digest}
+}
+
+// descriptor returns the descriptor of the blob in a manifest.
+func (b *blob) descriptor() v1.Descriptor {
+	return v1.Descriptor{MediaType: b.mediaType, Size: int64(len(b.content)), Digest: b.digest}
+}
+
+func (b *blob) Digest() (v1.Hash, error) { return b.digest, nil }
+
+func (b *blob) DiffID() (v1.Hash, error) { return b.digest, nil }
+
+func (b *blob) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(b.content)), nil
+}
+
+func (b *blob) Uncompressed() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(b.content)), nil
+}
+
+func (b *blob) Size() (int64, error) { return int64(len(b.content)), nil }
+
+func (b *blob) MediaType() (types.MediaType, error) { return b.mediaType, nil }
+
+// rawManifest is a remote.Taggable OCI image manifest.
+type rawManifest struct {
+	content []byte
+}
+
+func (m *rawManifest) RawManifest() ([]byte, error) { return m.content, nil }
+
+func (m *rawManifest) MediaType() (types.MediaType, error) { return types.OCIManifestSchema1, nil }
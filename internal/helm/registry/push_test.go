@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm"
+)
+
+// pushRegistry is an in-memory registry handler storing the pushed blobs and
+// manifests, by digest and by path.
+type pushRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	manifests map[string][]byte
+}
+
+func (reg *pushRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasSuffix(r.URL.Path, "/blobs/uploads/") && r.Method == http.MethodPost:
+		id := fmt.Sprintf("/upload/%d", len(reg.uploads))
+		reg.uploads[id] = nil
+		w.Header().Set("Location", id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(r.URL.Path, "/upload/") && r.Method == http.MethodPatch:
+		reg.uploads[r.URL.Path] = append(reg.uploads[r.URL.Path], body...)
+		w.Header().Set("Location", r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(r.URL.Path, "/upload/") && r.Method == http.MethodPut:
+		reg.blobs[r.URL.Query().Get("digest")] = append(reg.uploads[r.URL.Path], body...)
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(r.URL.Path, "/blobs/"):
+		blob, ok := reg.blobs[r.URL.Path[strings.Index(r.URL.Path, "/blobs/")+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+			return
+		}
+		_, _ = w.Write(blob)
+	case strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodPut:
+		reg.manifests[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(r.URL.Path, "/manifests/"):
+		manifest, ok := reg.manifests[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		_, _ = w.Write(manifest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// chartArchive returns the archive of a chart with the given metadata.
+func chartArchive(t *testing.T, md *chart.Metadata) []byte {
+	t.Helper()
+
+	path, err := chartutil.Save(&chart.Chart{Metadata: md}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestClient_Push(t *testing.T) {
+	g := NewWithT(t)
+
+	reg := &pushRegistry{blobs: map[string][]byte{}, uploads: map[string][]byte{}, manifests: map[string][]byte{}}
+	server := httptest.NewServer(reg)
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	md := &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "1.0.0+build", Description: "Hello world"}
+	archive := chartArchive(t, md)
+	prov := []byte("-----BEGIN PGP SIGNED MESSAGE-----\n")
+	c := NewClient(ClientOptInsecureHTTP(true))
+
+	res, err := c.Push(context.TODO(), fmt.Sprintf("oci://%s/charts/", host), archive, PushOptions{
+		Provenance:  prov,
+		Annotations: map[string]string{"org.opencontainers.image.created": "2023-08-18T10:00:00Z"},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(res.Ref).To(Equal(fmt.Sprintf("%s/charts/hello:1.0.0_build", host)))
+	g.Expect(res.Metadata.Name).To(Equal("hello"))
+
+	manifest := reg.manifests["/v2/charts/hello/manifests/1.0.0_build"]
+	digest, _, err := v1.SHA256(bytes.NewReader(manifest))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(res.Digest).To(Equal(digest.String()))
+
+	var m v1.Manifest
+	g.Expect(json.Unmarshal(manifest, &m)).To(Succeed())
+	g.Expect(string(m.Config.MediaType)).To(Equal(registry.ConfigMediaType))
+	g.Expect(m.Layers).To(HaveLen(2))
+	g.Expect(string(m.Layers[0].MediaType)).To(Equal(registry.ChartLayerMediaType))
+	g.Expect(string(m.Layers[1].MediaType)).To(Equal(registry.ProvLayerMediaType))
+	g.Expect(reg.blobs[m.Layers[1].Digest.String()]).To(Equal(prov))
+
+	a, err := c.Describe(context.TODO(), res.Ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(a.Metadata.Description).To(Equal("Hello world"))
+	g.Expect(a.Created).To(Equal(time.Date(2023, 8, 18, 10, 0, 0, 0, time.UTC)))
+	b, err := c.Get(res.Ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(b.Bytes()).To(Equal(archive))
+
+	// Chart layers larger than the maximum chart size are not read
+	maxChartSize := helm.MaxChartSize
+	helm.MaxChartSize = int64(len(archive) - 1)
+	t.Cleanup(func() {
+		helm.MaxChartSize = maxChartSize
+	})
+	_, err = c.Get(res.Ref)
+	g.Expect(err).To(MatchError(ContainSubstring("exceeds maximum")))
+
+	_, err = c.Push(context.TODO(), host, []byte("not a chart"), PushOptions{})
+	g.Expect(err).To(MatchError(ContainSubstring("invalid chart archive")))
+}
@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/oci"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm"
+)
+
+// Client is a client for the Helm charts stored in OCI registries.
+// Unlike the Helm registry client, all its requests, i.e. tag listing and
+// chart downloads, share the same transport, so they are subject to the
+// same TLS configuration. Client implements getter.Getter, to be used as the
+// getter of an OCIChartRepository.
+// The credentials of the registries are kept in memory, in the CredentialStore
+// of the client, and are sent with the requests to their registry host, without
+// a prior login request.
+// Failed requests are not retried on error status codes; responses asking to
+// retry later are returned as RetryAfterErrors.
+type Client struct {
+	// credentials holds the authenticators of the registry hosts.
+	credentials *CredentialStore
+	tlsConfig   *tls.Config
+	// insecureHTTP allows connecting to registries over plain HTTP.
+	insecureHTTP bool
+	transport    http.RoundTripper
+	// metrics records the durations of the requests, if set.
+	metrics *MetricsRecorder
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// ClientOptCredentialStore sets the store of the credentials of the registries,
+// e.g. to share them between clients. By default, each client has its own store.
+func ClientOptCredentialStore(store *CredentialStore) ClientOption {
+	return func(c *Client) {
+		c.credentials = store
+	}
+}
+
+// ClientOptTLSConfig sets the TLS configuration used to connect to registries,
+// e.g. a custom CA or a client certificate. A nil config keeps the defaults.
+func ClientOptTLSConfig(tlsConfig *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// ClientOptInsecureHTTP allows connecting to registries over plain HTTP,
+// if they do not serve HTTPS. This should only be used for local and
+// development registries.
+func ClientOptInsecureHTTP(insecure bool) ClientOption {
+	return func(c *Client) {
+		c.insecureHTTP = insecure
+	}
+}
+
+// ClientOptMetricsRecorder sets the recorder of the metrics of the tag
+// listings and chart downloads of the client.
+func ClientOptMetricsRecorder(recorder *MetricsRecorder) ClientOption {
+	return func(c *Client) {
+		c.metrics = recorder
+	}
+}
+
+// NewClient returns a Client configured with the given options.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.credentials == nil {
+		c.credentials = NewCredentialStore()
+	}
+
+	t := remote.DefaultTransport
+	if c.tlsConfig != nil {
+		tt := remote.DefaultTransport.(*http.Transport).Clone()
+		tt.TLSClientConfig = c.tlsConfig
+		t = tt
+	}
+	c.transport = &retryAfterTransport{inner: t}
+	return c
+}
+
+// SetCredentials stores the authenticator of the given registry host, which
+// authenticates the subsequent requests of the client to the host. The
+// credentials are not checked against the registry; invalid credentials make
+// the requests fail as unauthorized.
+func (c *Client) SetCredentials(host string, auth authn.Authenticator) error {
+	reg, err := name.NewRegistry(host, c.nameOptions()...)
+	if err != nil {
+		return fmt.Errorf("invalid registry host '%s': %w", host, err)
+	}
+	c.credentials.Set(reg.RegistryStr(), auth)
+	return nil
+}
+
+// RemoveCredentials removes the credentials of the given registry host.
+func (c *Client) RemoveCredentials(host string) {
+	if reg, err := name.NewRegistry(host, c.nameOptions()...); err == nil {
+		host = reg.RegistryStr()
+	}
+	c.credentials.Delete(host)
+}
+
+// Tags returns the tags of the given repository reference which are semantic
+// versions, sorted in descending order. As '+' is not allowed in OCI tags,
+// '_' in tags is read as '+', like Helm does.
+func (c *Client) Tags(ctx context.Context, ref string) ([]string, error) {
+	list, _, err := c.ListTags(ctx, ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	return SemverTags(list.Tags), nil
+}
+
+// Resolve returns the digest of the manifest of the given chart reference,
+// with or without the 'oci://' prefix, e.g. to pin a tag to the artifact it
+// currently references. The requests are bound to the given context.
+func (c *Client) Resolve(ctx context.Context, ref string) (string, error) {
+	r, err := parseChartReference(ref, c.nameOptions()...)
+	if err != nil {
+		return "", err
+	}
+	host := r.Context().RegistryStr()
+	start := time.Now()
+	opts := append(c.remoteOptions(r.Context().Registry), remote.WithContext(ctx))
+	desc, err := remote.Head(r, opts...)
+	if err != nil {
+		// Registries may not support HEAD requests for manifests
+		var d *remote.Descriptor
+		if d, err = remote.Get(r, opts...); err == nil {
+			desc = &d.Descriptor
+		}
+	}
+	if err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return "", fmt.Errorf("failed to resolve '%s': %w", r, err)
+	}
+	c.metrics.ObserveManifestResolution(host, start)
+	return desc.Digest.String(), nil
+}
+
+// Get downloads the chart layer of the chart artifact referenced by href,
+// with or without the 'oci://' prefix. The getter options are ignored, as
+// the client is configured with its own transport. As the requests of Get
+// are not bound to a context, GetContext is preferred.
+func (c *Client) Get(href string, _ ...getter.Option) (*bytes.Buffer, error) {
+	return c.GetContext(context.Background(), href)
+}
+
+// GetContext is like Get, but the requests are bound to the given context,
+// and the manifest fetch and the layer download are traced as children of
+// the span of the context, if any.
+func (c *Client) GetContext(ctx context.Context, href string) (*bytes.Buffer, error) {
+	ref, err := parseChartReference(href, c.nameOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	host := ref.Context().RegistryStr()
+
+	manifestCtx, span := StartSpan(ctx, "FetchManifest", AttributeRegistryHost.String(host), AttributeChart.String(ref.Context().RepositoryStr()))
+	start := time.Now()
+	img, err := remote.Image(ref, append(c.remoteOptions(ref.Context().Registry), remote.WithContext(manifestCtx))...)
+	if err != nil {
+		EndSpan(span, err)
+		c.metrics.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to pull '%s': %w", ref, err)
+	}
+	c.metrics.ObserveManifestResolution(host, start)
+	if digest, err := img.Digest(); err == nil {
+		span.SetAttributes(AttributeDigest.String(digest.String()))
+	}
+	EndSpan(span, nil)
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers of '%s': %w", ref, err)
+	}
+	for _, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mt != registry.ChartLayerMediaType && mt != registry.LegacyChartLayerMediaType {
+			continue
+		}
+		_, span := StartSpan(ctx, "DownloadBlob", AttributeRegistryHost.String(host))
+		b, err := c.readLayer(host, layer)
+		EndSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chart layer of '%s': %w", ref, err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("no chart layer found in '%s'", ref)
+}
+
+// readLayer downloads the given layer of an artifact of the given registry
+// host, which must not exceed helm.MaxChartSize, and verifies it against the
+// digest of its descriptor.
+func (c *Client) readLayer(host string, layer v1.Layer) (*bytes.Buffer, error) {
+	start := time.Now()
+	data, err := ReadLayer(layer, helm.MaxChartSize)
+	if err != nil {
+		c.metrics.recordAuthFailure(host, err)
+		return nil, err
+	}
+	c.metrics.ObserveDownload(host, start, len(data))
+	return bytes.NewBuffer(data), nil
+}
+
+// parseChartReference parses the given chart reference, translating the '+'
+// of the semantic version in its tag to '_'. A reference pinned to a digest,
+// i.e. 'repo:tag@digest', references the digest.
+func parseChartReference(href string, opts ...name.Option) (name.Reference, error) {
+	s, digest, pinned := strings.Cut(strings.TrimPrefix(href, fmt.Sprintf("%s://", registry.OCIScheme)), "@")
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		s = s[:i] + strings.ReplaceAll(s[i:], "+", "_")
+	}
+	if pinned {
+		s = fmt.Sprintf("%s@%s", s, digest)
+	}
+	ref, err := name.ParseReference(s, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart reference '%s': %w", href, err)
+	}
+	return ref, nil
+}
+
+// nameOptions returns the options to parse the references of the client.
+func (c *Client) nameOptions() []name.Option {
+	if c.insecureHTTP {
+		return []name.Option{name.Insecure}
+	}
+	return nil
+}
+
+// remoteOptions returns the options to send requests to the given registry,
+// authenticated with its credentials, if any.
+func (c *Client) remoteOptions(reg name.Registry) []remote.Option {
+	return []remote.Option{
+		remote.WithTransport(c.transport),
+		remote.WithAuth(c.credentials.Get(reg.RegistryStr())),
+		remote.WithUserAgent(oci.UserAgent),
+		// Failed requests are retried by the callers of the client,
+		// according to their retry policy
+		remote.WithRetryStatusCodes(),
+		remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
+	}
+}
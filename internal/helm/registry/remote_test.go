@@ -0,0 +1,265 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// newTLSRegistryServer returns a TLS registry serving the chart artifact
+// 'charts/hello:1.0.0+build', and the content of its chart layer. The
+// registry requires the given credentials if username is not empty.
+func newTLSRegistryServer(t *testing.T, username, password string) (*httptest.Server, []byte) {
+	t.Helper()
+
+	layer, err := tarball.LayerFromReader(bytes.NewReader([]byte("chart")), tarball.WithMediaType(registry.ChartLayerMediaType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.Append(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mutate.Addendum{
+		Layer:     layer,
+		MediaType: registry.ChartLayerMediaType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.ConfigMediaType(img, registry.ConfigMediaType)
+	manifest, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDigest, err := layer.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := map[v1.Hash][]byte{configName: config, layerDigest: data}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); username != "" && (!ok || u != username || p != password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/charts/hello/")
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case path == "tags/list":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"name": "charts/hello",
+				"tags": []string{"0.1.0", "1.0.0_build", "latest"},
+			})
+		case path == "manifests/1.0.0_build" || path == "manifests/"+manifestDigest.String():
+			w.Header().Set("Content-Type", string(types.OCIManifestSchema1))
+			_, _ = w.Write(manifest)
+		case strings.HasPrefix(path, "blobs/"):
+			h, err := v1.NewHash(strings.TrimPrefix(path, "blobs/"))
+			if blob, ok := blobs[h]; err == nil && ok {
+				_, _ = w.Write(blob)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, data
+}
+
+func TestClient_TLS(t *testing.T) {
+	server, data := newTLSRegistryServer(t, "", "")
+	host := strings.TrimPrefix(server.URL, "https://")
+	ref := fmt.Sprintf("%s/charts/hello", host)
+
+	t.Run("unknown authority", func(t *testing.T) {
+		g := NewWithT(t)
+		c := NewClient()
+		_, err := c.Tags(context.TODO(), ref)
+		g.Expect(err).To(MatchError(ContainSubstring("certificate")))
+		_, err = c.Get("oci://" + ref + ":1.0.0+build")
+		g.Expect(err).To(MatchError(ContainSubstring("certificate")))
+	})
+
+	t.Run("skip TLS verification", func(t *testing.T) {
+		g := NewWithT(t)
+		c := NewClient(ClientOptTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+		tags, err := c.Tags(context.TODO(), ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tags).To(HaveLen(2))
+	})
+
+	t.Run("custom CA", func(t *testing.T) {
+		g := NewWithT(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+		c := NewClient(ClientOptTLSConfig(&tls.Config{RootCAs: pool}))
+
+		tags, err := c.Tags(context.TODO(), ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tags).To(Equal([]string{"1.0.0+build", "0.1.0"}))
+
+		b, err := c.Get("oci://" + ref + ":1.0.0+build")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(b.Bytes()).To(Equal(data))
+
+		digest, err := c.Resolve(context.TODO(), "oci://"+ref+":1.0.0+build")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(HavePrefix("sha256:"))
+		b, err = c.Get("oci://" + ref + ":1.0.0+build@" + digest)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(b.Bytes()).To(Equal(data))
+	})
+}
+
+func TestClient_Credentials(t *testing.T) {
+	g := NewWithT(t)
+
+	server, data := newTLSRegistryServer(t, "user", "pass")
+	host := strings.TrimPrefix(server.URL, "https://")
+	ref := fmt.Sprintf("%s/charts/hello", host)
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	c := NewClient(ClientOptTLSConfig(&tls.Config{RootCAs: pool}))
+
+	_, err := c.Tags(context.TODO(), ref)
+	g.Expect(err).To(HaveOccurred())
+
+	// Invalid credentials are only rejected by the requests to the registry
+	g.Expect(c.SetCredentials(host, &authn.Basic{Username: "user", Password: "wrong"})).To(Succeed())
+	_, err = c.Tags(context.TODO(), ref)
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(c.SetCredentials(host, &authn.Basic{Username: "user", Password: "pass"})).To(Succeed())
+	tags, err := c.Tags(context.TODO(), ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveLen(2))
+	b, err := c.Get(ref + ":1.0.0+build")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(b.Bytes()).To(Equal(data))
+
+	c.RemoveCredentials(host)
+	_, err = c.Tags(context.TODO(), ref)
+	g.Expect(err).To(HaveOccurred())
+
+	// Clients sharing a credential store share the credentials
+	store := NewCredentialStore()
+	g.Expect(NewClient(ClientOptCredentialStore(store)).SetCredentials(host, &authn.Basic{Username: "user", Password: "pass"})).To(Succeed())
+	tags, err = NewClient(ClientOptTLSConfig(&tls.Config{RootCAs: pool}), ClientOptCredentialStore(store)).Tags(context.TODO(), ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveLen(2))
+}
+
+func TestClient_ListTags(t *testing.T) {
+	g := NewWithT(t)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/charts/hello/tags/list":
+			requests++
+			if r.URL.Query().Get("last") == "0.1.0" {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"0.2.0", "latest"}})
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"1"`)
+			w.Header().Set("Link", `</v2/charts/hello/tags/list?n=1&last=0.1.0>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{"0.1.0"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	ref := fmt.Sprintf("%s/charts/hello", strings.TrimPrefix(server.URL, "http://"))
+	c := NewClient(ClientOptInsecureHTTP(true))
+
+	// All pages are listed
+	list, modified, err := c.ListTags(context.TODO(), ref, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(modified).To(BeTrue())
+	g.Expect(list.Tags).To(Equal([]string{"0.1.0", "0.2.0", "latest"}))
+	g.Expect(list.ETag).To(Equal(`"1"`))
+	g.Expect(requests).To(Equal(2))
+
+	// An unchanged listing is revalidated with a conditional request
+	revalidated, modified, err := c.ListTags(context.TODO(), ref, list)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(modified).To(BeFalse())
+	g.Expect(revalidated).To(Equal(list))
+	g.Expect(requests).To(Equal(3))
+
+	// A changed listing is listed again
+	updated, modified, err := c.ListTags(context.TODO(), ref, &TagList{Tags: []string{"0.1.0"}, ETag: `"0"`})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(modified).To(BeTrue())
+	g.Expect(updated).To(Equal(list))
+
+	tags, err := c.Tags(context.TODO(), ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"0.2.0", "0.1.0"}))
+}
+
+func Test_parseChartReference(t *testing.T) {
+	g := NewWithT(t)
+
+	ref, err := parseChartReference("oci://example.com/charts/hello:1.0.0+build")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ref.Identifier()).To(Equal("1.0.0_build"))
+	g.Expect(ref.Context().Registry.Scheme()).To(Equal("https"))
+
+	c := NewClient(ClientOptInsecureHTTP(true))
+	ref, err = parseChartReference("example.com/charts/hello@sha256:"+strings.Repeat("a", 64), c.nameOptions()...)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ref.Context().Registry.Scheme()).To(Equal("http"))
+
+	ref, err = parseChartReference("oci://example.com/charts/hello:1.0.0+build@sha256:" + strings.Repeat("a", 64))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ref.Identifier()).To(Equal("sha256:" + strings.Repeat("a", 64)))
+
+	_, err = parseChartReference("oci://example.com/charts/Hello:1.0.0")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfterError is returned when a registry responds with a 'Retry-After'
+// header, e.g. with '429 Too Many Requests' or '503 Service Unavailable',
+// asking the client to retry the request later.
+type RetryAfterError struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// RetryAfter is the delay requested by the registry.
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("registry responded with status %d %s, retry after %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.RetryAfter)
+}
+
+// retryAfterTransport turns the responses asking to retry the request later
+// into RetryAfterErrors, as the registry errors of go-containerregistry do
+// not keep the response headers.
+type retryAfterTransport struct {
+	inner http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, nil
+	}
+	d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	return nil, &RetryAfterError{StatusCode: resp.StatusCode, RetryAfter: d}
+}
+
+// parseRetryAfter parses the value of a 'Retry-After' header, which is either
+// a number of seconds or an HTTP date, relative to now.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: now.Add(30 * time.Second).Format(http.TimeFormat), want: 30 * time.Second, wantOK: true},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.value, func(t *testing.T) {
+			g := NewWithT(t)
+			got, ok := parseRetryAfter(tt.value, now)
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	g := NewWithT(t)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+
+	ref := fmt.Sprintf("%s/charts/hello", strings.TrimPrefix(server.URL, "http://"))
+	_, err := NewClient().Tags(context.TODO(), ref)
+	var retryAfter *RetryAfterError
+	g.Expect(errors.As(err, &retryAfter)).To(BeTrue())
+	g.Expect(retryAfter.StatusCode).To(Equal(http.StatusTooManyRequests))
+	g.Expect(retryAfter.RetryAfter).To(Equal(7 * time.Second))
+	// The request is not retried by the client itself
+	g.Expect(requests).To(Equal(2))
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/oci"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// TagList is a listing of the tags of a repository, with the validators of the
+// registry response, to revalidate it with a conditional request.
+type TagList struct {
+	// Tags are the tags of the repository, in the order of the registry.
+	Tags []string
+	// ETag is the entity tag of the listing, if the registry returned one.
+	ETag string
+	// LastModified is the modification time of the listing, if the registry returned one.
+	LastModified string
+}
+
+// ListTags lists all tags of the given repository reference, following the
+// pagination of the registry. If prev, a previous listing of the repository,
+// has validators, the listing is revalidated with a conditional request, and
+// prev is returned with modified false if the registry reports that the tags
+// did not change.
+func (c *Client) ListTags(ctx context.Context, ref string, prev *TagList) (list *TagList, modified bool, err error) {
+	repo, err := name.NewRepository(ref, c.nameOptions()...)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid repository reference '%s': %w", ref, err)
+	}
+	start := time.Now()
+	list, modified, err = c.listTags(ctx, repo, prev)
+	if err != nil {
+		c.metrics.recordAuthFailure(repo.RegistryStr(), err)
+		return nil, false, err
+	}
+	c.metrics.ObserveTagList(repo.RegistryStr(), start)
+	return list, modified, nil
+}
+
+func (c *Client) listTags(ctx context.Context, repo name.Repository, prev *TagList) (*TagList, bool, error) {
+	t, err := transport.NewWithContext(ctx, repo.Registry, c.credentials.Get(repo.RegistryStr()),
+		transport.NewUserAgent(c.transport, oci.UserAgent), []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, false, err
+	}
+	client := &http.Client{Transport: t}
+
+	list := &TagList{}
+	next := &url.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/tags/list", repo.RepositoryStr()),
+	}
+	for first := true; next != nil; first = false {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next.String(), nil)
+		if err != nil {
+			return nil, false, err
+		}
+		// The validators of the listing are those of its first page
+		if first && prev != nil {
+			if prev.ETag != "" {
+				req.Header.Set("If-None-Match", prev.ETag)
+			}
+			if prev.LastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.LastModified)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+		if first && prev != nil && resp.StatusCode == http.StatusNotModified {
+			resp.Body.Close()
+			return prev, false, nil
+		}
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, false, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode tags of '%s': %w", repo, err)
+		}
+		if first {
+			list.ETag = resp.Header.Get("ETag")
+			list.LastModified = resp.Header.Get("Last-Modified")
+		}
+		list.Tags = append(list.Tags, page.Tags...)
+
+		if next, err = nextPage(resp); err != nil {
+			return nil, false, err
+		}
+	}
+	return list, true, nil
+}
+
+// nextPage returns the URL of the next page of a paginated listing, read from
+// the 'Link' header of the response, or nil if it is the last page.
+func nextPage(resp *http.Response) (*url.URL, error) {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return nil, nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start != 0 || end == -1 {
+		return nil, fmt.Errorf("invalid 'Link' header: %s", link)
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'Link' header: %w", err)
+	}
+	return resp.Request.URL.ResolveReference(u), nil
+}
+
+// SemverTags returns the given tags which are semantic versions, sorted in
+// descending order. As '+' is not allowed in OCI tags, '_' in tags is read
+// as '+', like Helm does.
+func SemverTags(tags []string) []string {
+	var versions []*semver.Version
+	for _, tag := range tags {
+		v, err := semver.StrictNewVersion(strings.ReplaceAll(tag, "_", "+"))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+
+	result := make([]string, 0, len(versions))
+	for _, v := range versions {
+		result = append(result, v.Original())
+	}
+	return result
+}
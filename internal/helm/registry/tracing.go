@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer of the spans of the chart loads.
+const TracerName = "github.com/tamalsaha/learn-helm-oci"
+
+// Attributes of the spans of the chart loads.
+const (
+	// AttributeRegistryHost is the host of the registry or chart repository.
+	AttributeRegistryHost = attribute.Key("registry.host")
+	// AttributeChart is the name of the chart.
+	AttributeChart = attribute.Key("chart.name")
+	// AttributeRequestedVersion is the requested version, or version constraint, of the chart.
+	AttributeRequestedVersion = attribute.Key("chart.version.requested")
+	// AttributeVersion is the version the requested version resolved to.
+	AttributeVersion = attribute.Key("chart.version")
+	// AttributeDigest is the digest of the chart artifact, or of the chart archive.
+	AttributeDigest = attribute.Key("chart.digest")
+)
+
+// StartSpan starts a span with the given name and attributes as a child of
+// the span of ctx, with the TracerProvider of that span, so that the spans of
+// a chart load are exported by the provider of the load. If ctx holds no
+// span, the span is not recorded.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(TracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the given error, if any, as the status of the span, and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
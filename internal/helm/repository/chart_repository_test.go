@@ -0,0 +1,305 @@
+/*
+Copyright 2020 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+)
+
+const testIndex = `apiVersion: v1
+entries:
+  nginx:
+    - name: nginx
+      version: 0.2.0
+      urls:
+        - https://example.com/nginx-0.2.0.tgz
+      created: "2022-01-01T00:00:00Z"
+    - name: nginx
+      version: 0.1.0
+      urls:
+        - nginx-0.1.0.tgz
+      created: "2021-01-01T00:00:00Z"
+    - name: nginx
+      version: 1.0.0-rc.1
+      urls:
+        - nginx-1.0.0-rc.1.tgz
+  alpine:
+    - name: alpine
+      version: 1.0.0+b.min.minute
+      urls:
+        - alpine-1.0.0.tgz
+      created: "2021-01-01T00:00:00Z"
+    - name: alpine
+      version: 1.0.0+a.min.hour
+      urls:
+        - alpine-1.0.0.tgz
+      created: "2021-02-01T00:00:00Z"
+generated: "2022-01-01T00:00:00Z"
+`
+
+type mockGetter struct {
+	Response      []byte
+	LastCalledURL string
+}
+
+func (g *mockGetter) Get(u string, _ ...helmgetter.Option) (*bytes.Buffer, error) {
+	r := g.Response
+	g.LastCalledURL = u
+	return bytes.NewBuffer(r), nil
+}
+
+var httpProviders = helmgetter.Providers{
+	helmgetter.Provider{
+		Schemes: []string{"http", "https"},
+		New:     helmgetter.NewHTTPGetter,
+	},
+}
+
+func TestNewChartRepository(t *testing.T) {
+	options := []helmgetter.Option{helmgetter.WithBasicAuth("username", "password")}
+
+	t.Run("should construct chart repository", func(t *testing.T) {
+		g := NewWithT(t)
+		r, err := NewChartRepository("https://example.com/charts/", "", httpProviders, nil, options)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r).ToNot(BeNil())
+		g.Expect(r.URL).To(Equal("https://example.com/charts/"))
+		g.Expect(r.Client).ToNot(BeNil())
+		g.Expect(r.Options).To(Equal(options))
+	})
+
+	t.Run("should error on unsupported scheme", func(t *testing.T) {
+		g := NewWithT(t)
+		r, err := NewChartRepository("ftp://example.com/charts/", "", httpProviders, nil, options)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(r).To(BeNil())
+	})
+
+	t.Run("should error on empty cache key", func(t *testing.T) {
+		g := NewWithT(t)
+		r, err := NewChartRepository("https://example.com/charts/", "", httpProviders, nil, options,
+			WithMemoryCache("", cache.New(1, 0), 0, nil))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(r).To(BeNil())
+	})
+}
+
+func TestChartRepository_GetChartVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		chart       string
+		version     string
+		want        string
+		wantCreated string
+		wantErr     bool
+	}{
+		{name: "exact match", chart: "nginx", version: "0.1.0", want: "0.1.0"},
+		{name: "stable latest", chart: "nginx", version: "", want: "0.2.0"},
+		{name: "stable wildcard", chart: "nginx", version: "*", want: "0.2.0"},
+		{name: "semver range", chart: "nginx", version: "<0.2.0", want: "0.1.0"},
+		{name: "prerelease range", chart: "nginx", version: ">=1.0.0-0", want: "1.0.0-rc.1"},
+		{name: "build metadata ordered by created", chart: "alpine", version: "1.0.0", want: "1.0.0+a.min.hour"},
+		{name: "unmatched range", chart: "nginx", version: ">2.0.0", wantErr: true},
+		{name: "unknown chart", chart: "redis", version: "", wantErr: true},
+		{name: "invalid constraint", chart: "nginx", version: "invalid", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := newChartRepository()
+			g.Expect(r.LoadIndexFromBytes([]byte(testIndex))).To(Succeed())
+
+			cv, err := r.GetChartVersion(tt.chart, tt.version)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(cv).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cv.Version).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestChartRepository_DownloadChart(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		chartVersion *repo.ChartVersion
+		wantURL      string
+		wantErr      error
+	}{
+		{
+			name: "absolute URL",
+			url:  "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart"},
+				URLs:     []string{"https://cdn.example.com/chart-0.1.0.tgz"},
+			},
+			wantURL: "https://cdn.example.com/chart-0.1.0.tgz",
+		},
+		{
+			name: "relative URL",
+			url:  "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart"},
+				URLs:     []string{"chart-0.1.0.tgz"},
+			},
+			wantURL: "https://example.com/charts/chart-0.1.0.tgz",
+		},
+		{
+			name: "relative URL keeps repository query",
+			url:  "https://example.com/charts?token=abc",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart"},
+				URLs:     []string{"chart-0.1.0.tgz"},
+			},
+			wantURL: "https://example.com/charts/chart-0.1.0.tgz?token=abc",
+		},
+		{
+			name: "matching digest",
+			url:  "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart"},
+				URLs:     []string{"chart-0.1.0.tgz"},
+				Digest:   "cc57fc1903e444cf6a726490b43b27ee9f87facc037f86872201847c565b45fb",
+			},
+			wantURL: "https://example.com/charts/chart-0.1.0.tgz",
+		},
+		{
+			name: "digest mismatch",
+			url:  "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart", Version: "0.1.0"},
+				URLs:     []string{"chart-0.1.0.tgz"},
+				Digest:   "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			},
+			wantErr: ErrDigestMismatch,
+		},
+		{
+			name:         "no chart URL",
+			url:          "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{Metadata: &chart.Metadata{Name: "chart"}},
+			wantErr:      errors.New("chart 'chart' has no downloadable URLs"),
+		},
+		{
+			name: "invalid chart URL",
+			url:  "https://example.com/charts",
+			chartVersion: &repo.ChartVersion{
+				Metadata: &chart.Metadata{Name: "chart"},
+				URLs:     []string{"https://ex ample.com/chart-0.1.0.tgz"},
+			},
+			wantErr: ErrInvalidURL,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			mg := &mockGetter{Response: []byte("chart")}
+			r := newChartRepository()
+			r.URL = tt.url
+			r.Client = mg
+
+			res, err := r.DownloadChart(tt.chartVersion)
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				g.Expect(res).To(BeNil())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(mg.LastCalledURL).To(Equal(tt.wantURL))
+			g.Expect(res.String()).To(Equal("chart"))
+		})
+	}
+}
+
+func TestChartRepository_StrategicallyLoadIndex(t *testing.T) {
+	g := NewWithT(t)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.URL.Path != "/charts/index.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(testIndex))
+	}))
+	defer server.Close()
+
+	c := cache.New(10, 0)
+	var events []string
+	newRepo := func() *ChartRepository {
+		r, err := NewChartRepository(server.URL+"/charts/", "", httpProviders, nil, nil,
+			WithMemoryCache("charts", c, 0, func(event string) {
+				events = append(events, event)
+			}))
+		g.Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	r := newRepo()
+	cv, err := r.GetChartVersion("nginx", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cv.Version).To(Equal("0.2.0"))
+	g.Expect(r.Checksum).ToNot(BeEmpty())
+	g.Expect(r.Cached).To(BeTrue())
+	cachePath := r.CachePath
+
+	g.Expect(r.Clear()).To(Succeed())
+	g.Expect(r.HasIndex()).To(BeFalse())
+	g.Expect(r.HasCacheFile()).To(BeFalse())
+	_, err = os.Stat(cachePath)
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+
+	// The index must now be served from the in-memory cache.
+	r = newRepo()
+	cv, err = r.GetChartVersion("nginx", "0.1.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cv.Version).To(Equal("0.1.0"))
+	g.Expect(requests).To(Equal(1))
+	g.Expect(events).To(Equal([]string{cache.CacheEventTypeMiss, cache.CacheEventTypeHit}))
+}
+
+func TestChartRepository_LoadIndexFromBytes(t *testing.T) {
+	g := NewWithT(t)
+
+	r := newChartRepository()
+	g.Expect(r.LoadIndexFromBytes([]byte("entries: {}"))).To(MatchError(repo.ErrNoAPIVersion))
+	g.Expect(r.LoadIndexFromBytes([]byte("apiVersion: v1\nunknown: field"))).ToNot(Succeed())
+	g.Expect(r.LoadIndexFromBytes([]byte(testIndex))).To(Succeed())
+	g.Expect(r.Index.Entries).To(HaveLen(2))
+	g.Expect(r.Index.Entries["nginx"][0].Version).To(Equal("1.0.0-rc.1"))
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// Reasons of the failures to resolve, download or verify a chart. The errors
+// returned for these failures are *Error values, which match their reason with
+// errors.Is, e.g. errors.Is(err, ErrChartNotFound).
+var (
+	// ErrChartNotFound means the chart, or its repository, does not exist.
+	ErrChartNotFound = errors.New("chart not found")
+	// ErrNoMatchingVersion means no version of the chart matches the requested
+	// version or semver constraint.
+	ErrNoMatchingVersion = errors.New("no matching chart version")
+	// ErrUnauthorized means the credentials are missing, invalid, or do not
+	// grant access to the chart.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrVerificationFailed means the signature of the chart could not be verified.
+	ErrVerificationFailed = errors.New("chart verification failed")
+	// ErrDigestMismatch means the downloaded chart does not match the digest
+	// of its manifest or layer descriptor, or of its repository index entry.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrInvalidURL means the URL of the chart or of its repository is malformed.
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrRegistryUnavailable means the registry or repository server could not
+	// be reached, or failed to serve the request.
+	ErrRegistryUnavailable = errors.New("registry unavailable")
+)
+
+// Error is the error of a failure to resolve, download or verify a chart.
+type Error struct {
+	// Reason is one of the Err* reasons of the failure, e.g. ErrChartNotFound.
+	Reason error
+	// Ref is the reference of the chart, repository or registry host the
+	// failure is about.
+	Ref string
+	// Err is the underlying cause of the failure, if any.
+	Err error
+}
+
+// NewError returns an *Error with the given reason, reference and cause.
+func NewError(reason error, ref string, err error) error {
+	return &Error{Reason: reason, Ref: ref, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Reason.Error()
+	if e.Ref != "" {
+		msg = fmt.Sprintf("%s '%s'", msg, e.Ref)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// Is returns true if target is the reason of the error.
+func (e *Error) Is(target error) bool {
+	return target == e.Reason
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WrapRegistryError returns the given error of a registry operation on ref as an
+// *Error whose reason is derived from the registry response: ErrUnauthorized for
+// '401 Unauthorized' and '403 Forbidden', ErrChartNotFound for '404 Not Found',
+// ErrRegistryUnavailable for server errors and connection failures, and
+// ErrDigestMismatch for content not matching its digest. Other
+// errors, and errors which already are *Errors, are returned unchanged.
+func WrapRegistryError(ref string, err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	if reason := registryErrorReason(err); reason != nil {
+		return NewError(reason, ref, err)
+	}
+	return err
+}
+
+// registryErrorReason returns the reason of the given registry error, or nil
+// if it is unknown.
+func registryErrorReason(err error) error {
+	if errors.Is(err, registry.ErrDigestMismatch) {
+		return ErrDigestMismatch
+	}
+
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		switch {
+		case terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden:
+			return ErrUnauthorized
+		case terr.StatusCode == http.StatusNotFound:
+			return ErrChartNotFound
+		case terr.StatusCode == http.StatusTooManyRequests || terr.StatusCode >= http.StatusInternalServerError:
+			return ErrRegistryUnavailable
+		}
+		return nil
+	}
+
+	var opErr *net.OpError
+	if IsTransient(err) || errors.Is(err, syscall.ECONNREFUSED) || errors.As(err, &opErr) {
+		return ErrRegistryUnavailable
+	}
+	return nil
+}
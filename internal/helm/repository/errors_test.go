@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/gomega"
+)
+
+func TestError(t *testing.T) {
+	g := NewWithT(t)
+
+	cause := errors.New("boom")
+	err := fmt.Errorf("failed: %w", NewError(ErrChartNotFound, "oci://example.com/charts/hello", cause))
+	g.Expect(err.Error()).To(Equal("failed: chart not found 'oci://example.com/charts/hello': boom"))
+	g.Expect(errors.Is(err, ErrChartNotFound)).To(BeTrue())
+	g.Expect(errors.Is(err, ErrUnauthorized)).To(BeFalse())
+	g.Expect(errors.Is(err, cause)).To(BeTrue())
+
+	var e *Error
+	g.Expect(errors.As(err, &e)).To(BeTrue())
+	g.Expect(e.Ref).To(Equal("oci://example.com/charts/hello"))
+	g.Expect(e.Err).To(Equal(cause))
+
+	g.Expect(NewError(ErrInvalidURL, "", nil).Error()).To(Equal("invalid URL"))
+}
+
+func TestWrapRegistryError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "unauthorized", err: &transport.Error{StatusCode: http.StatusUnauthorized}, want: ErrUnauthorized},
+		{name: "forbidden", err: &transport.Error{StatusCode: http.StatusForbidden}, want: ErrUnauthorized},
+		{name: "not found", err: &transport.Error{StatusCode: http.StatusNotFound}, want: ErrChartNotFound},
+		{name: "too many requests", err: &transport.Error{StatusCode: http.StatusTooManyRequests}, want: ErrRegistryUnavailable},
+		{name: "internal server error", err: &transport.Error{StatusCode: http.StatusInternalServerError}, want: ErrRegistryUnavailable},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: ErrRegistryUnavailable},
+		{name: "bad request", err: &transport.Error{StatusCode: http.StatusBadRequest}},
+		{name: "other error", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := WrapRegistryError("example.com/charts/hello", tt.err)
+			g.Expect(errors.Is(err, tt.err)).To(BeTrue())
+			if tt.want == nil {
+				g.Expect(err).To(Equal(tt.err))
+				return
+			}
+			g.Expect(err).To(MatchError(tt.want))
+			// Errors are only wrapped once
+			g.Expect(WrapRegistryError("other", err)).To(Equal(err))
+		})
+	}
+}
@@ -22,20 +22,21 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/url"
-	"os"
 	"path"
-	"sort"
 	"strings"
+	"time"
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/getter"
-	"helm.sh/helm/v3/pkg/registry"
+	helmreg "helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
 
-	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 
-	"github.com/fluxcd/pkg/version"
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
 	"github.com/tamalsaha/learn-helm-oci/internal/oci"
 	"github.com/tamalsaha/learn-helm-oci/internal/transport"
 )
@@ -44,9 +45,15 @@ import (
 // It is used by the OCIChartRepository to retrieve chart versions
 // from OCI registries
 type RegistryClient interface {
-	Login(host string, opts ...registry.LoginOption) error
-	Logout(host string, opts ...registry.LogoutOption) error
-	Tags(url string) ([]string, error)
+	SetCredentials(host string, auth authn.Authenticator) error
+	RemoveCredentials(host string)
+	// Tags returns the tags of the given repository which are versions.
+	Tags(ctx context.Context, url string) ([]string, error)
+	// Resolve returns the digest of the manifest of the given reference.
+	Resolve(ctx context.Context, ref string) (string, error)
+	// Push uploads the given chart archive to the given repository as the
+	// artifact 'repo/name:version' of the chart.
+	Push(ctx context.Context, repo string, archive []byte, opts registry.PushOptions) (*registry.PushResult, error)
 }
 
 // OCIChartRepository represents a Helm chart repository, and the configuration
@@ -62,14 +69,44 @@ type OCIChartRepository struct {
 	Options []getter.Option
 
 	tlsConfig *tls.Config
+	// insecureHTTP allows connecting to the registry over plain HTTP.
+	insecureHTTP bool
 
 	// RegistryClient is a client to use while downloading tags or charts from a registry.
 	RegistryClient RegistryClient
-	// credentialsFile is a temporary credentials file to use while downloading tags or charts from a registry.
-	credentialsFile string
+	// authenticator holds the credentials of the registry, if any.
+	authenticator authn.Authenticator
 
 	// verifiers is a list of verifiers to use when verifying a chart.
 	verifiers []oci.Verifier
+
+	// retryPolicy configures the retries of the registry operations.
+	retryPolicy RetryPolicy
+
+	// metrics records the retries and the verifications, if set.
+	metrics *registry.MetricsRecorder
+
+	// traceCtx is the context the registry operations are bound to: they are
+	// cancelled with it, and their spans are children of its span.
+	traceCtx context.Context
+
+	// tagCache caches the tag listings of the charts of the repository, if set.
+	tagCache *cache.Cache
+	// tagCacheKey scopes the cached tag listings of the repository.
+	tagCacheKey string
+	// tagCacheTTL is the duration the cached tag listings are fresh.
+	tagCacheTTL time.Duration
+	// recordTagCacheMetric records the tag cache events, if set.
+	recordTagCacheMetric RecordMetricsFunc
+	// forceRefresh revalidates the cached tag listings even if they are fresh.
+	forceRefresh bool
+
+	// versionPolicy selects the tags of the requested versions.
+	versionPolicy VersionPolicy
+
+	// chartNames are the names of the charts of the repository, listed if the
+	// registry does not list its repositories.
+	chartNames []string
 }
 
 // OCIChartRepositoryOption is a function that can be passed to NewOCIChartRepository
@@ -112,17 +149,121 @@ func WithOCIGetterOptions(getterOpts []getter.Option) OCIChartRepositoryOption {
 	}
 }
 
-// WithCredentialsFile returns a ChartRepositoryOption that will set the credentials file
-func WithCredentialsFile(credentialsFile string) OCIChartRepositoryOption {
+// WithTLSConfig returns a ChartRepositoryOption that will set the TLS configuration
+// used to connect to the registry. It applies to the default registry client,
+// which is used when no registry client is set, and to the transport of HTTP getters.
+func WithTLSConfig(tlsConfig *tls.Config) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.tlsConfig = tlsConfig
+		return nil
+	}
+}
+
+// WithInsecureHTTP returns a ChartRepositoryOption that will allow connecting
+// to the registry over plain HTTP. It applies to the default registry client,
+// and to the chart references passed to the verifiers.
+func WithInsecureHTTP() OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.insecureHTTP = true
+		return nil
+	}
+}
+
+// WithRetryPolicy returns a ChartRepositoryOption that will set the retry policy
+// of the registry operations, which defaults to DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithCredentials returns a ChartRepositoryOption that will set the authenticator
+// of the registry, which is stored in memory by the registry client.
+func WithCredentials(auth authn.Authenticator) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.authenticator = auth
+		return nil
+	}
+}
+
+// WithMetricsRecorder returns a ChartRepositoryOption that will set the recorder
+// of the metrics of the registry operations. It applies to the retries and the
+// verifications, and to the default registry client.
+func WithMetricsRecorder(recorder *registry.MetricsRecorder) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.metrics = recorder
+		return nil
+	}
+}
+
+// WithTraceContext returns a ChartRepositoryOption that will bind the registry
+// operations of the methods of the OCIChartRepository which take no context to
+// the given context: their requests and retries are cancelled with it, and are
+// traced as children of its span.
+func WithTraceContext(ctx context.Context) OCIChartRepositoryOption {
 	return func(r *OCIChartRepository) error {
-		r.credentialsFile = credentialsFile
+		r.traceCtx = ctx
+		return nil
+	}
+}
+
+// WithTagCache returns a ChartRepositoryOption that will cache the tag listings
+// of the charts of the repository in the given cache, keyed by the given key and
+// the chart reference, and record the cache events with rec, if not nil.
+// Cached listings are fresh for ttl, and are then revalidated with the registry,
+// with a conditional request if the registry client and the registry support it.
+// Stale listings are kept in the cache for revalidation for another ttl.
+// The cache key have to be safe in multi-tenancy environments, as otherwise it
+// could be used as a vector to bypass the repository's authentication.
+func WithTagCache(key string, c *cache.Cache, ttl time.Duration, rec RecordMetricsFunc) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.tagCacheKey = key
+		r.tagCache = c
+		r.tagCacheTTL = ttl
+		r.recordTagCacheMetric = rec
+		return nil
+	}
+}
+
+// WithForceRefresh returns a ChartRepositoryOption that will revalidate the
+// cached tag listings with the registry, even if they are fresh.
+func WithForceRefresh() OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.forceRefresh = true
+		return nil
+	}
+}
+
+// WithVersionPolicy returns a ChartRepositoryOption that will set the policy
+// selecting the tags of the requested chart versions, which defaults to the
+// semver policy without prereleases.
+func WithVersionPolicy(policy VersionPolicy) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.versionPolicy = policy
+		return nil
+	}
+}
+
+// WithChartNames returns a ChartRepositoryOption that will set the names of
+// the charts of the repository, relative to its URL, which are listed by
+// ListCharts if the registry does not support the '_catalog' endpoint.
+func WithChartNames(names ...string) OCIChartRepositoryOption {
+	return func(r *OCIChartRepository) error {
+		r.chartNames = names
 		return nil
 	}
 }
 
 // NewOCIChartRepository constructs and returns a new ChartRepository with
 // the ChartRepository.Client configured to the getter.Getter for the
-// repository URL scheme. It returns an error on URL parsing failures.
+// repository URL scheme. If no registry client is set, a registry client
+// configured with the TLS configuration of the repository is used. The
+// credentials of the repository, if any, are set on the registry client.
+// If no getter is set, and the registry client implements getter.Getter, the
+// charts are downloaded with the registry client, so that all requests to the
+// registry share the same TLS configuration and credentials.
+// It returns an error on URL parsing failures.
 // It assumes that the url scheme has been validated to be an OCI scheme.
 func NewOCIChartRepository(repositoryURL string, chartRepoOpts ...OCIChartRepositoryOption) (*OCIChartRepository, error) {
 	u, err := url.Parse(repositoryURL)
@@ -130,7 +271,10 @@ func NewOCIChartRepository(repositoryURL string, chartRepoOpts ...OCIChartReposi
 		return nil, err
 	}
 
-	r := &OCIChartRepository{}
+	r := &OCIChartRepository{
+		retryPolicy:   DefaultRetryPolicy,
+		versionPolicy: NewSemverPolicy(false),
+	}
 	r.URL = *u
 	for _, opt := range chartRepoOpts {
 		if err := opt(r); err != nil {
@@ -138,68 +282,157 @@ func NewOCIChartRepository(repositoryURL string, chartRepoOpts ...OCIChartReposi
 		}
 	}
 
+	if r.RegistryClient == nil {
+		r.RegistryClient = registry.NewClient(
+			registry.ClientOptTLSConfig(r.tlsConfig),
+			registry.ClientOptInsecureHTTP(r.insecureHTTP),
+			registry.ClientOptMetricsRecorder(r.metrics),
+		)
+	}
+	if r.authenticator != nil {
+		if err := r.RegistryClient.SetCredentials(r.URL.Host, r.authenticator); err != nil {
+			return nil, err
+		}
+	}
+	if r.Client == nil {
+		if g, ok := r.RegistryClient.(getter.Getter); ok {
+			r.Client = g
+		}
+	}
+
 	return r, nil
 }
 
-// GetChartVersion returns the repo.ChartVersion for the given name, the version is expected
-// to be a semver.Constraints compatible string. If version is empty, the latest
-// stable version will be returned and prerelease versions will be ignored.
+// GetChartVersion returns the repo.ChartVersion for the given name, the version
+// is selected among the tags of the chart by the version policy of the repository,
+// by default a semver.Constraints compatible string. If version is empty, the latest
+// version will be returned, prerelease versions being ignored unless the policy
+// includes them.
+// The version may also be pinned to a manifest digest, as 'tag@sha256:...' or
+// 'sha256:...'. Otherwise the tag of the version is resolved to the digest of
+// its manifest, and the URL of the returned chart version is pinned to it.
 // adapted from https://github.com/helm/helm/blob/49819b4ef782e80b0c7f78c30bd76b51ebb56dc8/pkg/downloader/chart_downloader.go#L162
-func (r *OCIChartRepository) GetChartVersion(name, ver string) (*repo.ChartVersion, error) {
+func (r *OCIChartRepository) GetChartVersion(name, ver string) (cv *repo.ChartVersion, err error) {
+	ctx, span := registry.StartSpan(r.traceContext(), "OCIChartRepository.GetChartVersion",
+		registry.AttributeRegistryHost.String(r.URL.Host),
+		registry.AttributeChart.String(name),
+		registry.AttributeRequestedVersion.String(ver))
+	defer func() {
+		if cv != nil {
+			span.SetAttributes(registry.AttributeVersion.String(cv.Version))
+		}
+		registry.EndSpan(span, err)
+	}()
+
 	cpURL := r.URL
 	cpURL.Path = path.Join(cpURL.Path, name)
 
-	// if ver is a valid semver version, take a shortcut here so we don't need to list all tags which can be an
+	// A version pinned to a manifest digest, e.g. 'tag@sha256:...', is pulled
+	// by digest without resolving its tag.
+	if tag, digest, pinned := registry.SplitDigest(ver); pinned {
+		if _, err := v1.NewHash(digest); err != nil {
+			return nil, NewError(ErrInvalidURL, cpURL.String(), fmt.Errorf("invalid digest '%s': %w", digest, err))
+		}
+		ref := registry.PinnedReference(cpURL.String(), tag, digest)
+		if tag == "" {
+			return pinnedChartVersion(name, digest, ref), nil
+		}
+		return pinnedChartVersion(name, tag, ref), nil
+	}
+
+	// if ver is a concrete version, take a shortcut here so we don't need to list all tags which can be an
 	// expensive operation.
-	usesDigest := strings.HasPrefix(ver, "sha256:")
-	_, err := version.ParseVersion(ver)
-	usesSemver := err == nil
-	if usesSemver || usesDigest {
-		return &repo.ChartVersion{
-			URLs: []string{fmt.Sprintf("%s:%s", cpURL.String(), ver)},
-			Metadata: &chart.Metadata{
-				Name:    name,
-				Version: ver,
-			},
-		}, nil
-	}
-
-	// ver doesn't denote a concrete version so we interpret it as a semver range and try to find the best-matching
-	// version from the list of tags in the registry.
-
-	cvs, err := r.getTags(cpURL.String())
-	if err != nil {
-		return nil, fmt.Errorf("could not get tags for %q: %s", name, err)
+	tag := ver
+	if !r.versionPolicy.Exact(ver) {
+		// ver doesn't denote a concrete version so we interpret it as a range and try to find the best-matching
+		// version from the list of tags in the registry.
+
+		tags, err := r.getTags(ctx, cpURL.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not get tags for %q: %w", name, err)
+		}
+
+		// Determine if version provided
+		// If empty, try to get the highest available tag
+		// If exact version, try to find it
+		// If constraint string, try to find a match
+		tag, err = r.versionPolicy.Latest(tags, ver)
+		if err != nil {
+			return nil, NewError(ErrNoMatchingVersion, cpURL.String(), err)
+		}
 	}
 
-	if len(cvs) == 0 {
-		return nil, fmt.Errorf("unable to locate any tags in provided repository: %s", name)
+	// Pin the tag to the manifest it references, so the chart is pulled by
+	// digest even if the tag moves in between.
+	digest, err := r.resolve(ctx, fmt.Sprintf("%s:%s", cpURL.String(), tag))
+	if err != nil {
+		return nil, err
 	}
+	return pinnedChartVersion(name, tag, registry.PinnedReference(cpURL.String(), tag, digest)), nil
+}
 
-	// Determine if version provided
-	// If empty, try to get the highest available tag
-	// If exact version, try to find it
-	// If semver constraint string, try to find a match
-	tag, err := getLastMatchingVersionOrConstraint(cvs, ver)
+// pinnedChartVersion returns the repo.ChartVersion of the given version of a
+// chart, to be pulled from the given reference pinned to a manifest digest.
+func pinnedChartVersion(name, version, ref string) *repo.ChartVersion {
 	return &repo.ChartVersion{
-		URLs: []string{fmt.Sprintf("%s:%s", cpURL.String(), tag)},
+		URLs: []string{ref},
 		Metadata: &chart.Metadata{
 			Name:    name,
-			Version: tag,
+			Version: version,
 		},
-	}, err
+	}
+}
+
+// resolve returns the digest of the manifest of the given chart reference.
+func (r *OCIChartRepository) resolve(ctx context.Context, ref string) (digest string, err error) {
+	_, span := registry.StartSpan(ctx, "OCIChartRepository.Resolve", registry.AttributeRegistryHost.String(r.URL.Host))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(registry.AttributeDigest.String(digest))
+		}
+		registry.EndSpan(span, err)
+	}()
+
+	err = r.retry(ctx, r.URL.Host, registry.OperationResolve, func() (err error) {
+		digest, err = r.RegistryClient.Resolve(ctx, strings.TrimPrefix(ref, fmt.Sprintf("%s://", helmreg.OCIScheme)))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %q: %w", ref, WrapRegistryError(ref, err))
+	}
+	return digest, nil
+}
+
+// ListChartVersions returns the tags of the chart with the given name which are
+// versions under the version policy, from the latest to the oldest.
+func (r *OCIChartRepository) ListChartVersions(name string) ([]string, error) {
+	cpURL := r.URL
+	cpURL.Path = path.Join(cpURL.Path, name)
+	tags, err := r.getTags(r.traceContext(), cpURL.String())
+	if err != nil {
+		return nil, err
+	}
+	return r.versionPolicy.Versions(tags), nil
 }
 
 // This function shall be called for OCI registries only
 // It assumes that the ref has been validated to be an OCI reference.
-func (r *OCIChartRepository) getTags(ref string) ([]string, error) {
+func (r *OCIChartRepository) getTags(ctx context.Context, ref string) (tags []string, err error) {
+	_, span := registry.StartSpan(ctx, "OCIChartRepository.Tags", registry.AttributeRegistryHost.String(r.URL.Host))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
 	// Retrieve list of repository tags
-	tags, err := r.RegistryClient.Tags(strings.TrimPrefix(ref, fmt.Sprintf("%s://", registry.OCIScheme)))
+	err = r.retry(ctx, r.URL.Host, registry.OperationListTags, func() (err error) {
+		tags, err = r.listTags(ctx, strings.TrimPrefix(ref, fmt.Sprintf("%s://", helmreg.OCIScheme)))
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch tags for %q: %s", ref, err)
+		return nil, fmt.Errorf("could not fetch tags for %q: %w", ref, WrapRegistryError(ref, err))
 	}
 	if len(tags) == 0 {
-		return nil, fmt.Errorf("unable to locate any tags in provided repository: %s", ref)
+		return nil, NewError(ErrChartNotFound, ref, fmt.Errorf("unable to locate any tags in provided repository"))
 	}
 
 	return tags, nil
@@ -207,9 +440,18 @@ func (r *OCIChartRepository) getTags(ref string) ([]string, error) {
 
 // DownloadChart confirms the given repo.ChartVersion has a downloadable URL,
 // and then attempts to download the chart using the Client and Options of the
-// ChartRepository. It returns a bytes.Buffer containing the chart data.
+// ChartRepository, retrying transient failures according to the retry policy.
+// It returns a bytes.Buffer containing the chart data.
 // In case of an OCI hosted chart, this function assumes that the chartVersion url is valid.
-func (r *OCIChartRepository) DownloadChart(chart *repo.ChartVersion) (*bytes.Buffer, error) {
+func (r *OCIChartRepository) DownloadChart(chart *repo.ChartVersion) (_ *bytes.Buffer, err error) {
+	ctx, span := registry.StartSpan(r.traceContext(), "OCIChartRepository.DownloadChart",
+		registry.AttributeRegistryHost.String(r.URL.Host),
+		registry.AttributeChart.String(chart.Name),
+		registry.AttributeVersion.String(chart.Version))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
 	if len(chart.URLs) == 0 {
 		return nil, fmt.Errorf("chart '%s' has no downloadable URLs", chart.Name)
 	}
@@ -217,8 +459,7 @@ func (r *OCIChartRepository) DownloadChart(chart *repo.ChartVersion) (*bytes.Buf
 	ref := chart.URLs[0]
 	u, err := url.Parse(ref)
 	if err != nil {
-		err = fmt.Errorf("invalid chart URL format '%s': %w", ref, err)
-		return nil, err
+		return nil, NewError(ErrInvalidURL, ref, fmt.Errorf("invalid chart URL format: %w", err))
 	}
 
 	t := transport.NewOrIdle(r.tlsConfig)
@@ -226,119 +467,113 @@ func (r *OCIChartRepository) DownloadChart(chart *repo.ChartVersion) (*bytes.Buf
 	defer transport.Release(t)
 
 	// trim the oci scheme prefix if needed
-	return r.Client.Get(strings.TrimPrefix(u.String(), fmt.Sprintf("%s://", registry.OCIScheme)), clientOpts...)
-}
-
-// Login attempts to login to the OCI registry.
-// It returns an error on failure.
-func (r *OCIChartRepository) Login(opts ...registry.LoginOption) error {
-	err := r.RegistryClient.Login(r.URL.Host, opts...)
-	if err != nil {
+	href := strings.TrimPrefix(u.String(), fmt.Sprintf("%s://", helmreg.OCIScheme))
+	var b *bytes.Buffer
+	err = r.retry(ctx, u.Host, registry.OperationDownload, func() (err error) {
+		// The registry client traces the manifest fetch and the layer download
+		if c, ok := r.Client.(contextGetter); ok {
+			b, err = c.GetContext(ctx, href)
+			return err
+		}
+		b, err = r.Client.Get(href, clientOpts...)
 		return err
-	}
-	return nil
-}
-
-// Logout attempts to logout from the OCI registry.
-// It returns an error on failure.
-func (r *OCIChartRepository) Logout() error {
-	err := r.RegistryClient.Logout(r.URL.Host)
+	})
 	if err != nil {
-		return err
+		return nil, WrapRegistryError(ref, err)
 	}
-	return nil
+	return b, nil
 }
 
-// HasCredentials returns true if the OCIChartRepository has credentials.
-func (r *OCIChartRepository) HasCredentials() bool {
-	return r.credentialsFile != ""
+// contextGetter is a getter.Getter whose downloads can be bound to a context.
+type contextGetter interface {
+	GetContext(ctx context.Context, href string) (*bytes.Buffer, error)
 }
 
-// Clear deletes the OCI registry credentials file.
-func (r *OCIChartRepository) Clear() error {
-	// clean the credentials file if it exists
-	if r.credentialsFile != "" {
-		if err := os.Remove(r.credentialsFile); err != nil {
-			return err
-		}
+// traceContext returns the context the registry operations are bound to.
+func (r *OCIChartRepository) traceContext() context.Context {
+	if r.traceCtx == nil {
+		return context.Background()
 	}
-	r.credentialsFile = ""
-	return nil
+	return r.traceCtx
 }
 
-// getLastMatchingVersionOrConstraint returns the last version that matches the given version string.
-// If the version string is empty, the highest available version is returned.
-func getLastMatchingVersionOrConstraint(cvs []string, ver string) (string, error) {
-	// Check for exact matches first
-	if ver != "" {
-		for _, cv := range cvs {
-			if ver == cv {
-				return cv, nil
-			}
-		}
-	}
-
-	// Continue to look for a (semantic) version match
-	verConstraint, err := semver.NewConstraint("*")
-	if err != nil {
-		return "", err
-	}
-	latestStable := ver == "" || ver == "*"
-	if !latestStable {
-		verConstraint, err = semver.NewConstraint(ver)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	matchingVersions := make([]*semver.Version, 0, len(cvs))
-	for _, cv := range cvs {
-		v, err := version.ParseVersion(cv)
-		if err != nil {
-			continue
+// retry calls fn according to the retry policy, until the given context is
+// done, and records the retries of the given operation on the given registry
+// host.
+func (r *OCIChartRepository) retry(ctx context.Context, host, operation string, fn func() error) error {
+	attempts := 0
+	return r.retryPolicy.do(ctx, func() error {
+		if attempts > 0 {
+			r.metrics.IncRetries(host, operation)
 		}
+		attempts++
+		return fn()
+	})
+}
 
-		if !verConstraint.Check(v) {
-			continue
-		}
+// HasCredentials returns true if the OCIChartRepository has credentials.
+func (r *OCIChartRepository) HasCredentials() bool {
+	return r.authenticator != nil
+}
 
-		matchingVersions = append(matchingVersions, v)
-	}
-	if len(matchingVersions) == 0 {
-		return "", fmt.Errorf("could not locate a version matching provided version string %s", ver)
+// Clear removes the credentials of the OCIChartRepository from the registry
+// client. No files are created by the OCIChartRepository, so none are deleted.
+func (r *OCIChartRepository) Clear() error {
+	if r.authenticator != nil {
+		r.RegistryClient.RemoveCredentials(r.URL.Host)
 	}
-
-	// Sort versions
-	sort.Sort(sort.Reverse(semver.Collection(matchingVersions)))
-
-	return matchingVersions[0].Original(), nil
+	return nil
 }
 
 // VerifyChart verifies the chart against a signature.
 // If no signature is provided, a keyless verification is performed.
 // It returns an error on failure.
 func (r *OCIChartRepository) VerifyChart(ctx context.Context, chart *repo.ChartVersion) error {
+	_, err := r.MatchingVerifier(ctx, chart)
+	return err
+}
+
+// MatchingVerifier verifies the chart against the configured verifiers and
+// returns the first verifier that found a valid signature for the chart.
+// It returns an error if no verifier matched.
+func (r *OCIChartRepository) MatchingVerifier(ctx context.Context, chart *repo.ChartVersion) (_ oci.Verifier, err error) {
+	ctx, span := registry.StartSpan(ctx, "OCIChartRepository.MatchingVerifier",
+		registry.AttributeRegistryHost.String(r.URL.Host),
+		registry.AttributeChart.String(chart.Name),
+		registry.AttributeVersion.String(chart.Version))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
 	if len(r.verifiers) == 0 {
-		return fmt.Errorf("no verifiers available")
+		return nil, fmt.Errorf("no verifiers available")
 	}
 
 	if len(chart.URLs) == 0 {
-		return fmt.Errorf("chart '%s' has no downloadable URLs", chart.Name)
+		return nil, fmt.Errorf("chart '%s' has no downloadable URLs", chart.Name)
 	}
 
-	ref, err := name.ParseReference(strings.TrimPrefix(chart.URLs[0], fmt.Sprintf("%s://", registry.OCIScheme)))
+	var nameOpts []name.Option
+	if r.insecureHTTP {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	ref, err := name.ParseReference(strings.TrimPrefix(chart.URLs[0], fmt.Sprintf("%s://", helmreg.OCIScheme)), nameOpts...)
 	if err != nil {
-		return fmt.Errorf("invalid chart reference: %s", err)
+		return nil, NewError(ErrInvalidURL, chart.URLs[0], fmt.Errorf("invalid chart reference: %w", err))
 	}
 
 	// verify the chart
+	host := ref.Context().RegistryStr()
 	for _, verifier := range r.verifiers {
 		if verified, err := verifier.Verify(ctx, ref); err != nil {
-			return fmt.Errorf("failed to verify %s: %w", chart.URLs[0], err)
+			r.metrics.IncVerifications(host, registry.VerificationResultFailed)
+			return nil, NewError(ErrVerificationFailed, chart.URLs[0], err)
 		} else if verified {
-			return nil
+			r.metrics.IncVerifications(host, registry.VerificationResultVerified)
+			return verifier, nil
 		}
 	}
 
-	return fmt.Errorf("no matching signatures were found for '%s'", ref.Name())
+	r.metrics.IncVerifications(host, registry.VerificationResultFailed)
+	return nil, NewError(ErrVerificationFailed, ref.Name(), fmt.Errorf("no matching signatures were found"))
 }
@@ -18,17 +18,24 @@ package repository
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net/url"
 	"path"
 	"strings"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 	. "github.com/onsi/gomega"
 	"helm.sh/helm/v3/pkg/chart"
 	helmgetter "helm.sh/helm/v3/pkg/getter"
-	"helm.sh/helm/v3/pkg/registry"
+	helmreg "helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/oci"
 )
 
 type OCIMockGetter struct {
@@ -42,24 +49,36 @@ func (g *OCIMockGetter) Get(u string, _ ...helmgetter.Option) (*bytes.Buffer, er
 	return bytes.NewBuffer(r), nil
 }
 
+// mockDigest is the manifest digest every reference resolves to with the mockRegistryClient.
+const mockDigest = "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
 type mockRegistryClient struct {
-	tags          []string
-	LastCalledURL string
+	tags            []string
+	LastCalledURL   string
+	LastResolvedRef string
 }
 
-func (m *mockRegistryClient) Tags(urlStr string) ([]string, error) {
+func (m *mockRegistryClient) Tags(_ context.Context, urlStr string) ([]string, error) {
 	m.LastCalledURL = urlStr
 	return m.tags, nil
 }
 
-func (m *mockRegistryClient) Login(url string, opts ...registry.LoginOption) error {
+func (m *mockRegistryClient) Resolve(_ context.Context, ref string) (string, error) {
+	m.LastResolvedRef = ref
+	return mockDigest, nil
+}
+
+func (m *mockRegistryClient) Push(_ context.Context, repo string, _ []byte, _ registry.PushOptions) (*registry.PushResult, error) {
+	return nil, fmt.Errorf("pushing to %q is not implemented by the mock", repo)
+}
+
+func (m *mockRegistryClient) SetCredentials(url string, _ authn.Authenticator) error {
 	m.LastCalledURL = url
 	return nil
 }
 
-func (m *mockRegistryClient) Logout(url string, opts ...registry.LogoutOption) error {
+func (m *mockRegistryClient) RemoveCredentials(url string) {
 	m.LastCalledURL = url
-	return nil
 }
 
 func TestNewOCIChartRepository(t *testing.T) {
@@ -83,6 +102,28 @@ func TestNewOCIChartRepository(t *testing.T) {
 		g.Expect(r.RegistryClient).To(Equal(registryClient))
 	})
 
+	t.Run("should default to the registry client", func(t *testing.T) {
+		g := NewWithT(t)
+		r, err := NewOCIChartRepository(url, WithTLSConfig(&tls.Config{}))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r.RegistryClient).To(BeAssignableToTypeOf(&registry.Client{}))
+		g.Expect(r.Client).To(BeIdenticalTo(r.RegistryClient))
+	})
+
+	t.Run("should set the credentials on the registry client", func(t *testing.T) {
+		g := NewWithT(t)
+		registryClient := &mockRegistryClient{}
+		r, err := NewOCIChartRepository(url, WithOCIRegistryClient(registryClient),
+			WithCredentials(&authn.Basic{Username: "username", Password: "password"}))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(r.HasCredentials()).To(BeTrue())
+		g.Expect(registryClient.LastCalledURL).To(Equal("localhost:5000"))
+
+		registryClient.LastCalledURL = ""
+		g.Expect(r.Clear()).To(Succeed())
+		g.Expect(registryClient.LastCalledURL).To(Equal("localhost:5000"))
+	})
+
 	t.Run("should return error on invalid url", func(t *testing.T) {
 		g := NewWithT(t)
 		r, err := NewOCIChartRepository("oci://localhost:5000 /my_repo", WithOCIGetter(providers), WithOCIGetterOptions(options), WithOCIRegistryClient(registryClient))
@@ -154,7 +195,7 @@ func TestOCIChartRepository_Get(t *testing.T) {
 		},
 		{
 			name:           "should return a perfect match",
-			registryClient: nil,
+			registryClient: &mockRegistryClient{},
 			version:        "0.1.0",
 			url:            testURL,
 			expected:       "0.1.0",
@@ -171,7 +212,7 @@ func TestOCIChartRepository_Get(t *testing.T) {
 			registryClient: registryClient,
 			version:        ">2.0.0",
 			url:            testURL,
-			expectedErr:    "could not locate a version matching provided version string >2.0.0",
+			expectedErr:    "no matching chart version 'oci://localhost:5000/my_repo/podinfo': could not locate a version matching provided version string >2.0.0",
 		},
 		{
 			name:           "shouldn't error out with trailing slash",
@@ -194,6 +235,7 @@ func TestOCIChartRepository_Get(t *testing.T) {
 			if tc.expectedErr != "" {
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(err.Error()).To(Equal(tc.expectedErr))
+				g.Expect(err).To(MatchError(ErrNoMatchingVersion))
 				return
 			}
 			g.Expect(err).ToNot(HaveOccurred())
@@ -201,8 +243,73 @@ func TestOCIChartRepository_Get(t *testing.T) {
 			u, err := url.Parse(tc.url)
 			g.Expect(err).ToNot(HaveOccurred())
 			u.Path = path.Join(u.Path, chart)
-			g.Expect(cv.URLs[0]).To(Equal(fmt.Sprintf("%s:%s", u.String(), tc.expected)))
-			g.Expect(registryClient.LastCalledURL).To(Equal(strings.TrimPrefix(u.String(), fmt.Sprintf("%s://", registry.OCIScheme))))
+			g.Expect(cv.Version).To(Equal(tc.expected))
+			g.Expect(cv.URLs[0]).To(Equal(fmt.Sprintf("%s:%s@%s", u.String(), tc.expected, mockDigest)))
+			ref := strings.TrimPrefix(u.String(), fmt.Sprintf("%s://", helmreg.OCIScheme))
+			g.Expect(tc.registryClient.(*mockRegistryClient).LastResolvedRef).To(Equal(fmt.Sprintf("%s:%s", ref, tc.expected)))
+			if tc.registryClient == registryClient {
+				g.Expect(registryClient.LastCalledURL).To(Equal(ref))
+			}
+		})
+	}
+}
+
+func TestOCIChartRepository_GetPinnedChartVersion(t *testing.T) {
+	const digest = "sha256:486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+
+	testCases := []struct {
+		name         string
+		version      string
+		wantVersion  string
+		wantURL      string
+		wantResolved string
+		wantErr      error
+	}{
+		{
+			name:        "tag pinned to digest",
+			version:     "0.1.0@" + digest,
+			wantVersion: "0.1.0",
+			wantURL:     "oci://localhost:5000/my_repo/podinfo:0.1.0@" + digest,
+		},
+		{
+			name:        "digest",
+			version:     digest,
+			wantVersion: digest,
+			wantURL:     "oci://localhost:5000/my_repo/podinfo@" + digest,
+		},
+		{
+			name:         "build metadata in tag",
+			version:      "0.1.5+a.min.hour",
+			wantVersion:  "0.1.5+a.min.hour",
+			wantURL:      "oci://localhost:5000/my_repo/podinfo:0.1.5_a.min.hour@" + mockDigest,
+			wantResolved: "localhost:5000/my_repo/podinfo:0.1.5+a.min.hour",
+		},
+		{
+			name:    "invalid digest",
+			version: "0.1.0@sha256:invalid",
+			wantErr: ErrInvalidURL,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			registryClient := &mockRegistryClient{}
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(registryClient))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			cv, err := r.GetChartVersion("podinfo", tc.version)
+			if tc.wantErr != nil {
+				g.Expect(err).To(MatchError(tc.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cv.Version).To(Equal(tc.wantVersion))
+			g.Expect(cv.URLs).To(Equal([]string{tc.wantURL}))
+			g.Expect(registryClient.LastResolvedRef).To(Equal(tc.wantResolved))
+			g.Expect(registryClient.LastCalledURL).To(BeEmpty())
 		})
 	}
 }
@@ -269,3 +376,80 @@ func TestOCIChartRepository_DownloadChart(t *testing.T) {
 		})
 	}
 }
+
+type mockVerifier struct {
+	name     string
+	verified bool
+	err      error
+}
+
+func (v *mockVerifier) Verify(_ context.Context, _ name.Reference) (bool, error) {
+	return v.verified, v.err
+}
+
+func TestOCIChartRepository_MatchingVerifier(t *testing.T) {
+	chartVersion := &repo.ChartVersion{
+		Metadata: &chart.Metadata{Name: "podinfo"},
+		URLs:     []string{"oci://localhost:5000/my_repo/podinfo:1.0.0"},
+	}
+	unmatched := &mockVerifier{name: "a.pub"}
+	matched := &mockVerifier{name: "b.pub", verified: true}
+	failing := &mockVerifier{name: "c.pub", err: fmt.Errorf("network error")}
+
+	testCases := []struct {
+		name         string
+		verifiers    []oci.Verifier
+		chartVersion *repo.ChartVersion
+		want         oci.Verifier
+		expectedErr  bool
+	}{
+		{
+			name:         "returns first matching verifier",
+			verifiers:    []oci.Verifier{unmatched, matched},
+			chartVersion: chartVersion,
+			want:         matched,
+		},
+		{
+			name:         "no matching signatures",
+			verifiers:    []oci.Verifier{unmatched},
+			chartVersion: chartVersion,
+			expectedErr:  true,
+		},
+		{
+			name:         "verifier error",
+			verifiers:    []oci.Verifier{failing, matched},
+			chartVersion: chartVersion,
+			expectedErr:  true,
+		},
+		{
+			name:         "no verifiers",
+			chartVersion: chartVersion,
+			expectedErr:  true,
+		},
+		{
+			name:         "no chart URL",
+			verifiers:    []oci.Verifier{matched},
+			chartVersion: &repo.ChartVersion{Metadata: &chart.Metadata{Name: "podinfo"}},
+			expectedErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithVerifiers(tc.verifiers))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			v, err := r.MatchingVerifier(context.TODO(), tc.chartVersion)
+			if tc.expectedErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(r.VerifyChart(context.TODO(), tc.chartVersion)).ToNot(Succeed())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(v).To(BeIdenticalTo(tc.want))
+			g.Expect(r.VerifyChart(context.TODO(), tc.chartVersion)).To(Succeed())
+		})
+	}
+}
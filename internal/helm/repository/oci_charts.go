@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// ChartInfo describes a chart of an OCI chart repository.
+type ChartInfo struct {
+	// Name of the chart, relative to the repository URL.
+	Name string
+	// Versions of the chart, from the latest to the oldest, per the version
+	// policy of the repository.
+	Versions []string
+	// Metadata of the latest version of the chart, e.g. its description,
+	// appVersion and icon, read from the config blob of its artifact.
+	Metadata *chart.Metadata
+}
+
+// catalogLister is a RegistryClient which lists the repositories of a registry.
+type catalogLister interface {
+	Catalog(ctx context.Context, host string) ([]string, error)
+}
+
+// metadataGetter is a RegistryClient which reads the metadata of charts
+// without downloading them.
+type metadataGetter interface {
+	Metadata(ctx context.Context, ref string) (*chart.Metadata, error)
+}
+
+// ListCharts returns the charts of the repository whose name starts with the
+// given prefix, sorted by name, with their versions and the metadata of their
+// latest version. The charts are listed with the '_catalog' endpoint of the
+// registry, or are the chart names of the repository if the registry does not
+// support it. Repositories which are not charts, or have no versions under
+// the version policy, are ignored.
+func (r *OCIChartRepository) ListCharts(prefix string) (_ []*ChartInfo, err error) {
+	ctx, span := registry.StartSpan(r.traceContext(), "OCIChartRepository.ListCharts",
+		registry.AttributeRegistryHost.String(r.URL.Host))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	names, err := r.listChartNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	charts := make([]*ChartInfo, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		info, err := r.chartInfo(ctx, name)
+		if errors.Is(err, ErrChartNotFound) || errors.Is(err, registry.ErrNotChart) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		charts = append(charts, info)
+	}
+	return charts, nil
+}
+
+// Search returns the charts of the repository whose name, description or
+// keywords contain the given query, ignoring case, like ListCharts does.
+// An empty query matches all charts.
+func (r *OCIChartRepository) Search(query string) ([]*ChartInfo, error) {
+	charts, err := r.ListCharts("")
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	result := make([]*ChartInfo, 0, len(charts))
+	for _, c := range charts {
+		if matchesQuery(c, query) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// matchesQuery returns true if the name, description or keywords of the given
+// chart contain the given lower case query.
+func matchesQuery(c *ChartInfo, query string) bool {
+	fields := append([]string{c.Name, c.Metadata.Description}, c.Metadata.Keywords...)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// listChartNames returns the sorted names of the charts of the repository,
+// i.e. the repositories of the registry under the repository path, or the
+// configured chart names if the registry does not list its repositories.
+func (r *OCIChartRepository) listChartNames(ctx context.Context) ([]string, error) {
+	var names []string
+	lister, ok := r.RegistryClient.(catalogLister)
+	switch {
+	case ok:
+		var repos []string
+		err := r.retry(ctx, r.URL.Host, registry.OperationCatalog, func() (err error) {
+			repos, err = lister.Catalog(ctx, r.URL.Host)
+			return err
+		})
+		if err == nil {
+			names = chartsUnder(repos, strings.Trim(r.URL.Path, "/"))
+			break
+		}
+		if len(r.chartNames) == 0 {
+			return nil, fmt.Errorf("could not list the charts of %q: %w", r.URL.String(), WrapRegistryError(r.URL.String(), err))
+		}
+		names = append(names, r.chartNames...)
+	case len(r.chartNames) > 0:
+		names = append(names, r.chartNames...)
+	default:
+		return nil, fmt.Errorf("listing the charts of %q is not supported by %T without chart names", r.URL.String(), r.RegistryClient)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// chartsUnder returns the names of the given repositories under the given
+// path, relative to it.
+func chartsUnder(repos []string, dir string) []string {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		if name := strings.TrimPrefix(repo, prefix); name != repo || prefix == "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// chartInfo returns the versions of the chart with the given name, and the
+// metadata of its latest version.
+func (r *OCIChartRepository) chartInfo(ctx context.Context, name string) (*ChartInfo, error) {
+	cpURL := r.URL
+	cpURL.Path = path.Join(cpURL.Path, name)
+	ref := cpURL.String()
+
+	tags, err := r.getTags(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	versions := r.versionPolicy.Versions(tags)
+	if len(versions) == 0 {
+		return nil, NewError(ErrChartNotFound, ref, fmt.Errorf("unable to locate any versions in provided repository"))
+	}
+
+	getter, ok := r.RegistryClient.(metadataGetter)
+	if !ok {
+		return nil, fmt.Errorf("reading the metadata of %q is not supported by %T", ref, r.RegistryClient)
+	}
+	href := strings.TrimPrefix(fmt.Sprintf("%s:%s", ref, versions[0]), fmt.Sprintf("%s://", cpURL.Scheme))
+	var md *chart.Metadata
+	err = r.retry(ctx, r.URL.Host, registry.OperationMetadata, func() (err error) {
+		md, err = getter.Metadata(ctx, href)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read the metadata of %q: %w", ref, WrapRegistryError(ref, err))
+	}
+	return &ChartInfo{Name: name, Versions: versions, Metadata: md}, nil
+}
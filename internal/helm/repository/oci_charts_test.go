@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// catalogRegistryClient is a mockRegistryClient listing the repositories of
+// its registry, and the tags and metadata of its charts, by repository.
+type catalogRegistryClient struct {
+	mockRegistryClient
+	repos      []string
+	catalogErr error
+	tagsOf     map[string][]string
+	metadataOf map[string]*chart.Metadata
+}
+
+func (c *catalogRegistryClient) Catalog(_ context.Context, _ string) ([]string, error) {
+	return c.repos, c.catalogErr
+}
+
+func (c *catalogRegistryClient) Tags(_ context.Context, ref string) ([]string, error) {
+	return c.tagsOf[ref], nil
+}
+
+func (c *catalogRegistryClient) Metadata(_ context.Context, ref string) (*chart.Metadata, error) {
+	repo := ref[:strings.LastIndex(ref, ":")]
+	md, ok := c.metadataOf[repo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", registry.ErrNotChart, ref)
+	}
+	return md, nil
+}
+
+func TestOCIChartRepository_ListCharts(t *testing.T) {
+	hello := &chart.Metadata{Name: "hello", Version: "0.2.0", Description: "Hello world", Keywords: []string{"greeting"}}
+	helloAgain := &chart.Metadata{Name: "hello-again", Version: "1.0.0", Description: "Another greeting"}
+	world := &chart.Metadata{Name: "world", Version: "0.1.0", Description: "The world", Icon: "world.png"}
+	newClient := func() *catalogRegistryClient {
+		return &catalogRegistryClient{
+			repos: []string{"my_repo/world", "my_repo/hello", "my_repo/hello-again", "my_repo/app", "my_repo/empty", "other/hello"},
+			tagsOf: map[string][]string{
+				"localhost:5000/my_repo/hello":       {"0.1.0", "0.2.0", "latest"},
+				"localhost:5000/my_repo/hello-again": {"1.0.0"},
+				"localhost:5000/my_repo/world":       {"0.1.0"},
+				"localhost:5000/my_repo/app":         {"1.0.0"},
+				"localhost:5000/my_repo/empty":       {"latest"},
+			},
+			metadataOf: map[string]*chart.Metadata{
+				"localhost:5000/my_repo/hello":       hello,
+				"localhost:5000/my_repo/hello-again": helloAgain,
+				"localhost:5000/my_repo/world":       world,
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		catalogErr error
+		opts       []OCIChartRepositoryOption
+		prefix     string
+		query      string
+		search     bool
+		want       []string
+		wantErr    string
+	}{
+		{
+			name: "all charts",
+			want: []string{"hello", "hello-again", "world"},
+		},
+		{
+			name:   "prefix",
+			prefix: "hello",
+			want:   []string{"hello", "hello-again"},
+		},
+		{
+			name:   "search by keyword",
+			search: true,
+			query:  "GREETING",
+			want:   []string{"hello", "hello-again"},
+		},
+		{
+			name:   "search by name",
+			search: true,
+			query:  "orl",
+			want:   []string{"hello", "world"},
+		},
+		{
+			name:       "chart names fallback",
+			catalogErr: errors.New("catalog not supported"),
+			opts:       []OCIChartRepositoryOption{WithChartNames("world", "hello")},
+			want:       []string{"hello", "world"},
+		},
+		{
+			name:       "catalog not supported",
+			catalogErr: errors.New("catalog not supported"),
+			wantErr:    "could not list the charts of \"oci://localhost:5000/my_repo\": catalog not supported",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			client := newClient()
+			client.catalogErr = tt.catalogErr
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo",
+				append([]OCIChartRepositoryOption{WithOCIRegistryClient(client)}, tt.opts...)...)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var charts []*ChartInfo
+			if tt.search {
+				charts, err = r.Search(tt.query)
+			} else {
+				charts, err = r.ListCharts(tt.prefix)
+			}
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			names := make([]string, 0, len(charts))
+			for _, c := range charts {
+				names = append(names, c.Name)
+			}
+			g.Expect(names).To(Equal(tt.want))
+		})
+	}
+
+	t.Run("versions and metadata", func(t *testing.T) {
+		g := NewWithT(t)
+
+		r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(newClient()))
+		g.Expect(err).ToNot(HaveOccurred())
+		charts, err := r.ListCharts("hello")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(charts).To(HaveLen(2))
+		g.Expect(charts[0]).To(Equal(&ChartInfo{Name: "hello", Versions: []string{"0.2.0", "0.1.0"}, Metadata: hello}))
+	})
+
+	t.Run("no catalog nor chart names", func(t *testing.T) {
+		g := NewWithT(t)
+
+		r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(&mockRegistryClient{}))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = r.ListCharts("")
+		g.Expect(err).To(MatchError(ContainSubstring("without chart names")))
+	})
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// chartDescriber is a RegistryClient which describes chart artifacts without
+// downloading their chart layer.
+type chartDescriber interface {
+	Describe(ctx context.Context, ref string) (*registry.ChartArtifact, error)
+}
+
+// IndexOption configures the index synthesized by OCIChartRepository.Index.
+type IndexOption func(*indexOptions)
+
+type indexOptions struct {
+	// downloadURL is the base URL of the chart archives, if set.
+	downloadURL string
+}
+
+// WithDownloadURL returns an IndexOption that will set the URLs of the chart
+// versions to '<baseURL>/<name>-<version>.tgz', like in classic chart
+// repositories, e.g. to download the charts through a proxy of the registry.
+func WithDownloadURL(baseURL string) IndexOption {
+	return func(o *indexOptions) {
+		o.downloadURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// Index returns the index of the charts of the repository, as served by a
+// classic chart repository, synthesized from the charts listed by ListCharts
+// and the manifests and config blobs of all their versions under the version
+// policy. The digest of a chart version is the digest of its chart archive,
+// i.e. of its chart layer, and its URL is its 'oci://' reference, unless
+// WithDownloadURL is set. Versions which are not charts, or whose metadata is
+// invalid, are not indexed.
+func (r *OCIChartRepository) Index(opts ...IndexOption) (_ *repo.IndexFile, err error) {
+	o := &indexOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ctx, span := registry.StartSpan(r.traceContext(), "OCIChartRepository.Index",
+		registry.AttributeRegistryHost.String(r.URL.Host))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	describer, ok := r.RegistryClient.(chartDescriber)
+	if !ok {
+		return nil, fmt.Errorf("indexing the charts of %q is not supported by %T", r.URL.String(), r.RegistryClient)
+	}
+	charts, err := r.ListCharts("")
+	if err != nil {
+		return nil, err
+	}
+
+	index := repo.NewIndexFile()
+	for _, c := range charts {
+		for _, v := range c.Versions {
+			cv, err := r.indexChartVersion(ctx, describer, c.Name, v, o)
+			if errors.Is(err, ErrChartNotFound) || errors.Is(err, registry.ErrNotChart) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if cv.Validate() != nil {
+				continue
+			}
+			index.Entries[c.Name] = append(index.Entries[c.Name], cv)
+		}
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// indexChartVersion returns the index entry of the given version of a chart.
+func (r *OCIChartRepository) indexChartVersion(ctx context.Context, describer chartDescriber, name, version string, o *indexOptions) (*repo.ChartVersion, error) {
+	cpURL := r.URL
+	cpURL.Path = path.Join(cpURL.Path, name)
+	ref := fmt.Sprintf("%s:%s", cpURL.String(), version)
+
+	var a *registry.ChartArtifact
+	err := r.retry(ctx, r.URL.Host, registry.OperationMetadata, func() (err error) {
+		a, err = describer.Describe(ctx, strings.TrimPrefix(ref, fmt.Sprintf("%s://", cpURL.Scheme)))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not describe %q: %w", ref, WrapRegistryError(ref, err))
+	}
+
+	url := ref
+	if o.downloadURL != "" {
+		url = fmt.Sprintf("%s/%s-%s.tgz", o.downloadURL, name, a.Metadata.Version)
+	}
+	return &repo.ChartVersion{
+		Metadata: a.Metadata,
+		URLs:     []string{url},
+		Created:  a.Created,
+		Digest:   a.ChartDigest.Hex,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// describingRegistryClient is a catalogRegistryClient describing every
+// version of its charts with the metadata of the chart.
+type describingRegistryClient struct {
+	catalogRegistryClient
+}
+
+func (c *describingRegistryClient) Describe(ctx context.Context, ref string) (*registry.ChartArtifact, error) {
+	md, err := c.Metadata(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	version := ref[strings.LastIndex(ref, ":")+1:]
+	if version == "0.0.1" {
+		// Invalid metadata
+		return &registry.ChartArtifact{Metadata: &chart.Metadata{}}, nil
+	}
+	return &registry.ChartArtifact{
+		Metadata: &chart.Metadata{
+			APIVersion:  chart.APIVersionV2,
+			Name:        md.Name,
+			Version:     version,
+			Description: md.Description,
+		},
+		ChartDigest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat(version[len(version)-1:], 64)},
+		Created:     time.Date(2023, 8, 18, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func TestOCIChartRepository_Index(t *testing.T) {
+	newClient := func() *describingRegistryClient {
+		return &describingRegistryClient{catalogRegistryClient{
+			repos: []string{"my_repo/hello", "my_repo/world", "my_repo/app"},
+			tagsOf: map[string][]string{
+				"localhost:5000/my_repo/hello": {"0.0.1", "0.1.0", "0.2.0", "latest"},
+				"localhost:5000/my_repo/world": {"1.0.0"},
+				"localhost:5000/my_repo/app":   {"1.0.0"},
+			},
+			metadataOf: map[string]*chart.Metadata{
+				"localhost:5000/my_repo/hello": {Name: "hello", Description: "Hello world"},
+				"localhost:5000/my_repo/world": {Name: "world", Description: "The world"},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		opts     []IndexOption
+		wantURLs []string
+	}{
+		{
+			name: "oci references",
+			wantURLs: []string{
+				"oci://localhost:5000/my_repo/hello:0.2.0",
+				"oci://localhost:5000/my_repo/hello:0.1.0",
+				"oci://localhost:5000/my_repo/world:1.0.0",
+			},
+		},
+		{
+			name: "download URL",
+			opts: []IndexOption{WithDownloadURL("https://charts.example.com/proxy/")},
+			wantURLs: []string{
+				"https://charts.example.com/proxy/hello-0.2.0.tgz",
+				"https://charts.example.com/proxy/hello-0.1.0.tgz",
+				"https://charts.example.com/proxy/world-1.0.0.tgz",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(newClient()))
+			g.Expect(err).ToNot(HaveOccurred())
+			index, err := r.Index(tt.opts...)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(index.APIVersion).To(Equal("v1"))
+			g.Expect(index.Entries).To(HaveLen(2))
+			g.Expect(index.Entries["hello"]).To(HaveLen(2))
+
+			var urls []string
+			for _, name := range []string{"hello", "world"} {
+				for _, cv := range index.Entries[name] {
+					urls = append(urls, cv.URLs...)
+				}
+			}
+			g.Expect(urls).To(Equal(tt.wantURLs))
+
+			cv, err := index.Get("hello", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cv.Version).To(Equal("0.2.0"))
+			g.Expect(cv.Description).To(Equal("Hello world"))
+			g.Expect(cv.Digest).To(Equal(strings.Repeat("0", 64)))
+			g.Expect(cv.Created).To(Equal(time.Date(2023, 8, 18, 0, 0, 0, 0, time.UTC)))
+		})
+	}
+
+	t.Run("not supported by the registry client", func(t *testing.T) {
+		g := NewWithT(t)
+
+		r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(&newClient().catalogRegistryClient))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = r.Index()
+		g.Expect(err).To(MatchError(fmt.Sprintf("indexing the charts of %q is not supported by *repository.catalogRegistryClient", "oci://localhost:5000/my_repo")))
+	})
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/oci"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// PushOption configures the chart artifact pushed by OCIChartRepository.Push.
+type PushOption func(*pushOptions)
+
+type pushOptions struct {
+	// provenance is the provenance file of the chart archive, if set.
+	provenance []byte
+	// source is the URL of the source of the chart, if set.
+	source string
+	// revision is the revision of the source of the chart, if set.
+	revision string
+	// created is the creation time of the artifact.
+	created time.Time
+}
+
+// WithProvenance returns a PushOption that will push the given provenance file
+// of the chart archive alongside it.
+func WithProvenance(prov []byte) PushOption {
+	return func(o *pushOptions) {
+		o.provenance = prov
+	}
+}
+
+// WithSource returns a PushOption that will annotate the artifact with the URL
+// of the source of the chart, e.g. its Git repository.
+func WithSource(source string) PushOption {
+	return func(o *pushOptions) {
+		o.source = source
+	}
+}
+
+// WithRevision returns a PushOption that will annotate the artifact with the
+// revision of the source of the chart, e.g. a Git commit.
+func WithRevision(revision string) PushOption {
+	return func(o *pushOptions) {
+		o.revision = revision
+	}
+}
+
+// WithCreated returns a PushOption that will annotate the artifact with the
+// given creation time, instead of the time of the push.
+func WithCreated(created time.Time) PushOption {
+	return func(o *pushOptions) {
+		o.created = created
+	}
+}
+
+// Push uploads the given chart archive to the repository as the artifact
+// '<repository>/<name>:<version>' of the chart, with the Helm media types, and
+// returns its reference, the digest of its manifest and the metadata of the chart. The manifest is annotated with the
+// source, revision and creation time of the artifact, using the
+// 'org.opencontainers.image.*' annotations. The chart is pushed with the
+// credentials and TLS configuration of the repository, retrying transient
+// failures according to the retry policy. The push replaces the artifact of
+// the version, if any.
+func (r *OCIChartRepository) Push(archive []byte, opts ...PushOption) (res *registry.PushResult, err error) {
+	o := &pushOptions{created: time.Now()}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ctx, span := registry.StartSpan(r.traceContext(), "OCIChartRepository.Push",
+		registry.AttributeRegistryHost.String(r.URL.Host))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	pushOpts := registry.PushOptions{
+		Provenance: o.provenance,
+		Annotations: map[string]string{
+			oci.CreatedAnnotation: o.created.UTC().Format(time.RFC3339),
+		},
+	}
+	if o.source != "" {
+		pushOpts.Annotations[oci.SourceAnnotation] = o.source
+	}
+	if o.revision != "" {
+		pushOpts.Annotations[oci.RevisionAnnotation] = o.revision
+	}
+
+	ref := r.URL.String()
+	err = r.retry(ctx, r.URL.Host, registry.OperationPush, func() (err error) {
+		res, err = r.RegistryClient.Push(ctx, strings.TrimPrefix(ref, fmt.Sprintf("%s://", helmreg.OCIScheme)), archive, pushOpts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not push chart to %q: %w", ref, WrapRegistryError(ref, err))
+	}
+	span.SetAttributes(registry.AttributeChart.String(res.Metadata.Name),
+		registry.AttributeVersion.String(res.Metadata.Version),
+		registry.AttributeDigest.String(res.Digest))
+
+	cpURL := r.URL
+	cpURL.Path = path.Join(cpURL.Path, res.Metadata.Name)
+	r.forgetTags(strings.TrimPrefix(cpURL.String(), fmt.Sprintf("%s://", helmreg.OCIScheme)))
+	return res, nil
+}
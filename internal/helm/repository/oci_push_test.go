@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// pushingRegistryClient is a mockRegistryClient recording the charts pushed
+// to it, failing the first pushes with the given errors.
+type pushingRegistryClient struct {
+	mockRegistryClient
+	errs     []error
+	calls    int
+	lastRepo string
+	lastOpts registry.PushOptions
+}
+
+func (c *pushingRegistryClient) Push(_ context.Context, repo string, _ []byte, opts registry.PushOptions) (*registry.PushResult, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	c.lastRepo = repo
+	c.lastOpts = opts
+	c.tags = append(c.tags, "0.2.0")
+	return &registry.PushResult{
+		Ref:      repo + "/podinfo:0.2.0",
+		Digest:   mockDigest,
+		Metadata: &chart.Metadata{Name: "podinfo", Version: "0.2.0"},
+	}, nil
+}
+
+func TestOCIChartRepository_Push(t *testing.T) {
+	created := time.Date(2023, 8, 18, 10, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name            string
+		errs            []error
+		opts            []PushOption
+		wantCalls       int
+		wantAnnotations map[string]string
+		wantErr         string
+	}{
+		{
+			name: "annotations",
+			opts: []PushOption{WithSource("https://github.com/stefanprodan/podinfo"), WithRevision("6.4.1@sha1:4b1b9b3"), WithCreated(created)},
+			wantAnnotations: map[string]string{
+				"org.opencontainers.image.source":   "https://github.com/stefanprodan/podinfo",
+				"org.opencontainers.image.revision": "6.4.1@sha1:4b1b9b3",
+				"org.opencontainers.image.created":  "2023-08-18T08:00:00Z",
+			},
+			wantCalls: 1,
+		},
+		{
+			name:            "transient error",
+			errs:            []error{&transport.Error{StatusCode: http.StatusServiceUnavailable}},
+			opts:            []PushOption{WithCreated(created)},
+			wantAnnotations: map[string]string{"org.opencontainers.image.created": "2023-08-18T08:00:00Z"},
+			wantCalls:       2,
+		},
+		{
+			name:      "permanent error",
+			errs:      []error{&transport.Error{StatusCode: http.StatusForbidden}},
+			wantCalls: 1,
+			wantErr:   "could not push chart to \"oci://localhost:5000/my_repo\"",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			recordSleeps(t)
+
+			client := &pushingRegistryClient{errs: tt.errs}
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client),
+				WithRetryPolicy(RetryPolicy{MaxRetries: 2, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			res, err := r.Push([]byte("chart"), tt.opts...)
+			g.Expect(client.calls).To(Equal(tt.wantCalls))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(res.Digest).To(Equal(mockDigest))
+			g.Expect(res.Metadata.Version).To(Equal("0.2.0"))
+			g.Expect(client.lastRepo).To(Equal("localhost:5000/my_repo"))
+			g.Expect(client.lastOpts.Annotations).To(Equal(tt.wantAnnotations))
+		})
+	}
+
+	t.Run("provenance", func(t *testing.T) {
+		g := NewWithT(t)
+
+		client := &pushingRegistryClient{}
+		r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client))
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = r.Push([]byte("chart"), WithProvenance([]byte("prov")))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(client.lastOpts.Provenance).To(Equal([]byte("prov")))
+		g.Expect(client.lastOpts.Annotations).To(HaveKey("org.opencontainers.image.created"))
+	})
+
+	t.Run("forgets cached tags", func(t *testing.T) {
+		g := NewWithT(t)
+
+		client := &pushingRegistryClient{mockRegistryClient: mockRegistryClient{tags: []string{"0.1.0"}}}
+		r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client),
+			WithTagCache("default/repo", cache.New(10, 0), time.Hour, nil))
+		g.Expect(err).ToNot(HaveOccurred())
+		versions, err := r.ListChartVersions("podinfo")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(versions).To(Equal([]string{"0.1.0"}))
+
+		_, err = r.Push([]byte("chart"))
+		g.Expect(err).ToNot(HaveOccurred())
+		versions, err = r.ListChartVersions("podinfo")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(versions).To(Equal([]string{"0.2.0", "0.1.0"}))
+	})
+}
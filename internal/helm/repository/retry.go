@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// RetryPolicy configures the retries of the registry operations of an
+// OCIChartRepository, i.e. listing tags and downloading charts.
+// Only transient errors are retried, see IsTransient.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the first attempt.
+	// Zero disables retries.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry. It doubles after every retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay before a retry. If the registry asks to
+	// retry after a longer delay with a 'Retry-After' header, the operation fails.
+	MaxBackoff time.Duration
+	// Jitter is the fraction, between 0 and 1, of the delay which is randomized,
+	// so that clients failing together do not retry together.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the RetryPolicy of an OCIChartRepository if none is set.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+}
+
+// sleep waits for the given duration, or until the given context is done, in
+// which case it returns the error of the context. It is replaced in tests.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// do calls fn until it succeeds, it fails with an error which is not transient,
+// the retries are exhausted, or the given context is done, e.g. because the
+// caller gave up. It returns the last error of fn.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.MaxRetries || ctx.Err() != nil || !IsTransient(err) {
+			return err
+		}
+		d := p.backoff(attempt)
+		var retryAfter *registry.RetryAfterError
+		if errors.As(err, &retryAfter) {
+			if p.MaxBackoff > 0 && retryAfter.RetryAfter > p.MaxBackoff {
+				return err
+			}
+			if retryAfter.RetryAfter > d {
+				d = retryAfter.RetryAfter
+			}
+		}
+		if sleep(ctx, d) != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns the delay before the given retry, starting at 0.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 && d > 0 {
+		// Randomize the delay in [d*(1-Jitter), d*(1+Jitter))
+		d += time.Duration(p.Jitter * float64(d) * (2*rand.Float64() - 1))
+	}
+	return d
+}
+
+// IsTransient returns true if the given error of a registry operation is
+// likely to be temporary, so the operation may succeed if it is retried:
+// timeouts of requests, connection resets, and the '429 Too Many Requests',
+// '502 Bad Gateway', '503 Service Unavailable' and '504 Gateway Timeout'
+// responses. The cancellation of an operation, or the expiry of its deadline,
+// is the decision of its caller, and is not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var retryAfter *registry.RetryAfterError
+	if errors.As(err, &retryAfter) {
+		return true
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		switch terr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
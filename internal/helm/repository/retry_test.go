@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// flakyRegistryClient fails to list the tags with the given errors, before succeeding.
+type flakyRegistryClient struct {
+	mockRegistryClient
+	errs  []error
+	calls int
+}
+
+func (c *flakyRegistryClient) Tags(ctx context.Context, urlStr string) ([]string, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return c.mockRegistryClient.Tags(ctx, urlStr)
+}
+
+// recordSleeps replaces sleep with a function recording the delays for the duration of the test.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() {
+		sleep = orig
+	})
+	return &delays
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "too many requests", err: &transport.Error{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "bad gateway", err: &transport.Error{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "service unavailable", err: &transport.Error{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "gateway timeout", err: fmt.Errorf("wrapped: %w", &transport.Error{StatusCode: http.StatusGatewayTimeout}), want: true},
+		{name: "not found", err: &transport.Error{StatusCode: http.StatusNotFound}, want: false},
+		{name: "unauthorized", err: &transport.Error{StatusCode: http.StatusUnauthorized}, want: false},
+		{name: "retry after", err: &registry.RetryAfterError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second}, want: true},
+		{name: "deadline exceeded", err: fmt.Errorf("wrapped: %w", context.DeadlineExceeded), want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "timeout", err: &net.DNSError{IsTimeout: true}, want: true},
+		{name: "other", err: errors.New("invalid reference"), want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(IsTransient(tt.err)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	g := NewWithT(t)
+
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	g.Expect(p.backoff(0)).To(Equal(time.Second))
+	g.Expect(p.backoff(1)).To(Equal(2 * time.Second))
+	g.Expect(p.backoff(2)).To(Equal(4 * time.Second))
+	g.Expect(p.backoff(3)).To(Equal(5 * time.Second))
+	g.Expect(p.backoff(100)).To(Equal(5 * time.Second))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		g.Expect(p.backoff(1)).To(And(
+			BeNumerically(">=", time.Second),
+			BeNumerically("<", 3*time.Second),
+		))
+	}
+}
+
+func TestRetryPolicy_doContext(t *testing.T) {
+	unavailable := &transport.Error{StatusCode: http.StatusServiceUnavailable}
+	p := RetryPolicy{MaxRetries: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		done := make(chan error)
+		go func() {
+			done <- p.do(ctx, func() error {
+				calls++
+				return unavailable
+			})
+		}()
+		cancel()
+		select {
+		case err := <-done:
+			g.Expect(err).To(MatchError(unavailable))
+			g.Expect(calls).To(Equal(1))
+		case <-time.After(5 * time.Second):
+			t.Fatal("retries did not stop when the context was cancelled")
+		}
+	})
+
+	t.Run("already done", func(t *testing.T) {
+		g := NewWithT(t)
+		sleeps := recordSleeps(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+		calls := 0
+		err := p.do(ctx, func() error {
+			calls++
+			return unavailable
+		})
+		g.Expect(err).To(MatchError(unavailable))
+		g.Expect(calls).To(Equal(1))
+		g.Expect(*sleeps).To(BeEmpty())
+	})
+}
+
+// collectedSamples returns the values of the counters, and the sample counts of
+// the histograms, recorded by the given recorder, by metric name.
+func collectedSamples(g *WithT, recorder *registry.MetricsRecorder) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(recorder.Collectors()...)
+	families, err := reg.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	samples := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				samples[f.GetName()] += float64(h.GetSampleCount())
+			} else {
+				samples[f.GetName()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return samples
+}
+
+func TestOCIChartRepository_Retry(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	unavailable := &transport.Error{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name       string
+		errs       []error
+		wantCalls  int
+		wantSleeps []time.Duration
+		wantErr    bool
+	}{
+		{name: "no error", wantCalls: 1},
+		{name: "transient errors", errs: []error{unavailable, unavailable}, wantCalls: 3, wantSleeps: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "retries exhausted", errs: []error{unavailable, unavailable, unavailable}, wantCalls: 3, wantSleeps: []time.Duration{time.Second, 2 * time.Second}, wantErr: true},
+		{name: "permanent error", errs: []error{&transport.Error{StatusCode: http.StatusNotFound}}, wantCalls: 1, wantErr: true},
+		{
+			name:       "retry after",
+			errs:       []error{&registry.RetryAfterError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}},
+			wantCalls:  2,
+			wantSleeps: []time.Duration{5 * time.Second},
+		},
+		{
+			name:      "retry after exceeding the maximum backoff",
+			errs:      []error{&registry.RetryAfterError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			sleeps := recordSleeps(t)
+
+			client := &flakyRegistryClient{mockRegistryClient: mockRegistryClient{tags: []string{"1.0.0"}}, errs: tt.errs}
+			recorder := registry.NewMetricsRecorder()
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client), WithRetryPolicy(policy), WithMetricsRecorder(recorder))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			tags, err := r.ListChartVersions("podinfo")
+			g.Expect(client.calls).To(Equal(tt.wantCalls))
+			g.Expect(*sleeps).To(Equal(tt.wantSleeps))
+			g.Expect(collectedSamples(g, recorder)["chartloader_retries_total"]).To(Equal(float64(tt.wantCalls - 1)))
+			if tt.wantErr {
+				g.Expect(err).To(MatchError(tt.errs[len(tt.errs)-1]))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(tags).To(Equal([]string{"1.0.0"}))
+		})
+	}
+}
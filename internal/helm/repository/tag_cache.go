@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// tagLister is a RegistryClient whose tag listings can be revalidated.
+type tagLister interface {
+	ListTags(ctx context.Context, ref string, prev *registry.TagList) (*registry.TagList, bool, error)
+}
+
+// cachedTags is the tag listing of a chart stored in the tag cache.
+type cachedTags struct {
+	// list is the listing of the tags, with its validators if the registry
+	// client can revalidate listings.
+	list *registry.TagList
+	// expiresAt is the time the listing becomes stale.
+	expiresAt time.Time
+}
+
+// listTags returns the tags of the given chart reference from the tag cache if
+// the cached listing is fresh, or else from the registry, revalidating the
+// stale cached listing, if any.
+func (r *OCIChartRepository) listTags(ctx context.Context, ref string) ([]string, error) {
+	if r.tagCache == nil {
+		list, _, err := r.fetchTags(ctx, ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		return list.Tags, nil
+	}
+
+	key := fmt.Sprintf("%s/%s", r.tagCacheKey, ref)
+	var prev *registry.TagList
+	if v, found := r.tagCache.Get(key); found {
+		entry := v.(*cachedTags)
+		if !r.forceRefresh && time.Now().Before(entry.expiresAt) {
+			r.recordTagCacheEvent(cache.CacheEventTypeHit)
+			return entry.list.Tags, nil
+		}
+		prev = entry.list
+	}
+
+	list, modified, err := r.fetchTags(ctx, ref, prev)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case prev == nil:
+		r.recordTagCacheEvent(cache.CacheEventTypeMiss)
+	case !modified:
+		r.recordTagCacheEvent(cache.CacheEventTypeRevalidated)
+	default:
+		r.recordTagCacheEvent(cache.CacheEventTypeUpdate)
+	}
+
+	// A full cache does not cache the listings of other charts
+	_ = r.tagCache.Set(key, &cachedTags{list: list, expiresAt: time.Now().Add(r.tagCacheTTL)}, 2*r.tagCacheTTL)
+	return list.Tags, nil
+}
+
+// forgetTags removes the cached tag listing of the given chart reference, if
+// any, e.g. after pushing a new version of the chart.
+func (r *OCIChartRepository) forgetTags(ref string) {
+	if r.tagCache == nil {
+		return
+	}
+	r.tagCache.Delete(fmt.Sprintf("%s/%s", r.tagCacheKey, ref))
+}
+
+// fetchTags lists the tags of the given chart reference with the registry client,
+// revalidating the previous listing, if any. All tags are listed if the registry
+// client is a tagLister, and only those returned by its Tags method otherwise.
+func (r *OCIChartRepository) fetchTags(ctx context.Context, ref string, prev *registry.TagList) (*registry.TagList, bool, error) {
+	if lister, ok := r.RegistryClient.(tagLister); ok {
+		return lister.ListTags(ctx, ref, prev)
+	}
+	tags, err := r.RegistryClient.Tags(ctx, ref)
+	if err != nil {
+		return nil, false, err
+	}
+	return &registry.TagList{Tags: tags}, true, nil
+}
+
+// recordTagCacheEvent records the given tag cache event, if the repository records them.
+func (r *OCIChartRepository) recordTagCacheEvent(event string) {
+	if r.recordTagCacheMetric != nil {
+		r.recordTagCacheMetric(event)
+	}
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// revalidatingRegistryClient lists its tags with its ETag, and reports a
+// listing revalidated with the same ETag as not modified.
+type revalidatingRegistryClient struct {
+	mockRegistryClient
+	etag  string
+	calls int
+}
+
+func (c *revalidatingRegistryClient) ListTags(_ context.Context, _ string, prev *registry.TagList) (*registry.TagList, bool, error) {
+	c.calls++
+	if prev != nil && prev.ETag == c.etag {
+		return prev, false, nil
+	}
+	return &registry.TagList{Tags: c.tags, ETag: c.etag}, true, nil
+}
+
+func TestOCIChartRepository_TagCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		forceRefresh bool
+		addTag       bool
+		wantCalls    int
+		wantEvents   []string
+		wantTags     []string
+	}{
+		{
+			name:       "fresh listing",
+			ttl:        time.Hour,
+			addTag:     true,
+			wantCalls:  1,
+			wantEvents: []string{cache.CacheEventTypeMiss, cache.CacheEventTypeHit},
+			wantTags:   []string{"0.2.0", "0.1.0"},
+		},
+		{
+			name:       "stale listing not modified",
+			wantCalls:  2,
+			wantEvents: []string{cache.CacheEventTypeMiss, cache.CacheEventTypeRevalidated},
+			wantTags:   []string{"0.2.0", "0.1.0"},
+		},
+		{
+			name:       "stale listing modified",
+			addTag:     true,
+			wantCalls:  2,
+			wantEvents: []string{cache.CacheEventTypeMiss, cache.CacheEventTypeUpdate},
+			wantTags:   []string{"0.3.0", "0.2.0", "0.1.0"},
+		},
+		{
+			name:         "force refresh",
+			ttl:          time.Hour,
+			forceRefresh: true,
+			addTag:       true,
+			wantCalls:    2,
+			wantEvents:   []string{cache.CacheEventTypeMiss, cache.CacheEventTypeUpdate},
+			wantTags:     []string{"0.3.0", "0.2.0", "0.1.0"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			client := &revalidatingRegistryClient{mockRegistryClient: mockRegistryClient{tags: []string{"0.1.0", "0.2.0", "latest"}}, etag: "1"}
+			c := cache.New(10, 0)
+			var events []string
+			rec := func(event string) {
+				events = append(events, event)
+			}
+
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client), WithTagCache("default/repo", c, tt.ttl, rec))
+			g.Expect(err).ToNot(HaveOccurred())
+			_, err = r.ListChartVersions("podinfo")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			if tt.addTag {
+				client.tags = append(client.tags, "0.3.0")
+				client.etag = "2"
+			}
+			opts := []OCIChartRepositoryOption{WithOCIRegistryClient(client), WithTagCache("default/repo", c, tt.ttl, rec)}
+			if tt.forceRefresh {
+				opts = append(opts, WithForceRefresh())
+			}
+			r, err = NewOCIChartRepository("oci://localhost:5000/my_repo", opts...)
+			g.Expect(err).ToNot(HaveOccurred())
+			tags, err := r.ListChartVersions("podinfo")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(tags).To(Equal(tt.wantTags))
+			g.Expect(client.calls).To(Equal(tt.wantCalls))
+			g.Expect(events).To(Equal(tt.wantEvents))
+		})
+	}
+
+	t.Run("scoped by key", func(t *testing.T) {
+		g := NewWithT(t)
+
+		client := &revalidatingRegistryClient{mockRegistryClient: mockRegistryClient{tags: []string{"0.1.0"}}}
+		c := cache.New(10, 0)
+		for _, key := range []string{"default/a", "default/b"} {
+			r, err := NewOCIChartRepository("oci://localhost:5000/my_repo", WithOCIRegistryClient(client), WithTagCache(key, c, time.Hour, nil))
+			g.Expect(err).ToNot(HaveOccurred())
+			_, err = r.ListChartVersions("podinfo")
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+		g.Expect(client.calls).To(Equal(2))
+	})
+}
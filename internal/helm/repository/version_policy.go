@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/version"
+)
+
+// The names of the version policies.
+const (
+	// VersionPolicySemver selects semantic versions, e.g. '1.2.3'.
+	VersionPolicySemver = "semver"
+	// VersionPolicyCalVer selects calendar versions, e.g. 'v2023.08.18'.
+	VersionPolicyCalVer = "calver"
+	// VersionPolicyNumerical selects numbers, e.g. build numbers or timestamps.
+	VersionPolicyNumerical = "numerical"
+	// VersionPolicyAlphabetical selects the tags in alphabetical order.
+	VersionPolicyAlphabetical = "alphabetical"
+)
+
+// DefaultCalVerLayout is the default time layout of the date of calendar
+// versions, i.e. 'YYYY.MM.DD', with or without leading zeros.
+const DefaultCalVerLayout = "2006.1.2"
+
+// VersionPolicy selects the tag of a chart among the tags of its repository,
+// e.g. the latest semantic version matching a constraint.
+type VersionPolicy interface {
+	// Exact returns true if the requested version is the tag of the chart
+	// itself, as is, which is then pulled without listing the tags. Requested
+	// versions the policy normalizes before matching them with the tags, e.g.
+	// a calendar version without its leading zeros, are not exact.
+	Exact(requested string) bool
+	// Versions returns the tags which are versions under the policy, from the
+	// latest to the oldest.
+	Versions(tags []string) []string
+	// Latest returns the latest of the tags matching the requested version.
+	// An empty version, or '*', requests the latest version.
+	Latest(tags []string, requested string) (string, error)
+}
+
+// NewVersionPolicy returns the version policy with the given name, the semver
+// policy if the name is empty. Prereleases are included in the latest version
+// of the semver and calver policies if prereleases is true, when no version
+// or '*' is requested. The calver policy
+// parses the dates of the versions with the given time layout, or
+// DefaultCalVerLayout if it is empty.
+func NewVersionPolicy(name string, prereleases bool, layout string) (VersionPolicy, error) {
+	switch name {
+	case VersionPolicySemver, "":
+		return NewSemverPolicy(prereleases), nil
+	case VersionPolicyCalVer:
+		return NewCalVerPolicy(layout, prereleases), nil
+	case VersionPolicyNumerical:
+		return NewNumericalPolicy(), nil
+	case VersionPolicyAlphabetical:
+		return NewAlphabeticalPolicy(), nil
+	default:
+		return nil, fmt.Errorf("unsupported version policy '%s'", name)
+	}
+}
+
+// comparablePolicy selects the tags which parse to semantic versions, matching
+// the requested versions as semver constraints.
+type comparablePolicy struct {
+	// parse returns the semantic version of the given tag.
+	parse func(tag string) (*semver.Version, error)
+	// exact returns true if the requested version is a tag as is, if set.
+	exact func(requested string) bool
+	// prereleases includes prereleases in the latest version, when no
+	// version or '*' is requested.
+	prereleases bool
+}
+
+// NewSemverPolicy returns the VersionPolicy selecting semantic versions, with
+// or without a 'v' prefix, with semver constraints. If prereleases is true,
+// the latest version may be a prerelease when no version or '*' is requested,
+// like with the '--devel' flag of Helm. Constraints only match prereleases if
+// they have a prerelease themselves, e.g. '>=1.0.0-0', whether prereleases is
+// true or not. As '+' is not allowed in OCI tags, '_' in tags is read as '+',
+// like Helm does. Only complete versions, e.g. '1.2.3' or 'v1.2.3', are exact.
+func NewSemverPolicy(prereleases bool) VersionPolicy {
+	return &comparablePolicy{
+		parse: func(tag string) (*semver.Version, error) {
+			return version.ParseVersion(strings.ReplaceAll(tag, "_", "+"))
+		},
+		exact: func(requested string) bool {
+			_, err := semver.StrictNewVersion(strings.TrimPrefix(requested, "v"))
+			return err == nil
+		},
+		prereleases: prereleases,
+	}
+}
+
+// NewCalVerPolicy returns the VersionPolicy selecting calendar versions, made of
+// a date in the given time layout, or DefaultCalVerLayout if it is empty, with
+// an optional 'v' prefix and '-prerelease' suffix, e.g. 'v2023.08.18-rc.0'.
+// The requested versions are matched as semver constraints on the
+// 'year.month.day' of the dates, e.g. '>=2023.8.1' or '~2023.8', so none is
+// exact. Prereleases are included in the latest version if prereleases is
+// true, when no version or '*' is requested.
+func NewCalVerPolicy(layout string, prereleases bool) VersionPolicy {
+	if layout == "" {
+		layout = DefaultCalVerLayout
+	}
+	return &comparablePolicy{
+		parse: func(tag string) (*semver.Version, error) {
+			return parseCalVer(layout, tag)
+		},
+		prereleases: prereleases,
+	}
+}
+
+// parseCalVer returns the semantic version 'year.month.day[-prerelease]' of
+// the given calendar version.
+func parseCalVer(layout, tag string) (*semver.Version, error) {
+	s := strings.TrimPrefix(tag, "v")
+	date, prerelease := s, ""
+	t, err := time.Parse(layout, date)
+	// The layout may contain '-' too, so the prerelease starts at the first
+	// '-' after a valid date
+	for i := 0; err != nil && i < len(s); i++ {
+		if s[i] != '-' {
+			continue
+		}
+		date, prerelease = s[:i], s[i+1:]
+		if t, err = time.Parse(layout, date); err == nil && prerelease == "" {
+			err = fmt.Errorf("empty prerelease")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar version '%s': %w", tag, err)
+	}
+	v := semver.New(uint64(t.Year()), uint64(t.Month()), uint64(t.Day()), "", "")
+	if prerelease != "" {
+		pv, err := v.SetPrerelease(prerelease)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar version '%s': %w", tag, err)
+		}
+		v = &pv
+	}
+	return v, nil
+}
+
+func (p *comparablePolicy) Exact(requested string) bool {
+	return p.exact != nil && p.exact(requested)
+}
+
+// taggedVersion is the semantic version of a tag.
+type taggedVersion struct {
+	tag     string
+	version *semver.Version
+}
+
+// sorted returns the tags which parse to versions, from the latest to the oldest.
+func (p *comparablePolicy) sorted(tags []string) []taggedVersion {
+	versions := make([]taggedVersion, 0, len(tags))
+	for _, tag := range tags {
+		v, err := p.parse(tag)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, taggedVersion{tag: tag, version: v})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].version.GreaterThan(versions[j].version)
+	})
+	return versions
+}
+
+// Versions returns the tags which are versions, from the latest to the oldest.
+// '_' in tags is read as '+' for semantic versions.
+func (p *comparablePolicy) Versions(tags []string) []string {
+	versions := p.sorted(tags)
+	result := make([]string, 0, len(versions))
+	for _, v := range versions {
+		result = append(result, strings.ReplaceAll(v.tag, "_", "+"))
+	}
+	return result
+}
+
+func (p *comparablePolicy) Latest(tags []string, requested string) (string, error) {
+	versions := p.sorted(tags)
+
+	// Check for exact matches first
+	if requested != "" {
+		for _, v := range versions {
+			if requested == v.tag || requested == strings.ReplaceAll(v.tag, "_", "+") {
+				return v.tag, nil
+			}
+		}
+	}
+
+	// Continue to look for a (semantic) version match
+	constraint := requested
+	if requested == "" || requested == "*" {
+		constraint = "*"
+		if p.prereleases {
+			constraint = ">=0.0.0-0"
+		}
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range versions {
+		if c.Check(v.version) {
+			return v.tag, nil
+		}
+	}
+	return "", fmt.Errorf("could not locate a version matching provided version string %s", requested)
+}
+
+// numericalPolicy selects the tags which are numbers, the latest being the highest.
+type numericalPolicy struct{}
+
+// NewNumericalPolicy returns the VersionPolicy selecting the tags which are
+// numbers, e.g. build numbers or timestamps, the latest being the highest.
+// The requested version is either a tag, or empty for the latest version.
+func NewNumericalPolicy() VersionPolicy {
+	return numericalPolicy{}
+}
+
+func (numericalPolicy) Exact(requested string) bool {
+	_, err := strconv.ParseFloat(requested, 64)
+	return err == nil
+}
+
+func (numericalPolicy) Versions(tags []string) []string {
+	type number struct {
+		tag   string
+		value float64
+	}
+	numbers := make([]number, 0, len(tags))
+	for _, tag := range tags {
+		v, err := strconv.ParseFloat(tag, 64)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number{tag: tag, value: v})
+	}
+	sort.SliceStable(numbers, func(i, j int) bool {
+		return numbers[i].value > numbers[j].value
+	})
+	result := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		result = append(result, n.tag)
+	}
+	return result
+}
+
+func (p numericalPolicy) Latest(tags []string, requested string) (string, error) {
+	return latestOf(p.Versions(tags), requested)
+}
+
+// alphabeticalPolicy selects all tags, the latest being the last in alphabetical order.
+type alphabeticalPolicy struct{}
+
+// NewAlphabeticalPolicy returns the VersionPolicy selecting all tags, the
+// latest being the last in alphabetical order, e.g. of 'RELEASE.2023-08-18'
+// tags. The requested version is either a tag, or empty for the latest version.
+func NewAlphabeticalPolicy() VersionPolicy {
+	return alphabeticalPolicy{}
+}
+
+func (alphabeticalPolicy) Exact(requested string) bool {
+	return requested != "" && requested != "*"
+}
+
+func (alphabeticalPolicy) Versions(tags []string) []string {
+	result := append([]string(nil), tags...)
+	sort.Sort(sort.Reverse(sort.StringSlice(result)))
+	return result
+}
+
+func (p alphabeticalPolicy) Latest(tags []string, requested string) (string, error) {
+	return latestOf(p.Versions(tags), requested)
+}
+
+// latestOf returns the first of the given versions, sorted from the latest to
+// the oldest, if the requested version is empty, or else the requested version
+// if it is one of them.
+func latestOf(versions []string, requested string) (string, error) {
+	if requested == "" || requested == "*" {
+		if len(versions) > 0 {
+			return versions[0], nil
+		}
+	} else {
+		for _, v := range versions {
+			if v == requested {
+				return v, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("could not locate a version matching provided version string %s", requested)
+}
+
+// regexPolicy selects the tags matching a pattern by the values extracted from
+// them, with another policy.
+type regexPolicy struct {
+	pattern *regexp.Regexp
+	extract string
+	policy  VersionPolicy
+}
+
+// NewRegexPolicy returns the VersionPolicy selecting the tags matching the
+// given regular expression, by comparing the values extracted from them with
+// the given policy. The value of a tag is the given template expanded with the
+// submatches of the pattern, e.g. '$ts' for a 'main-(?P<ts>[0-9]+)' pattern,
+// or the whole match if the template is empty. The requested version is
+// matched with the extracted values.
+func NewRegexPolicy(pattern, extract string, policy VersionPolicy) (VersionPolicy, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version pattern '%s': %w", pattern, err)
+	}
+	if extract == "" {
+		extract = "$0"
+	}
+	return &regexPolicy{pattern: re, extract: extract, policy: policy}, nil
+}
+
+// Exact returns false, as the requested version is matched with the values
+// extracted from the tags, which have to be listed.
+func (p *regexPolicy) Exact(string) bool {
+	return false
+}
+
+// values returns the values extracted from the tags matching the pattern, and
+// the tags they were extracted from. Tags with the same value as a previous
+// tag are ignored.
+func (p *regexPolicy) values(tags []string) ([]string, map[string]string) {
+	values := make([]string, 0, len(tags))
+	tagOf := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		match := p.pattern.FindStringSubmatchIndex(tag)
+		if match == nil {
+			continue
+		}
+		v := string(p.pattern.ExpandString(nil, p.extract, tag, match))
+		if _, ok := tagOf[v]; ok {
+			continue
+		}
+		values = append(values, v)
+		tagOf[v] = tag
+	}
+	return values, tagOf
+}
+
+func (p *regexPolicy) Versions(tags []string) []string {
+	values, tagOf := p.values(tags)
+	versions := p.policy.Versions(values)
+	result := make([]string, 0, len(versions))
+	for _, v := range versions {
+		// Semantic versions are returned with '+' instead of '_'
+		tag, ok := tagOf[v]
+		if !ok {
+			tag = tagOf[strings.ReplaceAll(v, "+", "_")]
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
+func (p *regexPolicy) Latest(tags []string, requested string) (string, error) {
+	values, tagOf := p.values(tags)
+	v, err := p.policy.Latest(values, requested)
+	if err != nil {
+		return "", err
+	}
+	return tagOf[v], nil
+}
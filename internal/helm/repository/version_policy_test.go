@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestVersionPolicy(t *testing.T) {
+	semverTags := []string{"0.1.0", "v0.2.0", "0.3.0-rc.1", "0.1.5_build.1", "latest"}
+	calverTags := []string{"v2023.08.18", "v2023.10.2", "v2023.10.9-rc.0", "v2023.9.30", "v2023.13.1", "latest"}
+	numericalTags := []string{"9", "10", "1.5", "latest"}
+	alphabeticalTags := []string{"RELEASE.2023-08-18", "RELEASE.2023-10-02", "RELEASE.2023-09-30"}
+	regexTags := []string{"main-abc-1690000000", "main-def-1700000000", "dev-ghi-1800000000", "latest"}
+
+	mustRegex := func(pattern, extract string, policy VersionPolicy) VersionPolicy {
+		p, err := NewRegexPolicy(pattern, extract, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name         string
+		policy       VersionPolicy
+		tags         []string
+		requested    string
+		wantExact    bool
+		wantLatest   string
+		wantErr      string
+		wantVersions []string
+	}{
+		{
+			name:         "semver latest stable",
+			policy:       NewSemverPolicy(false),
+			tags:         semverTags,
+			wantLatest:   "v0.2.0",
+			wantVersions: []string{"0.3.0-rc.1", "v0.2.0", "0.1.5+build.1", "0.1.0"},
+		},
+		{
+			name:       "semver latest with prereleases",
+			policy:     NewSemverPolicy(true),
+			tags:       semverTags,
+			requested:  "*",
+			wantLatest: "0.3.0-rc.1",
+		},
+		{
+			name:       "semver constraint",
+			policy:     NewSemverPolicy(true),
+			tags:       semverTags,
+			requested:  "~0.1",
+			wantLatest: "0.1.5_build.1",
+		},
+		{
+			name:      "semver exact",
+			policy:    NewSemverPolicy(false),
+			requested: "0.1.0",
+			wantExact: true,
+		},
+		{
+			name:       "semver incomplete version",
+			policy:     NewSemverPolicy(false),
+			tags:       semverTags,
+			requested:  "0.2",
+			wantLatest: "v0.2.0",
+		},
+		{
+			name:       "semver constraint with prereleases",
+			policy:     NewSemverPolicy(true),
+			tags:       semverTags,
+			requested:  ">=0.2.0",
+			wantLatest: "v0.2.0",
+		},
+		{
+			name:      "semver no match",
+			policy:    NewSemverPolicy(false),
+			tags:      semverTags,
+			requested: ">=1.0.0",
+			wantErr:   "could not locate a version matching provided version string >=1.0.0",
+		},
+		{
+			name:         "calver latest stable",
+			policy:       NewCalVerPolicy("", false),
+			tags:         calverTags,
+			wantLatest:   "v2023.10.2",
+			wantVersions: []string{"v2023.10.9-rc.0", "v2023.10.2", "v2023.9.30", "v2023.08.18"},
+		},
+		{
+			name:       "calver latest with prereleases",
+			policy:     NewCalVerPolicy("", true),
+			tags:       calverTags,
+			wantLatest: "v2023.10.9-rc.0",
+		},
+		{
+			name:       "calver constraint",
+			policy:     NewCalVerPolicy("", false),
+			tags:       calverTags,
+			requested:  "<2023.10.1",
+			wantLatest: "v2023.9.30",
+		},
+		{
+			name:       "calver tag",
+			policy:     NewCalVerPolicy("", false),
+			tags:       calverTags,
+			requested:  "v2023.08.18",
+			wantLatest: "v2023.08.18",
+		},
+		{
+			name:       "calver without leading zeros",
+			policy:     NewCalVerPolicy("", false),
+			tags:       calverTags,
+			requested:  "2023.8.18",
+			wantLatest: "v2023.08.18",
+		},
+		{
+			name:         "calver layout",
+			policy:       NewCalVerPolicy("2006-01-02", false),
+			tags:         []string{"2023-08-18", "2023-10-02-rc.1", "2023-09-30", "2023.10.03"},
+			wantLatest:   "2023-09-30",
+			wantVersions: []string{"2023-10-02-rc.1", "2023-09-30", "2023-08-18"},
+		},
+		{
+			name:         "numerical",
+			policy:       NewNumericalPolicy(),
+			tags:         numericalTags,
+			wantLatest:   "10",
+			wantVersions: []string{"10", "9", "1.5"},
+		},
+		{
+			name:      "numerical exact",
+			policy:    NewNumericalPolicy(),
+			requested: "9",
+			wantExact: true,
+		},
+		{
+			name:         "alphabetical",
+			policy:       NewAlphabeticalPolicy(),
+			tags:         alphabeticalTags,
+			wantLatest:   "RELEASE.2023-10-02",
+			wantVersions: []string{"RELEASE.2023-10-02", "RELEASE.2023-09-30", "RELEASE.2023-08-18"},
+		},
+		{
+			name:      "alphabetical exact",
+			policy:    NewAlphabeticalPolicy(),
+			requested: "RELEASE.2023-08-18",
+			wantExact: true,
+		},
+		{
+			name:         "regex numerical",
+			policy:       mustRegex(`^main-[a-z]+-(?P<ts>[0-9]+)$`, "$ts", NewNumericalPolicy()),
+			tags:         regexTags,
+			wantLatest:   "main-def-1700000000",
+			wantVersions: []string{"main-def-1700000000", "main-abc-1690000000"},
+		},
+		{
+			name:       "regex requested value",
+			policy:     mustRegex(`^main-[a-z]+-(?P<ts>[0-9]+)$`, "$ts", NewNumericalPolicy()),
+			tags:       regexTags,
+			requested:  "1690000000",
+			wantLatest: "main-abc-1690000000",
+		},
+		{
+			name:       "regex semver",
+			policy:     mustRegex(`^chart-(.*)$`, "$1", NewSemverPolicy(false)),
+			tags:       []string{"chart-0.1.0", "chart-0.2.0_build.1", "chart-0.3.0-rc.1", "0.4.0"},
+			requested:  "0.x",
+			wantLatest: "chart-0.2.0_build.1",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			g.Expect(tt.policy.Exact(tt.requested)).To(Equal(tt.wantExact))
+			if tt.wantExact {
+				return
+			}
+			latest, err := tt.policy.Latest(tt.tags, tt.requested)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(latest).To(Equal(tt.wantLatest))
+			if tt.wantVersions != nil {
+				g.Expect(tt.policy.Versions(tt.tags)).To(Equal(tt.wantVersions))
+			}
+		})
+	}
+}
+
+func TestNewVersionPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	for _, name := range []string{"", VersionPolicySemver, VersionPolicyCalVer, VersionPolicyNumerical, VersionPolicyAlphabetical} {
+		p, err := NewVersionPolicy(name, false, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(p).ToNot(BeNil())
+	}
+	_, err := NewVersionPolicy("lexical", false, "")
+	g.Expect(err).To(MatchError("unsupported version policy 'lexical'"))
+
+	_, err = NewRegexPolicy("(", "", NewNumericalPolicy())
+	g.Expect(err).To(HaveOccurred())
+}
@@ -19,6 +19,7 @@ package oci
 import (
 	"context"
 	"crypto"
+	"errors"
 	"fmt"
 
 	"github.com/google/go-containerregistry/pkg/v1/remote"
@@ -42,6 +43,7 @@ type Verifier interface {
 // options is a struct that holds options for verifier.
 type options struct {
 	PublicKey []byte
+	Name      string
 	ROpt      []remote.Option
 }
 
@@ -55,6 +57,14 @@ func WithPublicKey(publicKey []byte) Options {
 	}
 }
 
+// WithName sets the name used to identify the verifier,
+// e.g. the Secret data key the public key was read from.
+func WithName(name string) Options {
+	return func(opts *options) {
+		opts.Name = name
+	}
+}
+
 // WithRemoteOptions is a functional option for overriding the default
 // remote options used by the verifier.
 func WithRemoteOptions(opts ...remote.Option) Options {
@@ -65,6 +75,7 @@ func WithRemoteOptions(opts ...remote.Option) Options {
 
 // CosignVerifier is a struct which is responsible for executing verification logic.
 type CosignVerifier struct {
+	name string
 	opts *cosign.CheckOpts
 }
 
@@ -122,11 +133,23 @@ func NewCosignVerifier(ctx context.Context, opts ...Options) (*CosignVerifier, e
 		checkOpts.RekorClient = rc
 	}
 
+	name := o.Name
+	if name == "" && len(o.PublicKey) == 0 {
+		name = "keyless"
+	}
+
 	return &CosignVerifier{
+		name: name,
 		opts: checkOpts,
 	}, nil
 }
 
+// String returns the name of the verifier.
+// It is "keyless" for unnamed verifiers without a public key.
+func (v *CosignVerifier) String() string {
+	return v.name
+}
+
 // VerifyImageSignatures verify the authenticity of the given ref OCI image.
 func (v *CosignVerifier) VerifyImageSignatures(ctx context.Context, ref name.Reference) ([]oci.Signature, bool, error) {
 	return cosign.VerifyImageSignatures(ctx, ref, v.opts)
@@ -135,9 +158,14 @@ func (v *CosignVerifier) VerifyImageSignatures(ctx context.Context, ref name.Ref
 // Verify verifies the authenticity of the given ref OCI image.
 // It returns a boolean indicating if the verification was successful.
 // It returns an error if the verification fails, nil otherwise.
+// Signatures that do not match the verifier's key are not considered
+// an error, so that the caller can try the next verifier.
 func (v *CosignVerifier) Verify(ctx context.Context, ref name.Reference) (bool, error) {
 	signatures, _, err := v.VerifyImageSignatures(ctx, ref)
 	if err != nil {
+		if errors.Is(err, cosign.ErrNoMatchingSignatures) {
+			return false, nil
+		}
 		return false, err
 	}
 
@@ -41,6 +41,13 @@ func TestOptions(t *testing.T) {
 				PublicKey: []byte("foo"),
 				ROpt:      nil,
 			},
+		}, {
+			name: "name option",
+			opts: []Options{WithPublicKey([]byte("foo")), WithName("cosign.pub")},
+			want: &options{
+				PublicKey: []byte("foo"),
+				Name:      "cosign.pub",
+			},
 		}, {
 			name: "keychain option",
 			opts: []Options{WithRemoteOptions(remote.WithAuthFromKeychain(authn.DefaultKeychain))},
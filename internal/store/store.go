@@ -0,0 +1,301 @@
+// Package store provides a persistent on-disk store of chart artifacts.
+//
+// Artifacts are addressed by the sha256 digest of their content, and can be
+// tagged with references, e.g. the manifest digest or the immutable version
+// of a chart, which resolve to the digest of the artifact.
+// All writes are atomic, so a store can be shared by multiple processes.
+// When the size of the stored artifacts exceeds the configured maximum,
+// the least recently used artifacts are garbage collected.
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	blobsDir = "blobs"
+	refsDir  = "refs"
+)
+
+// Store is a digest-addressed on-disk store of artifacts.
+// It is safe for concurrent use.
+type Store struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// Ref is the artifact a reference resolves to.
+type Ref struct {
+	// Digest is the digest of the artifact content.
+	Digest digest.Digest `json:"digest"`
+	// Version is the version of the chart the reference resolved to.
+	Version string `json:"version,omitempty"`
+	// URL is the location the artifact was downloaded from.
+	URL string `json:"url,omitempty"`
+	// ManifestDigest is the digest of the OCI manifest of the artifact,
+	// if it was pulled from an OCI registry.
+	ManifestDigest digest.Digest `json:"manifestDigest,omitempty"`
+}
+
+// New returns a Store in the given directory, which is created if it does
+// not exist. If maxSize is greater than zero, the least recently used
+// artifacts are removed once the stored artifacts exceed maxSize bytes.
+func New(dir string, maxSize int64) (*Store, error) {
+	for _, d := range []string{filepath.Join(dir, blobsDir, string(digest.SHA256)), filepath.Join(dir, refsDir)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory: %w", err)
+		}
+	}
+	return &Store{dir: dir, maxSize: maxSize}, nil
+}
+
+// Put stores the given artifact content, and returns its digest.
+// Storing content which is already present only marks it as recently used.
+func (s *Store) Put(data []byte) (digest.Digest, error) {
+	d := digest.FromBytes(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.blobPath(d)
+	if _, err := os.Stat(p); err == nil {
+		return d, touch(p)
+	}
+	if err := writeFileAtomic(p, data); err != nil {
+		return "", err
+	}
+	if err := s.gc(p); err != nil {
+		return d, fmt.Errorf("failed to garbage collect store: %w", err)
+	}
+	return d, nil
+}
+
+// Get returns the content of the artifact with the given digest.
+// It returns an error wrapping fs.ErrNotExist if the artifact is not stored.
+// Content which does not match its digest is removed.
+func (s *Store) Get(d digest.Digest) ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	if d.Algorithm() != digest.SHA256 {
+		return nil, fmt.Errorf("unsupported digest algorithm '%s': %w", d.Algorithm(), fs.ErrNotExist)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.blobPath(d)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if digest.FromBytes(data) != d {
+		_ = os.Remove(p)
+		return nil, fmt.Errorf("stored artifact '%s' is corrupted: %w", d, fs.ErrNotExist)
+	}
+	return data, touch(p)
+}
+
+// Tag points the given reference at an artifact.
+// The artifact must have been stored with Put.
+func (s *Store) Tag(ref string, r Ref) error {
+	if err := r.Digest.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.refPath(ref), data)
+}
+
+// Untag removes the given reference, e.g. once the artifact it points at is
+// replaced. The artifact itself is kept until it is garbage collected.
+// Removing a reference which is not tagged is not an error.
+func (s *Store) Untag(ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.refPath(ref)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Resolve returns the artifact the given reference points at. It returns an
+// error wrapping fs.ErrNotExist if the reference is not tagged, or the
+// artifact has been garbage collected.
+func (s *Store) Resolve(ref string) (*Ref, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.refPath(ref))
+	if err != nil {
+		return nil, err
+	}
+	var r Ref
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid reference '%s': %w", ref, err)
+	}
+	if err := r.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid reference '%s': %w", ref, err)
+	}
+	if _, err := os.Stat(s.blobPath(r.Digest)); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GC removes the least recently used artifacts until the stored artifacts do
+// not exceed the maximum size of the store, and the references to removed
+// artifacts.
+func (s *Store) GC() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gc("")
+}
+
+// gc removes the least recently used artifacts, except the one at keep,
+// until the stored artifacts do not exceed the maximum size of the store.
+func (s *Store) gc(keep string) error {
+	if s.maxSize <= 0 {
+		return nil
+	}
+
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		blobs []blob
+		total int64
+	)
+	dir := filepath.Join(s.dir, blobsDir, string(digest.SHA256))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || filepath.Ext(e.Name()) == ".tmp" {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		blobs = append(blobs, blob{path: filepath.Join(dir, e.Name()), size: fi.Size(), modTime: fi.ModTime()})
+		total += fi.Size()
+	}
+	if total <= s.maxSize {
+		return nil
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].modTime.Before(blobs[j].modTime)
+	})
+	var removed bool
+	for _, b := range blobs {
+		if total <= s.maxSize {
+			break
+		}
+		if b.path == keep {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= b.size
+		removed = true
+	}
+	if removed {
+		return s.removeDanglingRefs()
+	}
+	return nil
+}
+
+// removeDanglingRefs removes the references to artifacts which are not stored.
+func (s *Store) removeDanglingRefs() error {
+	dir := filepath.Join(s.dir, refsDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var r Ref
+		if err := json.Unmarshal(data, &r); err == nil && r.Digest.Validate() == nil {
+			if _, err := os.Stat(s.blobPath(r.Digest)); err == nil {
+				continue
+			}
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) blobPath(d digest.Digest) string {
+	return filepath.Join(s.dir, blobsDir, string(d.Algorithm()), d.Encoded())
+}
+
+// refPath returns the path of the given reference, which is named after the
+// hash of the reference, as references may contain any character.
+func (s *Store) refPath(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return filepath.Join(s.dir, refsDir, hex.EncodeToString(sum[:]))
+}
+
+// writeFileAtomic writes data to a temporary file next to the given path,
+// and renames it to the path once it is synced to disk.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// touch marks the file at the given path as recently used.
+func touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
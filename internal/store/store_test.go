@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestStore_PutGet(t *testing.T) {
+	g := NewWithT(t)
+
+	s, err := New(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	data := []byte("chart")
+	d, err := s.Put(data)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(d).To(Equal(digest.FromBytes(data)))
+
+	got, err := s.Get(d)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(data))
+
+	_, err = s.Get(digest.FromString("unknown"))
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+
+	_, err = s.Get("invalid")
+	g.Expect(err).To(HaveOccurred())
+
+	// Corrupted content must not be served, and is removed
+	g.Expect(os.WriteFile(s.blobPath(d), []byte("corrupted"), 0o644)).To(Succeed())
+	_, err = s.Get(d)
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+	_, err = os.Stat(s.blobPath(d))
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+}
+
+func TestStore_TagResolve(t *testing.T) {
+	g := NewWithT(t)
+
+	s, err := New(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	d, err := s.Put([]byte("chart"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ref := "oci://example.com/charts/hello:1.0.0"
+	_, err = s.Resolve(ref)
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+
+	g.Expect(s.Tag(ref, Ref{Digest: d, Version: "1.0.0", URL: ref})).To(Succeed())
+	r, err := s.Resolve(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*r).To(Equal(Ref{Digest: d, Version: "1.0.0", URL: ref}))
+
+	g.Expect(s.Tag(ref, Ref{Digest: "invalid"})).ToNot(Succeed())
+
+	g.Expect(s.Untag(ref)).To(Succeed())
+	_, err = s.Resolve(ref)
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+	g.Expect(s.Untag(ref)).To(Succeed())
+	_, err = s.Get(d)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// A reference to an artifact which is not stored does not resolve
+	g.Expect(s.Tag("dangling", Ref{Digest: digest.FromString("unknown")})).To(Succeed())
+	_, err = s.Resolve("dangling")
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+}
+
+func TestStore_GC(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	s, err := New(dir, 10)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Store artifacts of 4 bytes each, with increasing modification times
+	var digests []digest.Digest
+	for i, data := range []string{"aaaa", "bbbb"} {
+		d, err := s.Put([]byte(data))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(s.Tag(data, Ref{Digest: d})).To(Succeed())
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		g.Expect(os.Chtimes(s.blobPath(d), mtime, mtime)).To(Succeed())
+		digests = append(digests, d)
+	}
+
+	// Reading the first artifact makes it the most recently used
+	_, err = s.Get(digests[0])
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Exceeding the maximum size removes the least recently used artifact and its references
+	d, err := s.Put([]byte("cccc"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = s.Get(digests[1])
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+	_, err = s.Resolve("bbbb")
+	g.Expect(err).To(MatchError(fs.ErrNotExist))
+	_, err = os.Stat(s.refPath("bbbb"))
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+	for _, d := range []digest.Digest{digests[0], d} {
+		_, err = s.Get(d)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// An artifact exceeding the maximum size on its own is kept
+	large := bytes.Repeat([]byte("d"), 20)
+	d, err = s.Put(large)
+	g.Expect(err).ToNot(HaveOccurred())
+	got, err := s.Get(d)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(large))
+	g.Expect(s.GC()).To(Succeed())
+
+	// No temporary files are left behind
+	tmp, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.tmp"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tmp).To(BeEmpty())
+}
@@ -0,0 +1,182 @@
+package chartloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"golang.org/x/sync/errgroup"
+	"helm.sh/helm/v3/pkg/chart"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+// DefaultConcurrency is the number of charts LoadBatch loads concurrently
+// if none is configured.
+const DefaultConcurrency = 8
+
+// BatchResult is the result of loading one of the chart source refs of a batch.
+type BatchResult struct {
+	// Ref is the chart source ref.
+	Ref releasesapi.ChartSourceRef
+	// Result is the loaded chart, if Err is nil.
+	Result *Result
+	// Err is the error of loading the chart, if any.
+	Err error
+}
+
+// LoadBatch loads the charts referenced by the given chart source refs
+// concurrently, with at most the number of concurrent loads configured with
+// WithConcurrency, and returns their results in the order of srcrefs.
+//
+// Loads of the same chart version of the same source which are in flight at
+// the same time within the batch are collapsed into a single load, and every
+// ref receives its own copy of its Result, as Helm modifies the charts it
+// installs or renders. The credentials of cloud providers are resolved once per registry
+// host and provider for the whole batch.
+func (l *ChartLoader) LoadBatch(ctx context.Context, srcrefs []releasesapi.ChartSourceRef) []BatchResult {
+	batch := *l
+	batch.logins = &flightGroup{keep: true}
+	// The loads are only collapsed within the batch, so that the cancellation
+	// of a batch does not fail the loads of another
+	inflight := &flightGroup{}
+
+	results := make([]BatchResult, len(srcrefs))
+	var group errgroup.Group
+	group.SetLimit(l.concurrency)
+	for i, srcref := range srcrefs {
+		i, srcref := i, srcref
+		results[i].Ref = srcref
+		group.Go(func() error {
+			v, err, shared := inflight.do(loadKey(srcref), func() (interface{}, error) {
+				return batch.Load(ctx, srcref)
+			})
+			res, _ := v.(*Result)
+			if shared && res != nil {
+				res, err = copyResult(res)
+			}
+			results[i].Result = res
+			results[i].Err = err
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return results
+}
+
+// copyResult returns a copy of the given result with a deep copy of its chart.
+func copyResult(res *Result) (*Result, error) {
+	c := *res
+	c.Archive = append([]byte(nil), res.Archive...)
+	var err error
+	if res.Chart != nil {
+		if c.Chart, err = copyChart(res.Chart); err != nil {
+			return nil, fmt.Errorf("failed to copy chart %q: %w", res.Name, err)
+		}
+	}
+	return &c, nil
+}
+
+// copyChart returns a deep copy of the given chart and of its dependencies.
+func copyChart(c *chart.Chart) (*chart.Chart, error) {
+	cp := &chart.Chart{
+		Raw:       copyFiles(c.Raw),
+		Templates: copyFiles(c.Templates),
+		Files:     copyFiles(c.Files),
+	}
+	if c.Values != nil {
+		cp.Values = copyValue(c.Values).(map[string]interface{})
+	}
+	if c.Schema != nil {
+		cp.Schema = append([]byte(nil), c.Schema...)
+	}
+	if c.Metadata != nil {
+		cp.Metadata = &chart.Metadata{}
+		if err := copyJSON(c.Metadata, cp.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	if c.Lock != nil {
+		cp.Lock = &chart.Lock{}
+		if err := copyJSON(c.Lock, cp.Lock); err != nil {
+			return nil, err
+		}
+	}
+	deps := make([]*chart.Chart, 0, len(c.Dependencies()))
+	for _, dep := range c.Dependencies() {
+		d, err := copyChart(dep)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, d)
+	}
+	cp.SetDependencies(deps...)
+	return cp, nil
+}
+
+// copyFiles returns a deep copy of the given chart files.
+func copyFiles(files []*chart.File) []*chart.File {
+	if files == nil {
+		return nil
+	}
+	cp := make([]*chart.File, 0, len(files))
+	for _, f := range files {
+		cp = append(cp, &chart.File{Name: f.Name, Data: append([]byte(nil), f.Data...)})
+	}
+	return cp
+}
+
+// copyValue returns a deep copy of the given chart values.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		cp := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			cp[k] = copyValue(e)
+		}
+		return cp
+	case []interface{}:
+		cp := make([]interface{}, len(v))
+		for i, e := range v {
+			cp[i] = copyValue(e)
+		}
+		return cp
+	default:
+		return v
+	}
+}
+
+// copyJSON copies in to out through their JSON encoding.
+func copyJSON(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// loadKey returns the key identifying the chart version loaded for srcref.
+func loadKey(srcref releasesapi.ChartSourceRef) string {
+	srcref.SetDefaults()
+	s := srcref.SourceRef
+	return fmt.Sprintf("%s/%s/%s/%s/%s@%s", s.APIGroup, s.Kind, s.Namespace, s.Name, srcref.Name, srcref.Version)
+}
+
+// providerAuth returns the authenticator of the given cloud provider for the
+// registry of the given URL. Within a batch, the provider login is shared by
+// the chart sources of the same registry host and provider.
+func (l *ChartLoader) providerAuth(ctx context.Context, registryURL, provider string) (authn.Authenticator, error) {
+	if l.logins == nil {
+		return oidcAuth(ctx, registryURL, provider)
+	}
+	host := registryURL
+	if u, err := url.Parse(registryURL); err == nil {
+		host = u.Host
+	}
+	v, err, _ := l.logins.do(provider+"/"+host, func() (interface{}, error) {
+		return oidcAuth(ctx, registryURL, provider)
+	})
+	auth, _ := v.(authn.Authenticator)
+	return auth, err
+}
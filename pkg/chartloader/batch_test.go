@@ -0,0 +1,193 @@
+package chartloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+func TestChartLoader_LoadBatch(t *testing.T) {
+	g := NewWithT(t)
+
+	var (
+		mu                sync.Mutex
+		active, maxActive int
+		downloads         int32
+	)
+	handler := newChartServer(t, "0.1.0", "0.1.1", "0.2.0").Config.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		if strings.HasSuffix(r.URL.Path, ".tgz") {
+			atomic.AddInt32(&downloads, 1)
+		}
+		// Keep the requests in flight long enough to overlap
+		time.Sleep(10 * time.Millisecond)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	l := New(&fakeClient{objects: []client.Object{helmRepository(server.URL)}}, WithConcurrency(2))
+	srcrefs := []releasesapi.ChartSourceRef{
+		chartSourceRef("0.1.0"),
+		chartSourceRef("0.1.0"),
+		chartSourceRef("0.2.0"),
+		chartSourceRef(">1.0.0"),
+		chartSourceRef("0.1.1"),
+	}
+	results := l.LoadBatch(context.TODO(), srcrefs)
+	g.Expect(results).To(HaveLen(len(srcrefs)))
+	for i, res := range results {
+		g.Expect(res.Ref).To(Equal(srcrefs[i]))
+	}
+
+	g.Expect(results[0].Err).ToNot(HaveOccurred())
+	g.Expect(results[0].Result.Version).To(Equal("0.1.0"))
+	// The loads of the same version in flight at the same time are collapsed,
+	// and every ref receives its own copy of the chart
+	g.Expect(results[1].Err).ToNot(HaveOccurred())
+	g.Expect(results[1].Result).To(Equal(results[0].Result))
+	g.Expect(results[1].Result.Chart).ToNot(BeIdenticalTo(results[0].Result.Chart))
+	results[1].Result.Chart.Metadata.Description = "changed"
+	g.Expect(results[0].Result.Chart.Metadata.Description).To(BeEmpty())
+	g.Expect(results[2].Err).ToNot(HaveOccurred())
+	g.Expect(results[2].Result.Version).To(Equal("0.2.0"))
+	g.Expect(results[3].Err).To(MatchError(ErrNoMatchingVersion))
+	g.Expect(results[3].Result).To(BeNil())
+	g.Expect(results[4].Err).ToNot(HaveOccurred())
+	g.Expect(results[4].Result.Version).To(Equal("0.1.1"))
+
+	g.Expect(maxActive).To(BeNumerically("<=", 2))
+	g.Expect(atomic.LoadInt32(&downloads)).To(Equal(int32(3)))
+}
+
+func Test_flightGroup(t *testing.T) {
+	t.Run("collapses concurrent calls", func(t *testing.T) {
+		g := NewWithT(t)
+
+		var (
+			group flightGroup
+			calls int32
+		)
+		release := make(chan struct{})
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return "value", nil
+		}
+
+		var wg sync.WaitGroup
+		results := make([]interface{}, 3)
+		shared := make([]bool, 3)
+		for i := range results {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _, shared[i] = group.do("key", fn)
+			}()
+		}
+		// Wait for all callers to join the call
+		g.Eventually(func() int {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			if c, ok := group.calls["key"]; ok {
+				return c.dups
+			}
+			return 0
+		}).Should(Equal(2))
+		close(release)
+		wg.Wait()
+
+		g.Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		g.Expect(results).To(Equal([]interface{}{"value", "value", "value"}))
+		g.Expect(shared).To(Equal([]bool{true, true, true}))
+
+		// Completed calls are forgotten
+		_, _, isShared := group.do("key", fn)
+		g.Expect(isShared).To(BeFalse())
+		g.Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	t.Run("forgets panicked calls", func(t *testing.T) {
+		g := NewWithT(t)
+
+		group := flightGroup{keep: true}
+		release := make(chan struct{})
+		waiter := make(chan error)
+		go func() {
+			defer func() {
+				_ = recover()
+			}()
+			group.do("key", func() (interface{}, error) {
+				<-release
+				panic("boom")
+			})
+		}()
+		g.Eventually(func() bool {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			_, ok := group.calls["key"]
+			return ok
+		}).Should(BeTrue())
+		go func() {
+			_, err, _ := group.do("key", func() (interface{}, error) {
+				return "value", nil
+			})
+			waiter <- err
+		}()
+		g.Eventually(func() int {
+			group.mu.Lock()
+			defer group.mu.Unlock()
+			if c, ok := group.calls["key"]; ok {
+				return c.dups
+			}
+			return 0
+		}).Should(Equal(1))
+		close(release)
+
+		// The waiting caller fails, and later calls call fn again
+		g.Expect(<-waiter).To(MatchError(ContainSubstring("panicked: boom")))
+		v, err, _ := group.do("key", func() (interface{}, error) {
+			return "value", nil
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(v).To(Equal("value"))
+	})
+
+	t.Run("keeps completed calls", func(t *testing.T) {
+		g := NewWithT(t)
+
+		group := flightGroup{keep: true}
+		calls := 0
+		fn := func() (interface{}, error) {
+			calls++
+			return calls, nil
+		}
+		v, _, _ := group.do("a", fn)
+		g.Expect(v).To(Equal(1))
+		v, _, shared := group.do("a", fn)
+		g.Expect(v).To(Equal(1))
+		g.Expect(shared).To(BeTrue())
+		v, _, _ = group.do("b", fn)
+		g.Expect(v).To(Equal(2))
+	})
+}
@@ -0,0 +1,351 @@
+package chartloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+	"github.com/tamalsaha/learn-helm-oci/internal/store"
+)
+
+// defaultTimeout is the timeout of remote operations for a
+// chart source that does not specify one.
+const defaultTimeout = 60 * time.Second
+
+// ChartLoader loads Helm charts referenced by a releasesapi.ChartSourceRef.
+// It is safe for concurrent use.
+type ChartLoader struct {
+	sources               SourceProvider
+	getters               helmgetter.Providers
+	registryClientFactory RegistryClientFactory
+	retryPolicy           RetryPolicy
+	cache                 *Cache
+	cacheTTL              time.Duration
+	tagCache              *Cache
+	tagCacheTTL           time.Duration
+	cacheRecorder         *CacheRecorder
+	versionPolicy         VersionPolicy
+	verifiers             []Verifier
+	embedFS               fs.FS
+	store                 *Store
+	logger                logr.Logger
+	concurrency           int
+	// logins shares the cloud provider logins of the chart sources of a batch.
+	// It is only set on the copy of the ChartLoader used by LoadBatch.
+	logins *flightGroup
+	// sessions holds the registry sessions of the chart sources, if enabled.
+	sessions *sessionCache
+	metrics  *MetricsRecorder
+	tracer   trace.Tracer
+}
+
+// SourceProvider provides the chart source objects, i.e. HelmRepositories and
+// OCIRepositories, and the Secrets and ServiceAccounts they reference. It returns
+// a NotFound error for objects which do not exist.
+// A client.Client provides the objects of a cluster, and the sourceprovider
+// package provides the objects of YAML manifests for use without a cluster.
+type SourceProvider interface {
+	Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error
+}
+
+// Result is the result of loading a chart. Modeled on the Artifact of Flux
+// sources, it records which chart was resolved, so it can be written to lock
+// files and audit logs.
+type Result struct {
+	// Chart is the loaded chart.
+	Chart *chart.Chart `json:"-"`
+	// Name is the name of the chart.
+	Name string `json:"name"`
+	// Version is the chart version the requested version resolved to.
+	Version string `json:"version"`
+	// URL is the location the chart was downloaded from.
+	URL string `json:"url"`
+	// Reference is the OCI reference of the chart artifact, pinned to its
+	// manifest digest if it is known. It is empty if the chart was not
+	// pulled from an OCI registry.
+	Reference string `json:"reference,omitempty"`
+	// ManifestDigest is the digest of the OCI manifest of the chart artifact,
+	// if it is known.
+	ManifestDigest string `json:"manifestDigest,omitempty"`
+	// Digest is the digest of the chart archive, or empty if the
+	// chart was loaded from a directory.
+	Digest string `json:"digest,omitempty"`
+	// Size is the size of the chart archive in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Archive is the chart archive as downloaded, whose digest is Digest, or
+	// nil if the chart was loaded from a directory. Unlike the chart, it does
+	// not contain the dependencies built after loading it.
+	Archive []byte `json:"-"`
+	// Verified is true if the signature of the chart was verified.
+	Verified bool `json:"verified"`
+	// Verifier is the name of the verifier which verified the signature of the chart.
+	Verifier string `json:"verifier,omitempty"`
+	// FetchedAt is the time the chart was fetched from its chart source or the store.
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// archiveResult loads the given chart archive, and returns its Result for the
+// given resolved reference. If the reference has no version, the chart version is used.
+func archiveResult(data []byte, ref *store.Ref) (*Result, error) {
+	chrt, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		Chart:          chrt,
+		Name:           chrt.Name(),
+		Version:        ref.Version,
+		URL:            ref.URL,
+		Reference:      ociReference(ref.URL, ref.ManifestDigest),
+		ManifestDigest: ref.ManifestDigest.String(),
+		Digest:         digest.FromBytes(data).String(),
+		Size:           int64(len(data)),
+		Archive:        data,
+		FetchedAt:      time.Now(),
+	}
+	if result.Version == "" {
+		result.Version = chrt.Metadata.Version
+	}
+	return result, nil
+}
+
+// ociReference returns the given OCI chart URL pinned to the given manifest
+// digest, or an empty string if the URL is not an OCI reference.
+func ociReference(chartURL string, manifestDigest digest.Digest) string {
+	switch {
+	case !helmreg.IsOCI(chartURL):
+		return ""
+	case manifestDigest == "" || strings.Contains(chartURL, "@"):
+		return chartURL
+	default:
+		return fmt.Sprintf("%s@%s", chartURL, manifestDigest)
+	}
+}
+
+// New returns a ChartLoader which reads the chart sources and their
+// Secrets from the given SourceProvider, usually a Kubernetes client.
+// It may be nil if only charts.x-helm.dev source kinds are loaded.
+func New(sources SourceProvider, opts ...Option) *ChartLoader {
+	l := &ChartLoader{
+		sources:               sources,
+		getters:               DefaultGetters,
+		registryClientFactory: DefaultRegistryClientFactory,
+		retryPolicy:           repository.DefaultRetryPolicy,
+		logger:                klog.NewKlogr(),
+		concurrency:           DefaultConcurrency,
+		tracer:                otel.Tracer(registry.TracerName),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Load resolves the chart version referenced by srcref, downloads the chart
+// and loads it. The given context bounds all remote operations.
+//
+// Besides the HelmRepository and OCIRepository sources, the following
+// charts.x-helm.dev source kinds are supported, which need no Kubernetes objects:
+//   - Legacy: the source ref name is the URL of an HTTP chart repository.
+//   - Local: the source ref name is a directory holding the chart directory or archive.
+//   - Embed: the source ref name is a directory of the file system set by WithEmbeddedCharts.
+//
+// The load is traced with the TracerProvider set by WithTracerProvider, as a
+// child of the span of the given context, if any.
+func (l *ChartLoader) Load(ctx context.Context, srcref releasesapi.ChartSourceRef) (result *Result, err error) {
+	srcref.SetDefaults()
+
+	ctx, span := l.tracer.Start(ctx, "ChartLoader.Load", trace.WithAttributes(
+		attributeSourceKind.String(srcref.SourceRef.Kind),
+		attributeSourceNamespace.String(srcref.SourceRef.Namespace),
+		attributeSourceName.String(srcref.SourceRef.Name),
+		registry.AttributeChart.String(srcref.Name),
+		registry.AttributeRequestedVersion.String(srcref.Version),
+	))
+	defer func() {
+		if result != nil {
+			span.SetAttributes(resultAttributes(result)...)
+		}
+		registry.EndSpan(span, err)
+	}()
+
+	return l.load(ctx, srcref)
+}
+
+// load loads the chart referenced by the given defaulted srcref.
+func (l *ChartLoader) load(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	switch srcref.SourceRef.Kind {
+	case releasesapi.SourceKindHelmRepository:
+		return l.loadFromHelmRepository(ctx, srcref)
+	case sourcev1.OCIRepositoryKind:
+		return l.loadFromOCIRepository(ctx, srcref)
+	case releasesapi.SourceKindLegacy:
+		return l.loadFromLegacy(ctx, srcref)
+	case releasesapi.SourceKindLocal:
+		return l.loadFromLocal(ctx, srcref)
+	case releasesapi.SourceKindEmbed:
+		return l.loadFromEmbed(ctx, srcref)
+	default:
+		return nil, fmt.Errorf("unsupported chart source kind %q", srcref.SourceRef.Kind)
+	}
+}
+
+// getSource reads the chart source object referenced by srcref from the SourceProvider.
+func (l *ChartLoader) getSource(ctx context.Context, srcref releasesapi.ChartSourceRef, obj client.Object) error {
+	if l.sources == nil {
+		return fmt.Errorf("no source provider configured for source kind %q", srcref.SourceRef.Kind)
+	}
+	return l.sources.Get(ctx, client.ObjectKey{Namespace: srcref.SourceRef.Namespace, Name: srcref.SourceRef.Name}, obj)
+}
+
+// newRegistryClient returns a registry client created by the registry client
+// factory, which records its metrics with the metrics recorder, if any.
+func (l *ChartLoader) newRegistryClient(tlsConfig *tls.Config, insecureHTTP bool) (*RegistryClient, error) {
+	var opts []RegistryClientOption
+	if l.metrics != nil {
+		opts = append(opts, registry.ClientOptMetricsRecorder(l.metrics))
+	}
+	return l.registryClientFactory(tlsConfig, insecureHTTP, opts...)
+}
+
+// fetchChart resolves the chart version referenced by srcref in the given chart
+// repository, verifies it with verifierRepo if not nil, and downloads and loads it.
+// Charts of immutable versions are served from the store of the ChartLoader, in
+// which they are scoped by the given scope. The dependencies of the returned
+// chart are not built.
+func (l *ChartLoader) fetchChart(ctx context.Context, chartRepo repository.Downloader, verifierRepo *repository.OCIChartRepository, scope string, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	storeRef := artifactRef(scope, srcref.Name, srcref.Version)
+
+	// Charts which must be verified are only served from the store once verified
+	if verifierRepo == nil {
+		if ref, res := l.storedChart(storeRef); res != nil {
+			if result, err := archiveResult(res.Bytes(), ref); err == nil {
+				return result, nil
+			}
+		}
+	}
+
+	// Get the current version for the RemoteReference
+	cv, err := chartRepo.GetChartVersion(srcref.Name, srcref.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chart version for remote reference: %w", err)
+	}
+	ref := &store.Ref{Version: cv.Version}
+	if len(cv.URLs) > 0 {
+		ref.URL = cv.URLs[0]
+	}
+	// OCI charts are pulled by the manifest digest their version resolved to
+	if _, d, pinned := registry.SplitDigest(ref.URL); helmreg.IsOCI(ref.URL) && pinned && digest.Digest(d).Validate() == nil {
+		ref.ManifestDigest = digest.Digest(d)
+	}
+
+	// Verify the chart if necessary
+	var (
+		res      *bytes.Buffer
+		verifier Verifier
+	)
+	if verifierRepo != nil {
+		verifier, err = verifierRepo.MatchingVerifier(ctx, cv)
+		if err != nil {
+			return nil, fmt.Errorf("chart verification failed for '%s:%s': %w", srcref.Name, cv.Version, err)
+		}
+		l.logger.Info("verified chart", "chart", srcref.Name, "version", cv.Version, "verifier", fmt.Sprint(verifier))
+
+		// The stored chart is only the verified one if it was stored for the
+		// verified manifest, as the tag may have moved since, or the chart may
+		// have been stored by a load which did not verify it
+		if stored, data := l.storedChart(storeRef); data != nil && ref.ManifestDigest != "" && stored.ManifestDigest == ref.ManifestDigest {
+			res = data
+		}
+	}
+	// The index of HTTP chart repositories declares the digest of the chart archives
+	if res == nil && cv.Digest != "" {
+		res = l.storedBlob(digest.NewDigestFromEncoded(digest.SHA256, strings.TrimPrefix(cv.Digest, "sha256:")))
+	}
+
+	if res == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Download the package for the resolved version
+		res, err = chartRepo.DownloadChart(cv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download chart for remote reference: %w", err)
+		}
+	}
+
+	data := res.Bytes()
+	result, err := archiveResult(data, ref)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		result.Verified = true
+		result.Verifier = fmt.Sprint(verifier)
+	}
+	l.storeChart(storeRef, data, *ref)
+	return result, nil
+}
+
+// forceRefreshKey is the context key of ForceRefresh.
+type forceRefreshKey struct{}
+
+// ForceRefresh returns a copy of ctx with which the loads revalidate the tag
+// listings of the tag cache with the registries, even if they are fresh, e.g.
+// to resolve a version constraint to a chart version which was just pushed.
+func ForceRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceRefreshKey{}, true)
+}
+
+// isForceRefresh returns true if the loads with ctx must revalidate the fresh tag listings.
+func isForceRefresh(ctx context.Context) bool {
+	force, _ := ctx.Value(forceRefreshKey{}).(bool)
+	return force
+}
+
+// cacheMetric returns the function recording the cache events of the given
+// chart source with the cache recorder, or nil if there is none.
+func (l *ChartLoader) cacheMetric(obj client.Object) repository.RecordMetricsFunc {
+	if l.cacheRecorder == nil {
+		return nil
+	}
+	return func(event string) {
+		l.cacheRecorder.IncCacheEvents(event, obj.GetName(), obj.GetNamespace())
+	}
+}
+
+// remoteTimeout returns the timeout of remote operations for a chart source
+// with the given timeout, or defaultTimeout if it does not specify one.
+func remoteTimeout(ctx context.Context, sourceTimeout *metav1.Duration) time.Duration {
+	timeout := defaultTimeout
+	if sourceTimeout != nil {
+		timeout = sourceTimeout.Duration
+	}
+	// The remote operations of Helm are not context aware,
+	// so make sure they don't outlive the caller's deadline.
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	return timeout
+}
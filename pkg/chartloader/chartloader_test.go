@@ -0,0 +1,324 @@
+package chartloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/pkg/sourceprovider"
+)
+
+// fakeClient is a client.Client which serves Get requests from a fixed set of objects.
+type fakeClient struct {
+	client.Client
+	objects []client.Object
+}
+
+func (c *fakeClient) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	for _, o := range c.objects {
+		if client.ObjectKeyFromObject(o) == key && reflect.TypeOf(o) == reflect.TypeOf(obj) {
+			reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(o.DeepCopyObject()).Elem())
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+// newChartServer starts an HTTP chart repository serving the given versions of a chart named hello.
+func newChartServer(t *testing.T, versions ...string) *httptest.Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	index := "apiVersion: v1\nentries:\n  hello:\n"
+	for _, v := range versions {
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{
+				APIVersion: chart.APIVersionV2,
+				Name:       "hello",
+				Version:    v,
+			},
+		}
+		if _, err := chartutil.Save(c, dir); err != nil {
+			t.Fatal(err)
+		}
+		index += fmt.Sprintf("    - name: hello\n      version: %s\n      urls:\n        - hello-%s.tgz\n", v, v)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.yaml"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func helmRepository(url string) *sourcev1.HelmRepository {
+	return &sourcev1.HelmRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "charts",
+			Namespace: "default",
+		},
+		Spec: sourcev1.HelmRepositorySpec{
+			URL: url,
+		},
+	}
+}
+
+func chartSourceRef(version string) releasesapi.ChartSourceRef {
+	return releasesapi.ChartSourceRef{
+		Name:    "hello",
+		Version: version,
+		SourceRef: kmapi.TypedObjectReference{
+			APIGroup:  sourcev1.GroupVersion.Group,
+			Kind:      sourcev1.HelmRepositoryKind,
+			Namespace: "default",
+			Name:      "charts",
+		},
+	}
+}
+
+func TestChartLoader_Load(t *testing.T) {
+	server := newChartServer(t, "0.1.0", "0.1.1", "0.2.0")
+
+	tests := []struct {
+		name        string
+		version     string
+		wantVersion string
+		wantErr     bool
+	}{
+		{name: "exact version", version: "0.1.0", wantVersion: "0.1.0"},
+		{name: "version constraint", version: "~0.1", wantVersion: "0.1.1"},
+		{name: "latest version", version: "", wantVersion: "0.2.0"},
+		{name: "unknown version", version: "1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := New(&fakeClient{objects: []client.Object{helmRepository(server.URL)}})
+			result, err := l.Load(context.TODO(), chartSourceRef(tt.version))
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.wantVersion))
+			g.Expect(result.URL).To(Equal(fmt.Sprintf("hello-%s.tgz", tt.wantVersion)))
+			g.Expect(result.Chart.Metadata.Name).To(Equal("hello"))
+			g.Expect(result.Chart.Metadata.Version).To(Equal(tt.wantVersion))
+			g.Expect(result.Digest).To(HavePrefix("sha256:"))
+			g.Expect(result.Name).To(Equal("hello"))
+			g.Expect(result.Size).To(BeNumerically(">", 0))
+			g.Expect(result.Reference).To(BeEmpty())
+			g.Expect(result.Verified).To(BeFalse())
+			g.Expect(result.FetchedAt).ToNot(BeZero())
+		})
+	}
+}
+
+func TestChartLoader_LoadErrors(t *testing.T) {
+	server := newChartServer(t, "0.1.0")
+
+	t.Run("missing HelmRepository", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(&fakeClient{})
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	t.Run("no source provider", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(nil)
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(err).To(MatchError(ContainSubstring("no source provider configured")))
+	})
+
+	t.Run("unsupported source kind", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(&fakeClient{})
+		ref := chartSourceRef("0.1.0")
+		ref.SourceRef.Kind = "GitRepository"
+		_, err := l.Load(context.TODO(), ref)
+		g.Expect(err).To(MatchError(ContainSubstring("unsupported chart source kind")))
+	})
+
+	t.Run("verification of HTTP repository", func(t *testing.T) {
+		g := NewWithT(t)
+		repo := helmRepository(server.URL)
+		repo.Annotations = map[string]string{AnnotationVerifyProvider: "cosign"}
+		l := New(&fakeClient{objects: []client.Object{repo}})
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(err).To(MatchError(ContainSubstring("signature verification is only supported")))
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		g := NewWithT(t)
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		l := New(&fakeClient{objects: []client.Object{helmRepository(server.URL)}})
+		_, err := l.Load(ctx, chartSourceRef("0.1.0"))
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestChartLoader_LoadErrorReasons(t *testing.T) {
+	server := newChartServer(t, "0.1.0")
+	registry := newRegistryServer(t, "0.1.0")
+	ociURL := fmt.Sprintf("oci://%s/charts/hello", strings.TrimPrefix(registry.URL, "http://"))
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	unavailable.Close()
+
+	unknownChart := chartSourceRef("0.1.0")
+	unknownChart.Name = "other"
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		srcref  releasesapi.ChartSourceRef
+		wantErr error
+	}{
+		{
+			name:    "unknown chart",
+			objects: []client.Object{helmRepository(server.URL)},
+			srcref:  unknownChart,
+			wantErr: ErrChartNotFound,
+		},
+		{
+			name:    "no matching version",
+			objects: []client.Object{helmRepository(server.URL)},
+			srcref:  chartSourceRef(">1.0.0"),
+			wantErr: ErrNoMatchingVersion,
+		},
+		{
+			name:    "unknown OCI tag",
+			objects: []client.Object{ociRepository(ociURL, &sourcev1.OCIRepositoryRef{Tag: "1.0.0"})},
+			srcref:  ociChartSourceRef(""),
+			wantErr: ErrChartNotFound,
+		},
+		{
+			name:    "no matching OCI version",
+			objects: []client.Object{ociRepository(ociURL, nil)},
+			srcref:  ociChartSourceRef(">1.0.0"),
+			wantErr: ErrNoMatchingVersion,
+		},
+		{
+			name:    "invalid OCI URL",
+			objects: []client.Object{ociRepository(registry.URL, nil)},
+			srcref:  ociChartSourceRef("0.1.0"),
+			wantErr: ErrInvalidURL,
+		},
+		{
+			name:    "unavailable registry",
+			objects: []client.Object{ociRepository(fmt.Sprintf("oci://%s/charts/hello", strings.TrimPrefix(unavailable.URL, "http://")), nil)},
+			srcref:  ociChartSourceRef(">0.1.0"),
+			wantErr: ErrRegistryUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			l := New(&fakeClient{objects: tt.objects})
+			_, err := l.Load(context.TODO(), tt.srcref)
+			g.Expect(err).To(MatchError(tt.wantErr))
+
+			var e *Error
+			g.Expect(errors.As(err, &e)).To(BeTrue())
+			g.Expect(e.Ref).ToNot(BeEmpty())
+		})
+	}
+}
+
+func TestChartLoader_LoadFromStore(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newChartServer(t, "0.1.0", "0.2.0")
+	s, err := NewStore(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	l := New(&fakeClient{objects: []client.Object{helmRepository(server.URL)}}, WithStore(s))
+
+	result, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.0"))
+
+	// Exact versions are served from the store once downloaded,
+	// but version constraints still need the repository.
+	server.Close()
+	result, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.0"))
+	g.Expect(result.URL).To(Equal("hello-0.1.0.tgz"))
+	g.Expect(result.Chart.Metadata.Version).To(Equal("0.1.0"))
+
+	_, err = l.Load(context.TODO(), chartSourceRef("~0.1"))
+	g.Expect(err).To(HaveOccurred())
+
+	// The store is scoped by the HelmRepository
+	repo := helmRepository(server.URL)
+	repo.Name = "other"
+	l = New(&fakeClient{objects: []client.Object{repo}}, WithStore(s))
+	ref := chartSourceRef("0.1.0")
+	ref.SourceRef.Name = "other"
+	_, err = l.Load(context.TODO(), ref)
+	g.Expect(err).To(HaveOccurred())
+
+	// and by the credentials of the HelmRepository, including the data of its Secret
+	server = newChartServer(t, "0.1.0")
+	repo = helmRepository(server.URL)
+	repo.Spec.SecretRef = &meta.LocalObjectReference{Name: "credentials"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("old")},
+	}
+	l = New(&fakeClient{objects: []client.Object{repo, secret}}, WithStore(s))
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	server.Close()
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	secret.Data["password"] = []byte("rotated")
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestChartLoader_LoadFromManifests(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newChartServer(t, "0.1.0", "0.2.0")
+	dir := t.TempDir()
+	manifest := fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: charts
+spec:
+  url: %s
+`, server.URL)
+	g.Expect(os.WriteFile(filepath.Join(dir, "repo.yaml"), []byte(manifest), 0o644)).To(Succeed())
+
+	sources, err := sourceprovider.NewManifests(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+	result, err := New(sources).Load(context.TODO(), chartSourceRef("~0.1"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.0"))
+}
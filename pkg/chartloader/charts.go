@@ -0,0 +1,106 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"helm.sh/helm/v3/pkg/repo"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// AnnotationCharts is the annotation on a HelmRepository of type 'oci' that
+// lists the comma separated names of its charts, e.g. "kubedb,stash", which
+// are listed if its registry does not support the '_catalog' endpoint.
+const AnnotationCharts = "charts.x-helm.dev/charts"
+
+// ChartInfo describes a chart of an OCI chart repository: its name, its
+// versions, newest first, and the metadata of its latest version.
+type ChartInfo = repository.ChartInfo
+
+// ListCharts returns the charts whose name starts with prefix of the
+// HelmRepository of type 'oci' referenced by srcref, sorted by name. The name
+// and the version of srcref are ignored. The charts are listed with the
+// '_catalog' endpoint of the registry, or are those of AnnotationCharts if the
+// registry does not support it.
+func (l *ChartLoader) ListCharts(ctx context.Context, srcref releasesapi.ChartSourceRef, prefix string) (charts []*ChartInfo, err error) {
+	err = l.withOCIChartRepository(ctx, srcref, "listing charts", func(r *repository.OCIChartRepository, _ string) error {
+		charts, err = r.ListCharts(prefix)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list charts: %w", err)
+	}
+	return charts, nil
+}
+
+// Search returns the charts of the HelmRepository of type 'oci' referenced by
+// srcref whose name, description or keywords contain query, ignoring case,
+// like ListCharts does.
+func (l *ChartLoader) Search(ctx context.Context, srcref releasesapi.ChartSourceRef, query string) (charts []*ChartInfo, err error) {
+	err = l.withOCIChartRepository(ctx, srcref, "listing charts", func(r *repository.OCIChartRepository, _ string) error {
+		charts, err = r.Search(query)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list charts: %w", err)
+	}
+	return charts, nil
+}
+
+// Index returns the index of all versions of the charts listed by ListCharts,
+// as served by a classic chart repository, e.g. to mirror the HelmRepository
+// of type 'oci' referenced by srcref as a classic chart repository. The URLs
+// of the chart versions are their 'oci://' references, or are relative to
+// downloadURL, as '<downloadURL>/<name>-<version>.tgz', if it is not empty.
+func (l *ChartLoader) Index(ctx context.Context, srcref releasesapi.ChartSourceRef, downloadURL string) (index *repo.IndexFile, err error) {
+	var opts []repository.IndexOption
+	if downloadURL != "" {
+		opts = append(opts, repository.WithDownloadURL(downloadURL))
+	}
+	err = l.withOCIChartRepository(ctx, srcref, "listing charts", func(r *repository.OCIChartRepository, _ string) error {
+		index, err = r.Index(opts...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index charts: %w", err)
+	}
+	return index, nil
+}
+
+// withOCIChartRepository calls fn with the chart repository of the
+// HelmRepository of type 'oci' referenced by srcref, and the scope of its
+// charts in the store, to perform the given operation, e.g. "listing charts".
+func (l *ChartLoader) withOCIChartRepository(ctx context.Context, srcref releasesapi.ChartSourceRef, operation string, fn func(r *repository.OCIChartRepository, scope string) error) error {
+	srcref.SetDefaults()
+	if srcref.SourceRef.Kind != releasesapi.SourceKindHelmRepository {
+		return fmt.Errorf("%s is not supported for chart source kind %q", operation, srcref.SourceRef.Kind)
+	}
+	src, err := l.openHelmRepository(ctx, srcref)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r, ok := src.chartRepo.(*repository.OCIChartRepository)
+	if !ok {
+		return fmt.Errorf("%s is only supported for HelmRepositories of type '%s'", operation, sourcev1.HelmRepositoryTypeOCI)
+	}
+	err = fn(r, src.scope)
+	l.sessions.invalidateOnUnauthorized(src.sessionKey, err)
+	return err
+}
+
+// chartNamesFor returns the chart names declared by the annotations of the given HelmRepository.
+func chartNamesFor(repo *sourcev1.HelmRepository) []string {
+	var names []string
+	for _, name := range strings.Split(repo.GetAnnotations()[AnnotationCharts], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,159 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+func TestChartLoader_ListCharts(t *testing.T) {
+	server := newRegistryServer(t, "0.1.0", "0.2.0")
+	handler := registryHandler(t, "0.1.0")
+	noCatalogServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/_catalog" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(noCatalogServer.Close)
+
+	ociRepo := func(server *httptest.Server, annotations map[string]string) *sourcev1.HelmRepository {
+		repo := helmRepository(fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://")))
+		repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+		repo.Annotations = annotations
+		return repo
+	}
+
+	tests := []struct {
+		name         string
+		repo         *sourcev1.HelmRepository
+		search       bool
+		query        string
+		want         []string
+		wantVersions []string
+		wantErr      string
+	}{
+		{
+			name:         "catalog",
+			repo:         ociRepo(server, nil),
+			want:         []string{"hello"},
+			wantVersions: []string{"0.2.0", "0.1.0"},
+		},
+		{
+			name:  "prefix",
+			repo:  ociRepo(server, nil),
+			query: "world",
+			want:  []string{},
+		},
+		{
+			name:   "search by description",
+			repo:   ociRepo(server, nil),
+			search: true,
+			query:  "SAYING",
+			want:   []string{"hello"},
+		},
+		{
+			name:   "search without match",
+			repo:   ociRepo(server, nil),
+			search: true,
+			query:  "goodbye",
+			want:   []string{},
+		},
+		{
+			name:         "chart names annotation",
+			repo:         ociRepo(noCatalogServer, map[string]string{AnnotationCharts: "missing, hello"}),
+			want:         []string{"hello"},
+			wantVersions: []string{"0.1.0"},
+		},
+		{
+			name:    "catalog not supported",
+			repo:    ociRepo(noCatalogServer, nil),
+			wantErr: "chart not found",
+		},
+		{
+			name:    "HTTP chart repository",
+			repo:    helmRepository(newChartServer(t, "0.1.0").URL),
+			wantErr: "only supported for HelmRepositories of type 'oci'",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := New(&fakeClient{objects: []client.Object{tt.repo}})
+			var (
+				charts []*ChartInfo
+				err    error
+			)
+			if tt.search {
+				charts, err = l.Search(context.TODO(), chartSourceRef(""), tt.query)
+			} else {
+				charts, err = l.ListCharts(context.TODO(), chartSourceRef(""), tt.query)
+			}
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			names := make([]string, 0, len(charts))
+			for _, c := range charts {
+				names = append(names, c.Name)
+				g.Expect(c.Metadata.Description).To(Equal("A chart saying hello"))
+			}
+			g.Expect(names).To(Equal(tt.want))
+			if tt.wantVersions != nil {
+				g.Expect(charts[0].Versions).To(Equal(tt.wantVersions))
+			}
+		})
+	}
+}
+
+func TestChartLoader_Index(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newRegistryServer(t, "0.1.0", "0.2.0")
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://"))
+	source := helmRepository(repoURL)
+	source.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	l := New(&fakeClient{objects: []client.Object{source}})
+
+	index, err := l.Index(context.TODO(), chartSourceRef(""), "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The index is a valid index of a classic chart repository
+	data, err := yaml.Marshal(index)
+	g.Expect(err).ToNot(HaveOccurred())
+	indexFile := filepath.Join(t.TempDir(), "index.yaml")
+	g.Expect(os.WriteFile(indexFile, data, 0o600)).To(Succeed())
+	loaded, err := repo.LoadIndexFile(indexFile)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loaded.Entries).To(HaveKey("hello"))
+	g.Expect(loaded.Entries["hello"]).To(HaveLen(2))
+
+	cv, err := loaded.Get("hello", "0.2.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cv.Description).To(Equal("A chart saying hello"))
+	g.Expect(cv.URLs).To(Equal([]string{repoURL + "/hello:0.2.0"}))
+	result, err := l.Load(context.TODO(), chartSourceRef("0.2.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect("sha256:" + cv.Digest).To(Equal(result.Digest))
+
+	index, err = l.Index(context.TODO(), chartSourceRef(""), "https://charts.example.com/")
+	g.Expect(err).ToNot(HaveOccurred())
+	cv, err = index.Get("hello", "0.1.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cv.URLs).To(Equal([]string{"https://charts.example.com/hello-0.1.0.tgz"}))
+}
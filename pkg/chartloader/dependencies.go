@@ -0,0 +1,117 @@
+package chartloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"helm.sh/helm/v3/pkg/chart"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+
+	helmchart "github.com/tamalsaha/learn-helm-oci/internal/helm/chart"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// sourceCredentials holds the credentials of a chart source. They are reused to
+// download the dependencies of its charts hosted on the same host.
+type sourceCredentials struct {
+	// host is the host of the chart source URL.
+	host string
+	// getterOpts are the getter options holding the credentials of the chart source.
+	getterOpts []helmgetter.Option
+	// tlsConfig is the TLS client config of the chart source.
+	tlsConfig *tls.Config
+	// insecureHTTP allows connecting to the OCI registry of the chart source over plain HTTP.
+	insecureHTTP bool
+	// auth is the authenticator of the OCI registry of the chart source, if any.
+	auth authn.Authenticator
+}
+
+// matches returns true if the given repository URL is hosted on the host of the chart source.
+func (c *sourceCredentials) matches(repositoryURL string) bool {
+	if c == nil {
+		return false
+	}
+	u, err := url.Parse(repositoryURL)
+	return err == nil && u.Host == c.host
+}
+
+// buildDependencies downloads the dependencies of the chart which are not
+// vendored in its 'charts/' directory, and adds them to the chart. The
+// credentials of the chart source are used for dependencies on the same host.
+func (l *ChartLoader) buildDependencies(ctx context.Context, chrt *chart.Chart, creds *sourceCredentials, timeout time.Duration, opts ...helmchart.DependencyManagerOption) (err error) {
+	if len(chrt.Metadata.Dependencies) == 0 {
+		return nil
+	}
+	ctx, span := registry.StartSpan(ctx, "BuildDependencies", registry.AttributeChart.String(chrt.Name()))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	opts = append(opts, helmchart.WithDownloaderCallback(l.dependencyDownloader(ctx, creds, timeout)))
+	dm := helmchart.NewDependencyManager(opts...)
+	defer func() {
+		if err := dm.Clear(); err != nil {
+			l.logger.Error(err, "failed to clear dependency repositories", "chart", chrt.Name())
+		}
+	}()
+
+	n, err := dm.Build(ctx, chrt)
+	if err != nil {
+		return fmt.Errorf("failed to build dependencies of chart '%s': %w", chrt.Name(), err)
+	}
+	if n > 0 {
+		l.logger.V(1).Info("built chart dependencies", "chart", chrt.Name(), "version", chrt.Metadata.Version, "dependencies", n)
+	}
+	return nil
+}
+
+// dependencyDownloader returns the callback used by the dependency manager to
+// get a repository.Downloader for a dependency repository URL.
+func (l *ChartLoader) dependencyDownloader(ctx context.Context, creds *sourceCredentials, timeout time.Duration) helmchart.GetChartDownloaderCallback {
+	return func(repositoryURL string) (repository.Downloader, error) {
+		sameHost := creds.matches(repositoryURL)
+		clientOpts := []helmgetter.Option{
+			helmgetter.WithURL(repositoryURL),
+			helmgetter.WithTimeout(timeout),
+		}
+		var (
+			tlsConfig    *tls.Config
+			insecureHTTP bool
+		)
+		if sameHost {
+			clientOpts = append(clientOpts, creds.getterOpts...)
+			tlsConfig = creds.tlsConfig
+			insecureHTTP = creds.insecureHTTP
+		}
+
+		if !helmreg.IsOCI(repositoryURL) {
+			return repository.NewChartRepository(repositoryURL, "", l.getters, tlsConfig, clientOpts)
+		}
+
+		registryClient, err := l.newRegistryClient(tlsConfig, insecureHTTP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct Helm client: %w", err)
+		}
+		repoOpts := []repository.OCIChartRepositoryOption{
+			repository.WithOCIGetterOptions(clientOpts),
+			repository.WithOCIRegistryClient(registryClient),
+			repository.WithTLSConfig(tlsConfig),
+			repository.WithRetryPolicy(l.retryPolicy),
+			repository.WithMetricsRecorder(l.metrics),
+			repository.WithTraceContext(ctx),
+		}
+		if sameHost && creds.auth != nil {
+			repoOpts = append(repoOpts, repository.WithCredentials(creds.auth))
+		}
+		if insecureHTTP {
+			repoOpts = append(repoOpts, repository.WithInsecureHTTP())
+		}
+		return repository.NewOCIChartRepository(repositoryURL, repoOpts...)
+	}
+}
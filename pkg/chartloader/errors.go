@@ -0,0 +1,33 @@
+package chartloader
+
+import (
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// Reasons of the failures to load a chart. The errors returned by the ChartLoader
+// for these failures match their reason with errors.Is, e.g.
+// errors.Is(err, ErrChartNotFound), and can be inspected with errors.As as an
+// *Error, which holds the reference the failure is about and its cause.
+var (
+	// ErrChartNotFound means the chart, or its repository, does not exist.
+	ErrChartNotFound = repository.ErrChartNotFound
+	// ErrNoMatchingVersion means no version of the chart matches the requested
+	// version or semver constraint.
+	ErrNoMatchingVersion = repository.ErrNoMatchingVersion
+	// ErrUnauthorized means the credentials are missing, invalid, or do not
+	// grant access to the chart.
+	ErrUnauthorized = repository.ErrUnauthorized
+	// ErrVerificationFailed means the signature of the chart could not be verified.
+	ErrVerificationFailed = repository.ErrVerificationFailed
+	// ErrDigestMismatch means the downloaded chart does not match the digest
+	// of its manifest or layer descriptor.
+	ErrDigestMismatch = repository.ErrDigestMismatch
+	// ErrInvalidURL means the URL of the chart source is malformed.
+	ErrInvalidURL = repository.ErrInvalidURL
+	// ErrRegistryUnavailable means the registry or repository server could not
+	// be reached, or failed to serve the request.
+	ErrRegistryUnavailable = repository.ErrRegistryUnavailable
+)
+
+// Error is the error of a failure to load a chart, see repository.Error.
+type Error = repository.Error
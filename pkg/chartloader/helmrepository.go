@@ -0,0 +1,465 @@
+package chartloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/oci"
+	"github.com/fluxcd/pkg/oci/auth/login"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/getter"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+	soci "github.com/tamalsaha/learn-helm-oci/internal/oci"
+)
+
+const (
+	// AnnotationVerifyProvider is the annotation on a HelmRepository that enables
+	// signature verification of its charts with the given provider, e.g. "cosign".
+	AnnotationVerifyProvider = "charts.x-helm.dev/verify-provider"
+	// AnnotationVerifySecretRef is the annotation on a HelmRepository that names the
+	// Secret, in the same namespace, holding the trusted public keys ('*.pub').
+	// If it is not set, a keyless verification is performed.
+	AnnotationVerifySecretRef = "charts.x-helm.dev/verify-secret-ref"
+)
+
+func getHelmRepositorySecret(ctx context.Context, sources SourceProvider, repository *sourcev1.HelmRepository) (*corev1.Secret, error) {
+	if repository.Spec.SecretRef == nil {
+		return nil, nil
+	}
+	key := types.NamespacedName{
+		Namespace: repository.GetNamespace(),
+		Name:      repository.Spec.SecretRef.Name,
+	}
+	var secret corev1.Secret
+	err := sources.Get(ctx, key, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func clientOptionsFromSecret(secret *corev1.Secret, normalizedURL string) ([]helmgetter.Option, *tls.Config, error) {
+	opts, err := getter.ClientOptionsFromSecret(*secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure Helm client with secret data: %w", err)
+	}
+
+	tlsConfig, err := getter.TLSClientConfigFromSecret(*secret, normalizedURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create TLS client config with secret data: %w", err)
+	}
+
+	return opts, tlsConfig, nil
+}
+
+// verificationFor returns the chart signature verification settings declared
+// by the annotations of the given HelmRepository, or nil if none are declared.
+func verificationFor(repo *sourcev1.HelmRepository) *sourcev1.OCIRepositoryVerification {
+	provider := repo.GetAnnotations()[AnnotationVerifyProvider]
+	if provider == "" {
+		return nil
+	}
+	verify := &sourcev1.OCIRepositoryVerification{
+		Provider: provider,
+	}
+	if secretName := repo.GetAnnotations()[AnnotationVerifySecretRef]; secretName != "" {
+		verify.SecretRef = &meta.LocalObjectReference{Name: secretName}
+	}
+	return verify
+}
+
+// makeVerifiers returns a list of verifiers for the charts of a chart source in the given namespace.
+// If the verification settings reference a Secret, a verifier is created for every
+// public key ('*.pub') in the Secret. Otherwise, a single keyless verifier is returned.
+// The verifiers connect to the registry with the given TLS configuration, if any.
+func makeVerifiers(ctx context.Context, sources SourceProvider, namespace string, verify *sourcev1.OCIRepositoryVerification, auth authn.Authenticator, keychain authn.Keychain, tlsConfig *tls.Config) ([]soci.Verifier, error) {
+	var verifiers []soci.Verifier
+	verifyOpts := []remote.Option{}
+	if auth != nil {
+		verifyOpts = append(verifyOpts, remote.WithAuth(auth))
+	} else if keychain != nil {
+		verifyOpts = append(verifyOpts, remote.WithAuthFromKeychain(keychain))
+	}
+	if tlsConfig != nil {
+		verifyOpts = append(verifyOpts, remote.WithTransport(tlsTransport(tlsConfig)))
+	}
+
+	switch verify.Provider {
+	case "cosign":
+		defaultCosignOciOpts := []soci.Options{
+			soci.WithRemoteOptions(verifyOpts...),
+		}
+
+		// get the public keys from the given secret
+		if secretRef := verify.SecretRef; secretRef != nil {
+			certSecretName := types.NamespacedName{
+				Namespace: namespace,
+				Name:      secretRef.Name,
+			}
+
+			var pubSecret corev1.Secret
+			if err := sources.Get(ctx, certSecretName, &pubSecret); err != nil {
+				return nil, err
+			}
+
+			for k, data := range pubSecret.Data {
+				// search for public keys in the secret
+				if strings.HasSuffix(k, ".pub") {
+					verifier, err := soci.NewCosignVerifier(ctx, append(defaultCosignOciOpts, soci.WithPublicKey(data), soci.WithName(k))...)
+					if err != nil {
+						return nil, err
+					}
+					verifiers = append(verifiers, verifier)
+				}
+			}
+
+			if len(verifiers) == 0 {
+				return nil, fmt.Errorf("no public keys found in secret '%s'", certSecretName)
+			}
+			return verifiers, nil
+		}
+
+		// if no secret is provided, add a keyless verifier
+		verifier, err := soci.NewCosignVerifier(ctx, defaultCosignOciOpts...)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, verifier)
+		return verifiers, nil
+	default:
+		return nil, fmt.Errorf("unsupported verification provider: %s", verify.Provider)
+	}
+}
+
+// tlsTransport returns a copy of the default transport of remote requests
+// configured with the given TLS configuration.
+func tlsTransport(tlsConfig *tls.Config) *http.Transport {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	return t
+}
+
+// makeAuthenticator returns the registry authenticator of the given chart source,
+// resolved from its provider authenticator or keychain. If the chart source
+// specifies no credentials, a nil authenticator is returned.
+func makeAuthenticator(auth authn.Authenticator, keychain authn.Keychain, registryURL string) (authn.Authenticator, error) {
+	if auth != nil {
+		return registry.AuthAdaptHelper(auth)
+	}
+
+	if keychain != nil {
+		return registry.KeychainAdaptHelper(keychain)(registryURL)
+	}
+
+	return nil, nil
+}
+
+// oidcAuth generates the OIDC credential authenticator based on the specified cloud provider.
+func oidcAuth(ctx context.Context, url, provider string) (_ authn.Authenticator, err error) {
+	ctx, span := registry.StartSpan(ctx, "OIDCLogin", attributeProvider.String(provider))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	u := strings.TrimPrefix(url, sourcev1.OCIRepositoryPrefix)
+	ref, err := name.ParseReference(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL '%s': %w", u, err)
+	}
+	span.SetAttributes(registry.AttributeRegistryHost.String(ref.Context().RegistryStr()))
+
+	opts := login.ProviderOptions{}
+	switch provider {
+	case sourcev1.AmazonOCIProvider:
+		opts.AwsAutoLogin = true
+	case sourcev1.AzureOCIProvider:
+		opts.AzureAutoLogin = true
+	case sourcev1.GoogleOCIProvider:
+		opts.GcpAutoLogin = true
+	}
+
+	return login.NewManager().Login(ctx, u, ref, opts)
+}
+
+// helmRepositorySource is the chart repository of a HelmRepository, configured
+// with the credentials and verification settings of the HelmRepository.
+type helmRepositorySource struct {
+	chartRepo repository.Downloader
+	// verifierRepo is the OCI chart repository verifying the charts, if they must be verified.
+	verifierRepo *repository.OCIChartRepository
+	// scope identifies the HelmRepository in the store.
+	scope   string
+	creds   *sourceCredentials
+	timeout time.Duration
+	closers []func()
+	// sessionKey is the key of the registry session of the HelmRepository,
+	// if it has one.
+	sessionKey string
+}
+
+// Close logs out from the registry, and removes the temporary files of the chart repository.
+func (s *helmRepositorySource) Close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+}
+
+func (l *ChartLoader) loadFromHelmRepository(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	src, err := l.openHelmRepository(ctx, srcref)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	result, err := l.fetchChart(ctx, src.chartRepo, src.verifierRepo, src.scope, srcref)
+	if err != nil {
+		l.sessions.invalidateOnUnauthorized(src.sessionKey, err)
+		return nil, err
+	}
+	if err := l.buildDependencies(ctx, result.Chart, src.creds, src.timeout); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// openHelmRepository returns the chart repository of the HelmRepository referenced
+// by srcref. The returned source must be closed once the charts are downloaded.
+func (l *ChartLoader) openHelmRepository(ctx context.Context, srcref releasesapi.ChartSourceRef) (_ *helmRepositorySource, err error) {
+	var repo sourcev1.HelmRepository
+	err = l.getSource(ctx, srcref, &repo)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &helmRepositorySource{
+		timeout: remoteTimeout(ctx, repo.Spec.Timeout),
+	}
+	defer func() {
+		if err != nil {
+			src.Close()
+		}
+	}()
+
+	var (
+		tlsConfig     *tls.Config
+		secretOpts    []helmgetter.Option
+		authenticator authn.Authenticator
+		keychain      authn.Keychain
+	)
+	// Used to login with the repository declared provider
+	ctxTimeout, cancel := context.WithTimeout(ctx, src.timeout)
+	defer cancel()
+
+	normalizedURL := repository.NormalizeURL(repo.Spec.URL)
+	err = repository.ValidateDepURL(normalizedURL)
+	if err != nil {
+		return nil, repository.NewError(ErrInvalidURL, repo.Spec.URL, err)
+	}
+	// Construct the Getter options from the HelmRepository data
+	clientOpts := []helmgetter.Option{
+		helmgetter.WithURL(normalizedURL),
+		helmgetter.WithTimeout(src.timeout),
+		helmgetter.WithPassCredentialsAll(repo.Spec.PassCredentials),
+	}
+
+	// The objects read are recorded to check the registry session of the repository
+	sources := &recordingSources{SourceProvider: l.sources}
+	secret, err := getHelmRepositorySecret(ctx, sources, &repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret '%s': %w", repo.Spec.SecretRef.Name, err)
+	}
+	session, reused := sources.session(l.sessions, sourcev1.HelmRepositoryKind, &repo)
+
+	if secret != nil {
+		// Build client options from secret
+		opts, tls, err := clientOptionsFromSecret(secret, normalizedURL)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, opts...)
+		secretOpts = opts
+		tlsConfig = tls
+
+		// Build registryClient options from secret
+		keychain, err = registry.LoginOptionFromSecret(normalizedURL, *secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure Helm client with secret data: %w", err)
+		}
+	} else if repo.Spec.Provider != sourcev1.GenericOCIProvider && repo.Spec.Type == sourcev1.HelmRepositoryTypeOCI {
+		if !reused {
+			auth, authErr := l.providerAuth(ctxTimeout, repo.Spec.URL, repo.Spec.Provider)
+			if authErr != nil && !errors.Is(authErr, oci.ErrUnconfiguredProvider) {
+				return nil, fmt.Errorf("failed to get credential from %s: %w", repo.Spec.Provider, authErr)
+			}
+			session.auth = auth
+			if auth != nil {
+				session.expiresAt = providerSessionExpiry(repo.Spec.Provider, time.Now())
+			}
+		}
+		authenticator = session.auth
+	}
+
+	registryAuth, err := makeAuthenticator(authenticator, keychain, normalizedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	insecure, err := insecureModeFor(&repo)
+	if err != nil {
+		return nil, err
+	}
+	if insecure.plainHTTP && repo.Spec.Type != sourcev1.HelmRepositoryTypeOCI {
+		return nil, fmt.Errorf("annotation '%s' is only supported for HelmRepositories of type '%s'", AnnotationInsecureHTTP, sourcev1.HelmRepositoryTypeOCI)
+	}
+	l.warnInsecure(sourcev1.HelmRepositoryKind, &repo, normalizedURL, insecure)
+	tlsConfig = insecure.applyTo(tlsConfig)
+
+	versionPolicy, err := versionPolicyFor(&repo, l.versionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if hasVersionPolicy(&repo) && repo.Spec.Type != sourcev1.HelmRepositoryTypeOCI {
+		return nil, fmt.Errorf("version policies are only supported for HelmRepositories of type '%s'", sourcev1.HelmRepositoryTypeOCI)
+	}
+
+	verify := verificationFor(&repo)
+	if verify != nil && repo.Spec.Type != sourcev1.HelmRepositoryTypeOCI {
+		return nil, fmt.Errorf("signature verification is only supported for HelmRepositories of type '%s'", sourcev1.HelmRepositoryTypeOCI)
+	}
+
+	// Initialize the chart repository
+	switch repo.Spec.Type {
+	case sourcev1.HelmRepositoryTypeOCI:
+		if !helmreg.IsOCI(normalizedURL) {
+			return nil, repository.NewError(ErrInvalidURL, normalizedURL, fmt.Errorf("invalid OCI registry URL"))
+		}
+
+		// The credentials are kept in memory by the registry client, and sent
+		// with its requests to the registry, without a prior login request
+		registryClient := session.client
+		if registryClient == nil {
+			registryClient, err = l.newRegistryClient(tlsConfig, insecure.plainHTTP)
+			if err != nil {
+				return nil, fmt.Errorf("failed to construct Helm client: %w", err)
+			}
+		}
+
+		verifiers := l.verifiers
+		if len(verifiers) == 0 && verify != nil {
+			verifiers, err = makeVerifiers(ctx, l.sources, repo.Namespace, verify, authenticator, keychain, tlsConfig)
+			if err != nil {
+				provider := verify.Provider
+				if verify.SecretRef == nil {
+					provider = fmt.Sprintf("%s keyless", provider)
+				}
+				return nil, fmt.Errorf("failed to verify the signature using provider '%s': %w", provider, err)
+			}
+		}
+
+		// The registry client lists the tags and downloads the charts,
+		// so all requests to the registry use the same TLS configuration
+		repoOpts := []repository.OCIChartRepositoryOption{
+			repository.WithOCIGetterOptions(clientOpts),
+			repository.WithOCIRegistryClient(registryClient),
+			repository.WithTLSConfig(tlsConfig),
+			repository.WithRetryPolicy(l.retryPolicy),
+			repository.WithVerifiers(verifiers),
+			repository.WithMetricsRecorder(l.metrics),
+			repository.WithTraceContext(ctx),
+		}
+		if registryAuth != nil {
+			repoOpts = append(repoOpts, repository.WithCredentials(registryAuth))
+		}
+		if insecure.plainHTTP {
+			repoOpts = append(repoOpts, repository.WithInsecureHTTP())
+		}
+		if versionPolicy != nil {
+			repoOpts = append(repoOpts, repository.WithVersionPolicy(versionPolicy))
+		}
+		if names := chartNamesFor(&repo); len(names) > 0 {
+			repoOpts = append(repoOpts, repository.WithChartNames(names...))
+		}
+		if l.tagCache != nil {
+			// The tag listings are scoped by the HelmRepository, like its index
+			key := fmt.Sprintf("%s/%s/%s", repo.Namespace, repo.Name, normalizedURL)
+			repoOpts = append(repoOpts, repository.WithTagCache(key, l.tagCache, l.tagCacheTTL, l.cacheMetric(&repo)))
+			if isForceRefresh(ctx) {
+				repoOpts = append(repoOpts, repository.WithForceRefresh())
+			}
+		}
+		ociChartRepo, err := repository.NewOCIChartRepository(normalizedURL, repoOpts...)
+		if err != nil {
+			return nil, err
+		}
+		src.chartRepo = ociChartRepo
+		if len(verifiers) > 0 {
+			src.verifierRepo = ociChartRepo
+		}
+		if l.sessions != nil {
+			// The registry client keeps the credentials for the next loads,
+			// until the session is invalidated
+			if !reused {
+				session.client = registryClient
+				l.sessions.put(sessionKey(sourcev1.HelmRepositoryKind, &repo), session)
+			}
+			src.sessionKey = sessionKey(sourcev1.HelmRepositoryKind, &repo)
+		} else {
+			src.closers = append(src.closers, func() {
+				// Forget the credentials of the registry
+				_ = ociChartRepo.Clear()
+			})
+		}
+	default:
+		var cacheOpts []repository.ChartRepositoryOption
+		if l.cache != nil {
+			// The cache key have to be safe in multi-tenancy environments,
+			// as otherwise it could be used as a vector to bypass the helm repository's authentication.
+			key := fmt.Sprintf("%s/%s/%s", repo.Namespace, repo.Name, normalizedURL)
+			cacheOpts = append(cacheOpts, repository.WithMemoryCache(key, l.cache, l.cacheTTL, l.cacheMetric(&repo)))
+		}
+		httpChartRepo, err := repository.NewChartRepository(normalizedURL, "", l.getters, tlsConfig, clientOpts, cacheOpts...)
+		if err != nil {
+			return nil, err
+		}
+		src.chartRepo = httpChartRepo
+		src.closers = append(src.closers, func() {
+			// Cache the index in memory if configured, and delete
+			// the cached index file and the index reference
+			if err := httpChartRepo.Clear(); err != nil {
+				l.logger.Error(err, "failed to clear chart repository", "url", normalizedURL)
+			}
+		})
+	}
+
+	// The charts of the HelmRepository are scoped by the HelmRepository and its credentials in the
+	// store, as otherwise it could be used as a vector to bypass the helm repository's authentication.
+	src.scope = sourceScope(&repo, normalizedURL, sources.credentials())
+	src.creds = &sourceCredentials{
+		getterOpts:   append(secretOpts, helmgetter.WithPassCredentialsAll(repo.Spec.PassCredentials)),
+		tlsConfig:    tlsConfig,
+		insecureHTTP: insecure.plainHTTP,
+		auth:         registryAuth,
+	}
+	if u, err := url.Parse(normalizedURL); err == nil {
+		src.creds.host = u.Host
+	}
+	return src, nil
+}
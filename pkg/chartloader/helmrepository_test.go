@@ -0,0 +1,484 @@
+package chartloader
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// collectedSamples returns the values of the counters, and the sample counts of
+// the histograms, recorded by the given recorder, by metric name.
+func collectedSamples(g *WithT, recorder *MetricsRecorder) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(recorder.Collectors()...)
+	families, err := reg.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	samples := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				samples[f.GetName()] += float64(h.GetSampleCount())
+			} else {
+				samples[f.GetName()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return samples
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryWithTLS(t *testing.T) {
+	server := newTLSRegistryServer(t, "0.1.0", "0.1.1")
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "https://"))
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-tls", Namespace: "default"},
+		Data:       map[string][]byte{"caFile": ca},
+	}
+
+	tests := []struct {
+		name        string
+		secretRef   *meta.LocalObjectReference
+		annotations map[string]string
+		version     string
+		wantVersion string
+		wantErr     string
+	}{
+		{name: "exact version", secretRef: &meta.LocalObjectReference{Name: secret.Name}, version: "0.1.0", wantVersion: "0.1.0"},
+		{name: "version constraint", secretRef: &meta.LocalObjectReference{Name: secret.Name}, version: "~0.1", wantVersion: "0.1.1"},
+		{name: "unknown authority", version: "0.1.0", wantErr: "certificate"},
+		{name: "skip TLS verification", annotations: map[string]string{AnnotationInsecureSkipTLSVerify: "true"}, version: "~0.1", wantVersion: "0.1.1"},
+		{name: "invalid annotation", annotations: map[string]string{AnnotationInsecureSkipTLSVerify: "yes"}, version: "0.1.0", wantErr: "invalid value"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := helmRepository(repoURL)
+			repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+			repo.Spec.SecretRef = tt.secretRef
+			repo.Annotations = tt.annotations
+			l := New(&fakeClient{objects: []client.Object{repo, secret}})
+
+			result, err := l.Load(context.TODO(), chartSourceRef(tt.version))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.wantVersion))
+			g.Expect(result.Chart.Metadata.Version).To(Equal(tt.wantVersion))
+		})
+	}
+}
+
+func TestChartLoader_LoadFromHelmRepositoryInsecureHTTP(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newChartServer(t, "0.1.0")
+	repo := helmRepository(server.URL)
+	repo.Annotations = map[string]string{AnnotationInsecureHTTP: "true"}
+	l := New(&fakeClient{objects: []client.Object{repo}})
+
+	_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).To(MatchError(ContainSubstring("only supported for HelmRepositories of type 'oci'")))
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryWithCredentials(t *testing.T) {
+	handler := registryHandler(t, "0.1.0", "0.1.1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://"))
+
+	tests := []struct {
+		name        string
+		password    string
+		wantVersion string
+		wantErr     error
+		wantSamples map[string]float64
+	}{
+		{
+			name:        "valid credentials",
+			password:    "pass",
+			wantVersion: "0.1.1",
+			wantSamples: map[string]float64{
+				"chartloader_tag_list_duration_seconds":            1,
+				"chartloader_manifest_resolution_duration_seconds": 2,
+				"chartloader_download_duration_seconds":            1,
+				"chartloader_download_size_bytes":                  1,
+			},
+		},
+		{
+			name:     "invalid credentials",
+			password: "wrong",
+			wantErr:  ErrUnauthorized,
+			wantSamples: map[string]float64{
+				"chartloader_auth_failures_total": 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "registry-auth", Namespace: "default"},
+				Data:       map[string][]byte{"username": []byte("user"), "password": []byte(tt.password)},
+			}
+			repo := helmRepository(repoURL)
+			repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+			repo.Spec.SecretRef = &meta.LocalObjectReference{Name: secret.Name}
+			recorder := registry.NewMetricsRecorder()
+			l := New(&fakeClient{objects: []client.Object{repo, secret}}, WithMetricsRecorder(recorder))
+
+			result, err := l.Load(context.TODO(), chartSourceRef("~0.1"))
+			g.Expect(collectedSamples(g, recorder)).To(Equal(tt.wantSamples))
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.wantVersion))
+		})
+	}
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryPinned(t *testing.T) {
+	handler := registryHandler(t, "0.1.0", "0.1.1")
+	var corrupt bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !corrupt || !strings.Contains(r.URL.Path, "/blobs/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		blob := rec.Body.Bytes()
+		blob[len(blob)-1] ^= 0xff
+		_, _ = w.Write(blob)
+	}))
+	t.Cleanup(server.Close)
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://"))
+
+	g := NewWithT(t)
+
+	repo := helmRepository(repoURL)
+	repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	l := New(&fakeClient{objects: []client.Object{repo}})
+
+	// Tags are resolved to the digest of their manifest
+	result, err := l.Load(context.TODO(), chartSourceRef("~0.1"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.1"))
+	g.Expect(result.ManifestDigest).To(HavePrefix("sha256:"))
+	g.Expect(result.URL).To(Equal(fmt.Sprintf("%s/hello:0.1.1@%s", repoURL, result.ManifestDigest)))
+	g.Expect(result.Reference).To(Equal(result.URL))
+
+	// Versions pinned to a digest are pulled by digest
+	pinned, err := l.Load(context.TODO(), chartSourceRef("0.1.1@"+result.ManifestDigest))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pinned.Version).To(Equal("0.1.1"))
+	g.Expect(pinned.URL).To(Equal(result.URL))
+	g.Expect(pinned.Digest).To(Equal(result.Digest))
+
+	// Chart layers not matching their digest are rejected
+	corrupt = true
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.1"))
+	g.Expect(err).To(MatchError(ErrDigestMismatch))
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryVerifiedStore(t *testing.T) {
+	g := NewWithT(t)
+
+	var mu sync.Mutex
+	handler := registryHandler(t, "0.1.0")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		h := handler
+		mu.Unlock()
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	repo := helmRepository(fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://")))
+	repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	s, err := NewStore(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	l := New(&fakeClient{objects: []client.Object{repo}}, WithStore(s), WithVerifiers(&fakeVerifier{name: "a.pub", verified: true}))
+
+	first, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(first.Verified).To(BeTrue())
+
+	// The chart stored for the tag is not served once the tag is re-pushed
+	mu.Lock()
+	handler = describedRegistryHandler(t, "A re-pushed chart saying hello", "0.1.0")
+	mu.Unlock()
+	second, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(second.Verified).To(BeTrue())
+	g.Expect(second.ManifestDigest).ToNot(Equal(first.ManifestDigest))
+	g.Expect(second.Digest).ToNot(Equal(first.Digest))
+	g.Expect(second.Chart.Metadata.Description).To(Equal("A re-pushed chart saying hello"))
+
+	// but is served again while the tag references the verified manifest
+	third, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(third.Digest).To(Equal(second.Digest))
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryVersionPolicy(t *testing.T) {
+	server := newRegistryServer(t, "0.1.0", "v2023.08.18", "v2023.10.2", "v2023.10.9-rc.0")
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://"))
+
+	calver, err := NewVersionPolicy(VersionPolicyCalVer, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		opts         []Option
+		version      string
+		want         string
+		wantVersions []string
+		wantErr      string
+	}{
+		{
+			// Zero-padded dates are not semantic versions
+			name:         "semver by default",
+			want:         "v2023.10.2",
+			wantVersions: []string{"v2023.10.9-rc.0", "v2023.10.2", "0.1.0"},
+		},
+		{
+			name:         "calver annotation",
+			annotations:  map[string]string{AnnotationVersionPolicy: VersionPolicyCalVer},
+			want:         "v2023.10.2",
+			wantVersions: []string{"v2023.10.9-rc.0", "v2023.10.2", "v2023.08.18"},
+		},
+		{
+			name:        "calver constraint",
+			annotations: map[string]string{AnnotationVersionPolicy: VersionPolicyCalVer},
+			version:     "~2023.8",
+			want:        "v2023.08.18",
+		},
+		{
+			name: "calver with prereleases",
+			annotations: map[string]string{
+				AnnotationVersionPolicy:      VersionPolicyCalVer,
+				AnnotationIncludePrereleases: "true",
+			},
+			want: "v2023.10.9-rc.0",
+		},
+		{
+			name: "calver loader option",
+			opts: []Option{WithVersionPolicy(calver)},
+			want: "v2023.10.2",
+		},
+		{
+			name:        "annotation overrides loader option",
+			annotations: map[string]string{AnnotationVersionPolicy: VersionPolicySemver},
+			opts:        []Option{WithVersionPolicy(calver)},
+			version:     "<1.0.0",
+			want:        "0.1.0",
+		},
+		{
+			name: "version pattern",
+			annotations: map[string]string{
+				AnnotationVersionPolicy:  VersionPolicyNumerical,
+				AnnotationVersionPattern: `^v2023\.([0-9]+)\.`,
+				AnnotationVersionExtract: "$1",
+			},
+			want:         "v2023.10.2",
+			wantVersions: []string{"v2023.10.2", "v2023.08.18"},
+		},
+		{
+			name:        "unsupported policy",
+			annotations: map[string]string{AnnotationVersionPolicy: "lexical"},
+			wantErr:     "invalid value \"lexical\" of annotation 'charts.x-helm.dev/version-policy'",
+		},
+		{
+			name:        "invalid prereleases",
+			annotations: map[string]string{AnnotationIncludePrereleases: "yes"},
+			wantErr:     "invalid value \"yes\" of annotation 'charts.x-helm.dev/include-prereleases'",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := helmRepository(repoURL)
+			repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+			repo.Annotations = tt.annotations
+			l := New(&fakeClient{objects: []client.Object{repo}}, tt.opts...)
+
+			result, err := l.Load(context.TODO(), chartSourceRef(tt.version))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.want))
+
+			if tt.wantVersions != nil {
+				versions, err := l.Versions(context.TODO(), chartSourceRef(""))
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(versions).To(Equal(tt.wantVersions))
+			}
+		})
+	}
+
+	t.Run("HTTP chart repository", func(t *testing.T) {
+		g := NewWithT(t)
+
+		repo := helmRepository(newChartServer(t, "0.1.0").URL)
+		repo.Annotations = map[string]string{AnnotationVersionPolicy: VersionPolicyCalVer}
+		l := New(&fakeClient{objects: []client.Object{repo}})
+
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(err).To(MatchError(ContainSubstring("only supported for HelmRepositories of type 'oci'")))
+	})
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryTagCache(t *testing.T) {
+	handler := registryHandler(t, "0.1.0", "0.1.1")
+	var listings int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			listings++
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	g := NewWithT(t)
+
+	repo := helmRepository(fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://")))
+	repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	recorder := cache.NewCacheRecorder()
+	l := New(&fakeClient{objects: []client.Object{repo}}, WithTagCache(NewCache(10, 0), time.Hour), WithCacheRecorder(recorder))
+
+	// The fresh tag listing is served from the cache
+	for i := 0; i < 2; i++ {
+		result, err := l.Load(context.TODO(), chartSourceRef("~0.1"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Version).To(Equal("0.1.1"))
+	}
+	g.Expect(listings).To(Equal(1))
+
+	// Forced refreshes revalidate the listing
+	_, err := l.Load(ForceRefresh(context.TODO()), chartSourceRef("~0.1"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listings).To(Equal(2))
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(recorder.Collectors()...)
+	families, err := reg.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+	events := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "event_type" {
+					events[label.GetValue()] += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	g.Expect(events).To(Equal(map[string]float64{
+		cache.CacheEventTypeMiss:   1,
+		cache.CacheEventTypeHit:    1,
+		cache.CacheEventTypeUpdate: 1,
+	}))
+}
+
+// spanRecorder is a SpanExporter recording the exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestChartLoader_LoadFromOCIHelmRepositoryTracing(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newRegistryServer(t, "0.1.0", "0.1.1")
+	host := strings.TrimPrefix(server.URL, "http://")
+	repo := helmRepository(fmt.Sprintf("oci://%s/charts", host))
+	repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+
+	rec := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(rec))
+	l := New(&fakeClient{objects: []client.Object{repo}}, WithTracerProvider(tp))
+
+	result, err := l.Load(context.TODO(), chartSourceRef("~0.1"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.spans {
+		spans[s.Name()] = s
+	}
+	g.Expect(spans).To(HaveKey("ChartLoader.Load"))
+	g.Expect(spans).To(HaveKey("OCIChartRepository.GetChartVersion"))
+	g.Expect(spans).To(HaveKey("OCIChartRepository.Tags"))
+	g.Expect(spans).To(HaveKey("OCIChartRepository.Resolve"))
+	g.Expect(spans).To(HaveKey("OCIChartRepository.DownloadChart"))
+	g.Expect(spans).To(HaveKey("FetchManifest"))
+	g.Expect(spans).To(HaveKey("DownloadBlob"))
+
+	// The spans of a load belong to the trace of the load
+	load := spans["ChartLoader.Load"]
+	for _, s := range rec.spans {
+		g.Expect(s.SpanContext().TraceID()).To(Equal(load.SpanContext().TraceID()))
+	}
+	g.Expect(spans["OCIChartRepository.Tags"].Parent().SpanID()).To(Equal(spans["OCIChartRepository.GetChartVersion"].SpanContext().SpanID()))
+	g.Expect(spans["FetchManifest"].Parent().SpanID()).To(Equal(spans["OCIChartRepository.DownloadChart"].SpanContext().SpanID()))
+
+	g.Expect(load.Attributes()).To(ContainElements(
+		registry.AttributeChart.String("hello"),
+		registry.AttributeRequestedVersion.String("~0.1"),
+		registry.AttributeVersion.String("0.1.1"),
+		registry.AttributeDigest.String(result.ManifestDigest),
+		registry.AttributeRegistryHost.String(host),
+	))
+}
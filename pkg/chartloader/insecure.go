@@ -0,0 +1,82 @@
+package chartloader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AnnotationInsecureHTTP is the annotation on a HelmRepository of type 'oci' or an
+	// OCIRepository that allows connecting to its registry over plain HTTP when set to
+	// "true", e.g. to a local registry:2. For OCIRepositories, it is equivalent to
+	// their 'insecure' field.
+	AnnotationInsecureHTTP = "charts.x-helm.dev/insecure-http"
+	// AnnotationInsecureSkipTLSVerify is the annotation on a HelmRepository or an
+	// OCIRepository that disables the verification of the TLS certificate of its
+	// server when set to "true".
+	AnnotationInsecureSkipTLSVerify = "charts.x-helm.dev/insecure-skip-tls-verify"
+)
+
+// insecureMode holds the insecure connection settings of a chart source.
+type insecureMode struct {
+	// plainHTTP allows connecting to the registry over plain HTTP.
+	plainHTTP bool
+	// skipTLSVerify disables the verification of the server certificate.
+	skipTLSVerify bool
+}
+
+// insecureModeFor returns the insecure connection settings declared by the
+// annotations of the given chart source.
+func insecureModeFor(obj client.Object) (insecureMode, error) {
+	var (
+		m   insecureMode
+		err error
+	)
+	for annotation, field := range map[string]*bool{
+		AnnotationInsecureHTTP:          &m.plainHTTP,
+		AnnotationInsecureSkipTLSVerify: &m.skipTLSVerify,
+	} {
+		v, ok := obj.GetAnnotations()[annotation]
+		if !ok {
+			continue
+		}
+		if *field, err = strconv.ParseBool(v); err != nil {
+			return m, fmt.Errorf("invalid value %q of annotation '%s': %w", v, annotation, err)
+		}
+	}
+	return m, nil
+}
+
+// enabled returns true if any insecure setting is enabled.
+func (m insecureMode) enabled() bool {
+	return m.plainHTTP || m.skipTLSVerify
+}
+
+// applyTo returns the given TLS configuration, or a copy of it which skips the
+// verification of the server certificate if required. A nil config is only
+// replaced if the verification is skipped.
+func (m insecureMode) applyTo(tlsConfig *tls.Config) *tls.Config {
+	if !m.skipTLSVerify {
+		return tlsConfig
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = true
+	return tlsConfig
+}
+
+// warnInsecure logs a warning if insecure connections are enabled for the given chart source.
+func (l *ChartLoader) warnInsecure(kind string, obj client.Object, url string, m insecureMode) {
+	if !m.enabled() {
+		return
+	}
+	l.logger.Info("WARNING: insecure connections are enabled for chart source, do not use this in production",
+		"kind", kind, "namespace", obj.GetNamespace(), "name", obj.GetName(), "url", url,
+		"plainHTTP", m.plainHTTP, "skipTLSVerify", m.skipTLSVerify)
+}
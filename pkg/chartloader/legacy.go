@@ -0,0 +1,67 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// loadFromLegacy loads a chart from the HTTP chart repository whose URL is the
+// name of the source ref of srcref. No credentials are used.
+func (l *ChartLoader) loadFromLegacy(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	src, err := l.openLegacyRepository(ctx, srcref)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	result, err := l.fetchChart(ctx, src.chartRepo, nil, src.scope, srcref)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.buildDependencies(ctx, result.Chart, nil, src.timeout); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// openLegacyRepository returns the HTTP chart repository whose URL is the name
+// of the source ref of srcref. The returned source must be closed once the
+// charts are downloaded.
+func (l *ChartLoader) openLegacyRepository(ctx context.Context, srcref releasesapi.ChartSourceRef) (*helmRepositorySource, error) {
+	normalizedURL := repository.NormalizeURL(srcref.SourceRef.Name)
+	if err := repository.ValidateDepURL(normalizedURL); err != nil {
+		return nil, err
+	}
+
+	src := &helmRepositorySource{
+		// The repository is accessed without credentials,
+		// so its URL is a safe cache key.
+		scope:   fmt.Sprintf("%s/%s", releasesapi.SourceKindLegacy, normalizedURL),
+		timeout: remoteTimeout(ctx, nil),
+	}
+
+	clientOpts := []helmgetter.Option{
+		helmgetter.WithURL(normalizedURL),
+		helmgetter.WithTimeout(src.timeout),
+	}
+	var cacheOpts []repository.ChartRepositoryOption
+	if l.cache != nil {
+		cacheOpts = append(cacheOpts, repository.WithMemoryCache(src.scope, l.cache, l.cacheTTL, nil))
+	}
+	chartRepo, err := repository.NewChartRepository(normalizedURL, "", l.getters, nil, clientOpts, cacheOpts...)
+	if err != nil {
+		return nil, err
+	}
+	src.chartRepo = chartRepo
+	src.closers = append(src.closers, func() {
+		if err := chartRepo.Clear(); err != nil {
+			l.logger.Error(err, "failed to clear chart repository", "url", normalizedURL)
+		}
+	})
+	return src, nil
+}
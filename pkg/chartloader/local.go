@@ -0,0 +1,204 @@
+package chartloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/version"
+	"github.com/opencontainers/go-digest"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	helmchart "github.com/tamalsaha/learn-helm-oci/internal/helm/chart"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// loadFromLocal loads a chart from the directory named by the source ref of srcref.
+// The chart is either the chart archive '<dir>/<name>-<version>.tgz' packaged by
+// 'helm package' if it exists, or else the unpacked directory '<dir>/<name>'.
+func (l *ChartLoader) loadFromLocal(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	if err := validateChartName(srcref.Name); err != nil {
+		return nil, err
+	}
+	if srcref.SourceRef.Name == "" {
+		return nil, fmt.Errorf("missing chart directory for source kind %q", srcref.SourceRef.Kind)
+	}
+
+	var (
+		depOpts  []helmchart.DependencyManagerOption
+		unpacked bool
+	)
+	chartPath := filepath.Join(srcref.SourceRef.Name, srcref.Name)
+	archivePath := fmt.Sprintf("%s-%s.tgz", chartPath, srcref.Version)
+	fi, err := os.Stat(chartPath)
+	switch {
+	case srcref.Version != "" && fileExists(archivePath):
+		chartPath = archivePath
+	case err == nil && fi.IsDir():
+		unpacked = true
+		// Local dependencies of an unpacked chart are relative to its directory
+		depOpts = append(depOpts, helmchart.WithLocalPath(chartPath))
+	case os.IsNotExist(err) && srcref.Version != "":
+		chartPath = archivePath
+	case os.IsNotExist(err):
+		return nil, repository.NewError(ErrChartNotFound, chartPath, err)
+	case err != nil:
+		return nil, err
+	}
+
+	var (
+		chrt *chart.Chart
+		data []byte
+	)
+	if unpacked {
+		chrt, err = loader.LoadDir(chartPath)
+	} else if data, err = os.ReadFile(chartPath); err == nil {
+		chrt, err = loader.LoadArchive(bytes.NewReader(data))
+	}
+	if os.IsNotExist(err) {
+		return nil, repository.NewError(ErrChartNotFound, chartPath, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart from '%s': %w", chartPath, err)
+	}
+	result, err := chartResult(chrt, srcref, chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		result.Digest = digest.FromBytes(data).String()
+		result.Size = int64(len(data))
+		result.Archive = data
+	}
+	if err := l.buildDependencies(ctx, chrt, nil, remoteTimeout(ctx, nil), depOpts...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// loadFromEmbed loads a chart from the directory '<source ref name>/<name>' of the
+// embedded file system of the ChartLoader.
+func (l *ChartLoader) loadFromEmbed(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	chrt, dir, err := l.loadEmbeddedChart(srcref)
+	if err != nil {
+		return nil, err
+	}
+	result, err := chartResult(chrt, srcref, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.buildDependencies(ctx, chrt, nil, remoteTimeout(ctx, nil)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// loadEmbeddedChart loads the chart of the Embed chart source srcref, without
+// its dependencies. It returns the chart and its directory in the embedded file system.
+func (l *ChartLoader) loadEmbeddedChart(srcref releasesapi.ChartSourceRef) (*chart.Chart, string, error) {
+	if l.embedFS == nil {
+		return nil, "", fmt.Errorf("no embedded charts configured for source kind %q", srcref.SourceRef.Kind)
+	}
+
+	dir := path.Join(srcref.SourceRef.Name, srcref.Name)
+	if dir == "" {
+		dir = "."
+	}
+	fsys, err := fs.Sub(l.embedFS, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	chrt, err := loadFS(fsys)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", repository.NewError(ErrChartNotFound, dir, err)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load embedded chart from '%s': %w", dir, err)
+	}
+	return chrt, dir, nil
+}
+
+// loadFS loads an unpacked chart from the root of the given file system.
+// Unlike loader.LoadDir, it does not honor '.helmignore' files.
+func loadFS(fsys fs.FS) (*chart.Chart, error) {
+	var files []*loader.BufferedFile
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, &loader.BufferedFile{Name: name, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loader.LoadFiles(files)
+}
+
+// chartResult returns the Result of a chart loaded from the given location,
+// after checking that the chart matches the name and version of srcref.
+func chartResult(chrt *chart.Chart, srcref releasesapi.ChartSourceRef, location string) (*Result, error) {
+	if srcref.Name != "" && chrt.Name() != srcref.Name {
+		return nil, repository.NewError(ErrChartNotFound, location, fmt.Errorf("contains chart '%s', expected '%s'", chrt.Name(), srcref.Name))
+	}
+	if err := checkChartVersion(chrt.Metadata.Version, srcref.Version); err != nil {
+		return nil, repository.NewError(ErrNoMatchingVersion, location, fmt.Errorf("chart '%s': %w", chrt.Name(), err))
+	}
+	return &Result{
+		Chart:     chrt,
+		Name:      chrt.Name(),
+		Version:   chrt.Metadata.Version,
+		URL:       location,
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// checkChartVersion returns an error if the given chart version does not
+// match the version, or semver constraint, requested by the chart source ref.
+func checkChartVersion(chartVersion, want string) error {
+	if want == "" || want == chartVersion {
+		return nil
+	}
+	constraint, err := semver.NewConstraint(want)
+	if err != nil {
+		return fmt.Errorf("version '%s' does not match '%s'", chartVersion, want)
+	}
+	v, err := version.ParseVersion(chartVersion)
+	if err != nil {
+		return err
+	}
+	if !constraint.Check(v) {
+		return fmt.Errorf("version '%s' does not match '%s'", chartVersion, want)
+	}
+	return nil
+}
+
+// fileExists returns true if a regular file exists at the given path.
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// validateChartName returns an error if the given chart name is empty or
+// would escape the directory it is looked up in.
+func validateChartName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid chart name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package chartloader
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+func legacyChartSourceRef(kind, sourceName, chartName, version string) releasesapi.ChartSourceRef {
+	return releasesapi.ChartSourceRef{
+		Name:    chartName,
+		Version: version,
+		SourceRef: kmapi.TypedObjectReference{
+			Kind: kind,
+			Name: sourceName,
+		},
+	}
+}
+
+func TestChartLoader_LoadFromLocal(t *testing.T) {
+	dir := t.TempDir()
+	if err := chartutil.SaveDir(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "unpacked", Version: "0.1.0"},
+	}, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "packaged", Version: "1.2.3"},
+	}, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		chart       string
+		version     string
+		wantVersion string
+		wantErr     string
+	}{
+		{name: "directory", chart: "unpacked", wantVersion: "0.1.0"},
+		{name: "directory with version constraint", chart: "unpacked", version: "~0.1", wantVersion: "0.1.0"},
+		{name: "directory with unmatched version", chart: "unpacked", version: "0.2.0", wantErr: "does not match"},
+		{name: "archive", chart: "packaged", version: "1.2.3", wantVersion: "1.2.3"},
+		{name: "archive without version", chart: "packaged", wantErr: "no such file or directory"},
+		{name: "path traversal", chart: "../unpacked", wantErr: "invalid chart name"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := New(nil)
+			result, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindLocal, dir, tt.chart, tt.version))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.wantVersion))
+			g.Expect(result.Chart.Name()).To(Equal(tt.chart))
+		})
+	}
+}
+
+func TestChartLoader_LoadFromLocalWithDependencies(t *testing.T) {
+	server := newChartServer(t, "0.1.0", "0.2.0")
+
+	g := NewWithT(t)
+	dir := t.TempDir()
+	g.Expect(chartutil.SaveDir(&chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV2,
+			Name:       "parent",
+			Version:    "0.1.0",
+			Dependencies: []*chart.Dependency{
+				{Name: "hello", Version: "~0.1", Repository: server.URL},
+			},
+		},
+	}, dir)).To(Succeed())
+
+	l := New(nil)
+	result, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindLocal, dir, "parent", ""))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Chart.Dependencies()).To(HaveLen(1))
+	g.Expect(result.Chart.Dependencies()[0].Metadata.Version).To(Equal("0.1.0"))
+}
+
+func TestChartLoader_LoadFromEmbed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"charts/hello/Chart.yaml":          {Data: []byte("apiVersion: v2\nname: hello\nversion: 0.1.0\n")},
+		"charts/hello/values.yaml":         {Data: []byte("replicas: 1\n")},
+		"charts/hello/templates/cm.yaml":   {Data: []byte("kind: ConfigMap\n")},
+		"charts/invalid/templates/cm.yaml": {Data: []byte("kind: ConfigMap\n")},
+	}
+
+	t.Run("load chart", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(nil, WithEmbeddedCharts(fsys))
+		result, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindEmbed, "charts", "hello", "0.1.0"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Version).To(Equal("0.1.0"))
+		g.Expect(result.Chart.Values).To(HaveKeyWithValue("replicas", BeNumerically("==", 1)))
+		g.Expect(result.Chart.Templates).To(HaveLen(1))
+	})
+
+	t.Run("missing Chart.yaml", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(nil, WithEmbeddedCharts(fsys))
+		_, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindEmbed, "charts", "invalid", ""))
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("no embedded charts", func(t *testing.T) {
+		g := NewWithT(t)
+		l := New(nil)
+		_, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindEmbed, "charts", "hello", ""))
+		g.Expect(err).To(MatchError(ContainSubstring("no embedded charts configured")))
+	})
+}
+
+func TestChartLoader_LoadFromLegacy(t *testing.T) {
+	server := newChartServer(t, "0.1.0", "0.2.0")
+
+	g := NewWithT(t)
+	l := New(nil, WithCache(NewCache(10, 0), 0))
+	result, err := l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindLegacy, server.URL, "hello", "~0.1"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.0"))
+	g.Expect(result.Chart.Name()).To(Equal("hello"))
+
+	_, err = l.Load(context.TODO(), legacyChartSourceRef(releasesapi.SourceKindLegacy, "ftp://example.com", "hello", ""))
+	g.Expect(err).To(HaveOccurred())
+}
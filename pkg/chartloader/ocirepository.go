@@ -0,0 +1,551 @@
+package chartloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/oci"
+	"github.com/fluxcd/pkg/version"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	godigest "github.com/opencontainers/go-digest"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/getter"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+	soci "github.com/tamalsaha/learn-helm-oci/internal/oci"
+	"github.com/tamalsaha/learn-helm-oci/internal/store"
+)
+
+func (l *ChartLoader) loadFromOCIRepository(ctx context.Context, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	o, err := l.openOCIRepository(ctx, srcref)
+	if err != nil {
+		return nil, err
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	o.remoteOpts = append(o.remoteOpts, remote.WithContext(ctxTimeout))
+
+	// Charts of immutable references are served from the store,
+	// unless they must be verified
+	var result *Result
+	if len(l.verifiers) == 0 && o.repo.Spec.Verify == nil {
+		if ref, res := l.storedChart(o.storeRef); res != nil {
+			result, _ = archiveResult(res.Bytes(), ref)
+		}
+	}
+	if result == nil {
+		result, err = l.pullOCIChart(ctxTimeout, o, srcref)
+		if err != nil {
+			l.sessions.invalidateOnUnauthorized(sessionKey(sourcev1.OCIRepositoryKind, o.repo), err)
+			return nil, err
+		}
+	}
+	if srcref.Name != "" && result.Name != srcref.Name {
+		return nil, repository.NewError(ErrChartNotFound, result.URL,
+			fmt.Errorf("artifact contains chart '%s', expected '%s'", result.Name, srcref.Name))
+	}
+
+	registryAuth, err := makeAuthenticator(o.authenticator, o.keychain, o.repo.Spec.URL)
+	if err != nil {
+		return nil, err
+	}
+	creds := &sourceCredentials{
+		host:         strings.SplitN(o.url, "/", 2)[0],
+		tlsConfig:    o.tlsConfig,
+		insecureHTTP: o.insecureHTTP,
+		auth:         registryAuth,
+	}
+	if err := l.buildDependencies(ctx, result.Chart, creds, o.timeout); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// openOCIRepository returns the configuration to pull charts from the OCIRepository
+// referenced by srcref. The remote options do not include a context, which must
+// be added by the caller.
+func (l *ChartLoader) openOCIRepository(ctx context.Context, srcref releasesapi.ChartSourceRef) (*ociPull, error) {
+	var repo sourcev1.OCIRepository
+	err := l.getSource(ctx, srcref, &repo)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := remoteTimeout(ctx, repo.Spec.Timeout)
+	ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	insecure, err := insecureModeFor(&repo)
+	if err != nil {
+		return nil, err
+	}
+	insecure.plainHTTP = insecure.plainHTTP || repo.Spec.Insecure
+	l.warnInsecure(sourcev1.OCIRepositoryKind, &repo, repo.Spec.URL, insecure)
+
+	var nameOpts []name.Option
+	if insecure.plainHTTP {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	url, err := parseOCIRepositoryURL(repo.Spec.URL, nameOpts...)
+	if err != nil {
+		return nil, repository.NewError(ErrInvalidURL, repo.Spec.URL, err)
+	}
+
+	// The objects read are recorded to check the registry session of the repository
+	sources := &recordingSources{SourceProvider: l.sources}
+	useProvider := repo.Spec.Provider != "" && repo.Spec.Provider != sourcev1.GenericOCIProvider
+	var keychain authn.Keychain
+	if !useProvider {
+		keychain, err = ociRepositoryKeychain(ctxTimeout, sources, &repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Configure the TLS settings of the registry
+	var tlsConfig *tls.Config
+	if repo.Spec.CertSecretRef != nil {
+		var certSecret corev1.Secret
+		key := types.NamespacedName{Namespace: repo.Namespace, Name: repo.Spec.CertSecretRef.Name}
+		if err := sources.Get(ctxTimeout, key, &certSecret); err != nil {
+			return nil, fmt.Errorf("failed to get secret '%s': %w", key, err)
+		}
+		tlsConfig, err = getter.TLSClientConfigFromSecret(certSecret, repo.Spec.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TLS client config with secret data: %w", err)
+		}
+	}
+	tlsConfig = insecure.applyTo(tlsConfig)
+
+	session, reused := sources.session(l.sessions, sourcev1.OCIRepositoryKind, &repo)
+	if !reused {
+		if useProvider {
+			auth, authErr := l.providerAuth(ctxTimeout, repo.Spec.URL, repo.Spec.Provider)
+			if authErr != nil && !errors.Is(authErr, oci.ErrUnconfiguredProvider) {
+				return nil, fmt.Errorf("failed to get credential from %s: %w", repo.Spec.Provider, authErr)
+			}
+			session.auth = auth
+			if auth != nil {
+				session.expiresAt = providerSessionExpiry(repo.Spec.Provider, time.Now())
+			}
+		}
+		if tlsConfig != nil {
+			session.transport = tlsTransport(tlsConfig)
+		}
+		l.sessions.put(sessionKey(sourcev1.OCIRepositoryKind, &repo), session)
+	}
+	authenticator := session.auth
+
+	remoteOpts := []remote.Option{
+		remote.WithUserAgent(oci.UserAgent),
+	}
+	if authenticator != nil {
+		remoteOpts = append(remoteOpts, remote.WithAuth(authenticator))
+	} else if keychain != nil {
+		remoteOpts = append(remoteOpts, remote.WithAuthFromKeychain(keychain))
+	}
+	if session.transport != nil {
+		remoteOpts = append(remoteOpts, remote.WithTransport(session.transport))
+	}
+
+	return &ociPull{
+		repo:          &repo,
+		url:           url,
+		timeout:       timeout,
+		nameOpts:      nameOpts,
+		remoteOpts:    remoteOpts,
+		tlsConfig:     tlsConfig,
+		insecureHTTP:  insecure.plainHTTP,
+		authenticator: authenticator,
+		keychain:      keychain,
+		storeRef:      artifactRef(sourceScope(&repo, url, sources.credentials()), srcref.Name, requestedOCIReference(repo.Spec.Reference, srcref.Version)),
+	}, nil
+}
+
+// ociPull holds the configuration to pull a chart from an OCIRepository.
+type ociPull struct {
+	repo          *sourcev1.OCIRepository
+	url           string
+	timeout       time.Duration
+	nameOpts      []name.Option
+	remoteOpts    []remote.Option
+	tlsConfig     *tls.Config
+	insecureHTTP  bool
+	authenticator authn.Authenticator
+	keychain      authn.Keychain
+	// storeRef is the reference of the chart in the store,
+	// empty if the reference of the OCIRepository is mutable.
+	storeRef string
+}
+
+// pullOCIChart resolves the reference of the OCIRepository, verifies the artifact
+// if necessary, and loads the chart from its chart layer. The dependencies of the
+// returned chart are not built.
+//
+// The artifact is pulled with go-containerregistry, configured with the
+// authentication of fluxcd/pkg/oci, rather than with the client of
+// fluxcd/pkg/oci: its Pull only accepts artifacts annotated with the source
+// and revision of Flux, which Helm chart artifacts are not, and extracts their
+// first layer to a directory, without a size limit. The chart layer must
+// instead be selected with the layer selector of the OCIRepository, be read
+// within helm.MaxChartSize and be kept as an archive, to be loaded and stored.
+func (l *ChartLoader) pullOCIChart(ctx context.Context, o *ociPull, srcref releasesapi.ChartSourceRef) (*Result, error) {
+	// Resolve the reference of the chart artifact
+	ref, err := l.resolveOCIRepositoryRef(ctx, o.url, o.repo.Spec.Reference, srcref.Version, o.remoteOpts, o.nameOpts...)
+	if err != nil {
+		return nil, err
+	}
+	chartURL := fmt.Sprintf("%s%s", sourcev1.OCIRepositoryPrefix, ref)
+
+	host := ref.Context().RegistryStr()
+	_, span := registry.StartSpan(ctx, "FetchManifest", registry.AttributeRegistryHost.String(host))
+	start := time.Now()
+	img, err := remote.Image(ref, o.remoteOpts...)
+	if err != nil {
+		err = repository.WrapRegistryError(ref.String(), err)
+		registry.EndSpan(span, err)
+		l.recordAuthFailure(host, err)
+		return nil, fmt.Errorf("failed to pull artifact from '%s': %w", ref, err)
+	}
+	l.metrics.ObserveManifestResolution(host, start)
+	digest, err := img.Digest()
+	if err != nil {
+		registry.EndSpan(span, err)
+		return nil, fmt.Errorf("failed to determine artifact digest: %w", err)
+	}
+	span.SetAttributes(registry.AttributeDigest.String(digest.String()))
+	registry.EndSpan(span, nil)
+	// Pin the reference to the pulled digest, so the verified artifact is
+	// the one that is loaded even if the tag moves in between.
+	pinned := ref.Context().Digest(digest.String())
+
+	// Verify the artifact if necessary
+	verifier, err := l.verifyArtifact(ctx, o, ref, pinned)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := selectChartLayer(img, o.repo.Spec.LayerSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select chart layer of '%s': %w", ref, err)
+	}
+	// The chart layer is content addressed, so it is only downloaded once
+	var data []byte
+	if layerDigest, err := layer.Digest(); err == nil {
+		if res := l.storedBlob(godigest.Digest(layerDigest.String())); res != nil {
+			data = res.Bytes()
+		}
+	}
+	if data == nil {
+		_, span := registry.StartSpan(ctx, "DownloadLayer", registry.AttributeRegistryHost.String(host))
+		start := time.Now()
+		data, err = readChartLayer(layer)
+		registry.EndSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chart layer of '%s': %w", ref, err)
+		}
+		l.metrics.ObserveDownload(host, start, len(data))
+	}
+
+	artifact := &store.Ref{URL: chartURL, ManifestDigest: godigest.Digest(digest.String())}
+	result, err := archiveResult(data, artifact)
+	if err != nil {
+		return nil, err
+	}
+	if verifier != nil {
+		result.Verified = true
+		result.Verifier = fmt.Sprint(verifier)
+	}
+	artifact.Version = result.Version
+	l.storeChart(o.storeRef, data, *artifact)
+	return result, nil
+}
+
+// verifyArtifact verifies the signature of the artifact of the given reference,
+// pinned to its digest, with the verifiers of the ChartLoader, or else with the
+// verifiers of the OCIRepository, if any, and returns the verifier which
+// verified it. A nil verifier is returned if the artifact need not be verified.
+func (l *ChartLoader) verifyArtifact(ctx context.Context, o *ociPull, ref name.Reference, pinned name.Digest) (_ soci.Verifier, err error) {
+	verifiers := l.verifiers
+	verify := o.repo.Spec.Verify
+	if len(verifiers) == 0 && verify == nil {
+		return nil, nil
+	}
+
+	host := pinned.Context().RegistryStr()
+	ctx, span := registry.StartSpan(ctx, "VerifyArtifact",
+		registry.AttributeRegistryHost.String(host),
+		registry.AttributeDigest.String(pinned.DigestStr()))
+	defer func() {
+		registry.EndSpan(span, err)
+	}()
+
+	if len(verifiers) == 0 {
+		verifiers, err = makeVerifiers(ctx, l.sources, o.repo.Namespace, verify, o.authenticator, o.keychain, o.tlsConfig)
+		if err != nil {
+			provider := verify.Provider
+			if verify.SecretRef == nil {
+				provider = fmt.Sprintf("%s keyless", provider)
+			}
+			return nil, fmt.Errorf("failed to verify the signature using provider '%s': %w", provider, err)
+		}
+	}
+	verifier, err := matchingVerifier(ctx, verifiers, pinned)
+	if err != nil {
+		l.metrics.IncVerifications(host, registry.VerificationResultFailed)
+		return nil, repository.NewError(ErrVerificationFailed, ref.String(), err)
+	}
+	l.metrics.IncVerifications(host, registry.VerificationResultVerified)
+	l.logger.Info("verified artifact", "ref", ref.String(), "digest", pinned.DigestStr(), "verifier", fmt.Sprint(verifier))
+	return verifier, nil
+}
+
+// requestedOCIReference returns the reference requested from an OCIRepository:
+// the chart version if set, or else the digest, semver or tag of its reference.
+func requestedOCIReference(ociRef *sourcev1.OCIRepositoryRef, chartVersion string) string {
+	switch {
+	case chartVersion != "":
+		return chartVersion
+	case ociRef == nil:
+		return ""
+	case ociRef.Digest != "":
+		return ociRef.Digest
+	case ociRef.SemVer != "":
+		return ociRef.SemVer
+	default:
+		return ociRef.Tag
+	}
+}
+
+// parseOCIRepositoryURL validates the URL of an OCIRepository and returns it without the 'oci://' prefix.
+func parseOCIRepositoryURL(u string, opts ...name.Option) (string, error) {
+	if !strings.HasPrefix(u, sourcev1.OCIRepositoryPrefix) {
+		return "", fmt.Errorf("URL must be in format 'oci://<domain>/<org>/<repo>'")
+	}
+	url := strings.TrimPrefix(u, sourcev1.OCIRepositoryPrefix)
+	ref, err := name.ParseReference(url, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	imageName := strings.TrimPrefix(url, ref.Context().RegistryStr())
+	if s := strings.Split(imageName, ":"); len(s) > 1 {
+		return "", fmt.Errorf("URL must not contain a tag; remove ':%s'", s[1])
+	}
+	return ref.Context().Name(), nil
+}
+
+// resolveOCIRepositoryRef returns the reference of the artifact selected by the
+// OCIRepository reference. The chart version of the chart source ref, if set,
+// takes precedence. It is pulled as a digest if it is pinned to one, i.e.
+// 'tag@sha256:...' or 'sha256:...', and as a tag if it is not a semver
+// constraint, e.g. 'latest'. It is otherwise used as a semver constraint on
+// the tags of the repository, a tag equal to it being preferred.
+// If no reference is given, the 'latest' tag is used.
+func (l *ChartLoader) resolveOCIRepositoryRef(ctx context.Context, url string, ociRef *sourcev1.OCIRepositoryRef, chartVersion string, remoteOpts []remote.Option, opts ...name.Option) (name.Reference, error) {
+	if chartVersion != "" {
+		ociRef = &sourcev1.OCIRepositoryRef{SemVer: chartVersion}
+		if _, _, pinned := registry.SplitDigest(chartVersion); pinned {
+			ociRef = &sourcev1.OCIRepositoryRef{Digest: chartVersion}
+		} else if _, err := semver.NewConstraint(chartVersion); err != nil {
+			ociRef = &sourcev1.OCIRepositoryRef{Tag: chartVersion}
+		}
+	}
+	if ociRef == nil {
+		return name.ParseReference(url, opts...)
+	}
+
+	switch {
+	case ociRef.Digest != "":
+		tag, digest, pinned := registry.SplitDigest(ociRef.Digest)
+		if !pinned {
+			digest = ociRef.Digest
+		}
+		return name.NewDigest(registry.PinnedReference(url, tag, digest), opts...)
+	case ociRef.SemVer != "":
+		repository, err := name.NewRepository(url, opts...)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := l.getTagBySemver(ctx, repository, ociRef.SemVer, remoteOpts)
+		if err != nil {
+			return nil, err
+		}
+		return repository.Tag(tag), nil
+	case ociRef.Tag != "":
+		return name.NewTag(fmt.Sprintf("%s:%s", url, ociRef.Tag), opts...)
+	default:
+		return name.ParseReference(url, opts...)
+	}
+}
+
+// getTagBySemver returns the tag of the repository equal to the given semver
+// constraint if there is one, e.g. a 'v1' tag, or else the highest tag matching it.
+func (l *ChartLoader) getTagBySemver(ctx context.Context, repo name.Repository, exp string, remoteOpts []remote.Option) (string, error) {
+	tags, err := l.listTags(ctx, repo, remoteOpts)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tags {
+		if t == exp {
+			return t, nil
+		}
+	}
+
+	constraint, err := semver.NewConstraint(exp)
+	if err != nil {
+		return "", repository.NewError(ErrNoMatchingVersion, repo.String(), fmt.Errorf("semver '%s' parse error: %w", exp, err))
+	}
+
+	var matchingVersions []*semver.Version
+	for _, t := range tags {
+		v, err := version.ParseVersion(t)
+		if err != nil {
+			continue
+		}
+
+		if constraint.Check(v) {
+			matchingVersions = append(matchingVersions, v)
+		}
+	}
+
+	if len(matchingVersions) == 0 {
+		return "", repository.NewError(ErrNoMatchingVersion, repo.String(), fmt.Errorf("no match found for semver: %s", exp))
+	}
+
+	sort.Sort(sort.Reverse(semver.Collection(matchingVersions)))
+	return matchingVersions[0].Original(), nil
+}
+
+// listTags returns the tags of the given repository, and records the metrics
+// and the span of the request. The request is bound to the context of the
+// remote options, if any. The tags are listed with go-containerregistry, like
+// pullOCIChart pulls the artifacts, so both use the same authentication and
+// transport of the OCIRepository.
+func (l *ChartLoader) listTags(ctx context.Context, repo name.Repository, remoteOpts []remote.Option) ([]string, error) {
+	_, span := registry.StartSpan(ctx, "ListTags", registry.AttributeRegistryHost.String(repo.RegistryStr()))
+	start := time.Now()
+	tags, err := remote.List(repo, remoteOpts...)
+	if err != nil {
+		err = repository.WrapRegistryError(repo.String(), err)
+		registry.EndSpan(span, err)
+		l.recordAuthFailure(repo.RegistryStr(), err)
+		return nil, fmt.Errorf("failed to list tags of '%s': %w", repo, err)
+	}
+	l.metrics.ObserveTagList(repo.RegistryStr(), start)
+	registry.EndSpan(span, nil)
+	return tags, nil
+}
+
+// recordAuthFailure records an auth failure of the given registry host if
+// err is an unauthorized error.
+func (l *ChartLoader) recordAuthFailure(host string, err error) {
+	if errors.Is(err, ErrUnauthorized) {
+		l.metrics.IncAuthFailures(host)
+	}
+}
+
+// ociRepositoryKeychain returns the keychain built from the Secret and the
+// image pull secrets of the ServiceAccount referenced by the given OCIRepository.
+// If it references neither, a nil keychain is returned.
+func ociRepositoryKeychain(ctx context.Context, sources SourceProvider, repo *sourcev1.OCIRepository) (authn.Keychain, error) {
+	var secretNames []string
+	if repo.Spec.SecretRef != nil {
+		secretNames = append(secretNames, repo.Spec.SecretRef.Name)
+	}
+
+	if repo.Spec.ServiceAccountName != "" {
+		var sa corev1.ServiceAccount
+		key := types.NamespacedName{Namespace: repo.Namespace, Name: repo.Spec.ServiceAccountName}
+		if err := sources.Get(ctx, key, &sa); err != nil {
+			return nil, fmt.Errorf("failed to get service account '%s': %w", key, err)
+		}
+		for _, ips := range sa.ImagePullSecrets {
+			secretNames = append(secretNames, ips.Name)
+		}
+	}
+
+	if len(secretNames) == 0 {
+		return nil, nil
+	}
+
+	keychains := make([]authn.Keychain, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		var secret corev1.Secret
+		key := types.NamespacedName{Namespace: repo.Namespace, Name: secretName}
+		if err := sources.Get(ctx, key, &secret); err != nil {
+			return nil, fmt.Errorf("failed to get secret '%s': %w", key, err)
+		}
+		keychain, err := registry.LoginOptionFromSecret(repo.Spec.URL, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure registry client with secret data: %w", err)
+		}
+		keychains = append(keychains, keychain)
+	}
+	return authn.NewMultiKeychain(keychains...), nil
+}
+
+// matchingVerifier returns the first of the given verifiers which verifies the
+// signature of the given artifact.
+func matchingVerifier(ctx context.Context, verifiers []soci.Verifier, ref name.Reference) (soci.Verifier, error) {
+	for _, verifier := range verifiers {
+		if verified, err := verifier.Verify(ctx, ref); err != nil {
+			return nil, fmt.Errorf("failed to verify: %w", err)
+		} else if verified {
+			return verifier, nil
+		}
+	}
+	return nil, fmt.Errorf("no matching signatures were found")
+}
+
+// selectChartLayer returns the layer of the given artifact which holds the chart.
+// If the layer selector specifies a media type, the first layer of that type is
+// returned. Otherwise, the Helm chart layer is preferred over the first layer.
+func selectChartLayer(img v1.Image, selector *sourcev1.OCILayerSelector) (v1.Layer, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers found in artifact")
+	}
+
+	mediaType := helmreg.ChartLayerMediaType
+	if selector != nil && selector.MediaType != "" {
+		mediaType = selector.MediaType
+	}
+	for _, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if string(mt) == mediaType {
+			return layer, nil
+		}
+	}
+	if selector != nil && selector.MediaType != "" {
+		return nil, fmt.Errorf("no layer of media type '%s' found in artifact", mediaType)
+	}
+	return layers[0], nil
+}
+
+// readChartLayer returns the compressed content of the given layer, which
+// must not exceed helm.MaxChartSize, verified against the digest of the layer.
+func readChartLayer(layer v1.Layer) ([]byte, error) {
+	return registry.ReadLayer(layer, helm.MaxChartSize)
+}
@@ -0,0 +1,332 @@
+package chartloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmreg "helm.sh/helm/v3/pkg/registry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+// fakeVerifier verifies the signature of every artifact if verified is true.
+type fakeVerifier struct {
+	name     string
+	verified bool
+}
+
+func (v *fakeVerifier) Verify(_ context.Context, _ name.Reference) (bool, error) {
+	return v.verified, nil
+}
+
+func (v *fakeVerifier) String() string {
+	return v.name
+}
+
+// newRegistryServer starts a read-only OCI registry serving the given versions
+// of a chart named hello as Helm chart artifacts tagged with their version.
+func newRegistryServer(t *testing.T, versions ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(registryHandler(t, versions...))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTLSRegistryServer is like newRegistryServer, but serves TLS with a
+// certificate signed by an unknown authority.
+func newTLSRegistryServer(t *testing.T, versions ...string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(registryHandler(t, versions...))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// registryHandler returns a handler serving the 'hello' chart in the given
+// versions from the 'charts/hello' repository of an OCI registry. The last
+// version is also tagged 'latest', which is not listed with the tags.
+func registryHandler(t *testing.T, versions ...string) http.Handler {
+	t.Helper()
+	return describedRegistryHandler(t, "A chart saying hello", versions...)
+}
+
+// describedRegistryHandler is like registryHandler, with the given description
+// of the chart, e.g. to serve other charts for the same tags.
+func describedRegistryHandler(t *testing.T, description string, versions ...string) http.Handler {
+	t.Helper()
+
+	manifests := map[string][]byte{}
+	blobs := map[string][]byte{}
+	for _, v := range versions {
+		dir := t.TempDir()
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{
+				APIVersion:  chart.APIVersionV2,
+				Name:        "hello",
+				Version:     v,
+				Description: description,
+			},
+		}
+		p, err := chartutil.Save(c, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Clean(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		layer, err := tarball.LayerFromReader(bytes.NewReader(data), tarball.WithMediaType(helmreg.ChartLayerMediaType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The config of a chart artifact is the metadata of the chart
+		config, err := json.Marshal(c.Metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configDigest, _, err := v1.SHA256(bytes.NewReader(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := json.Marshal(v1.Manifest{
+			SchemaVersion: 2,
+			MediaType:     types.OCIManifestSchema1,
+			Config:        v1.Descriptor{MediaType: helmreg.ConfigMediaType, Size: int64(len(config)), Digest: configDigest},
+			Layers:        []v1.Descriptor{{MediaType: helmreg.ChartLayerMediaType, Size: int64(len(data)), Digest: layerDigest}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifestDigest, _, err := v1.SHA256(bytes.NewReader(manifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests[v] = manifest
+		manifests["latest"] = manifest
+		manifests[manifestDigest.String()] = manifest
+		blobs[configDigest.String()] = config
+		blobs[layerDigest.String()] = data
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v2/charts/hello/")
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/_catalog":
+			_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": {"charts/hello"}})
+		case path == "tags/list":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "charts/hello", "tags": versions})
+		case strings.HasPrefix(path, "manifests/"):
+			manifest, ok := manifests[strings.TrimPrefix(path, "manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", string(types.OCIManifestSchema1))
+			w.Header().Set("Content-Length", fmt.Sprint(len(manifest)))
+			if r.Method != http.MethodHead {
+				_, _ = w.Write(manifest)
+			}
+		case strings.HasPrefix(path, "blobs/"):
+			blob, ok := blobs[strings.TrimPrefix(path, "blobs/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func ociRepository(url string, ref *sourcev1.OCIRepositoryRef) *sourcev1.OCIRepository {
+	return &sourcev1.OCIRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hello",
+			Namespace: "default",
+		},
+		Spec: sourcev1.OCIRepositorySpec{
+			URL:       url,
+			Reference: ref,
+		},
+	}
+}
+
+func ociChartSourceRef(version string) releasesapi.ChartSourceRef {
+	return releasesapi.ChartSourceRef{
+		Name:    "hello",
+		Version: version,
+		SourceRef: kmapi.TypedObjectReference{
+			APIGroup:  sourcev1.GroupVersion.Group,
+			Kind:      sourcev1.OCIRepositoryKind,
+			Namespace: "default",
+			Name:      "hello",
+		},
+	}
+}
+
+func TestChartLoader_LoadFromOCIRepository(t *testing.T) {
+	server := newRegistryServer(t, "0.1.0", "0.1.1", "0.2.0")
+	url := fmt.Sprintf("oci://%s/charts/hello", strings.TrimPrefix(server.URL, "http://"))
+
+	tests := []struct {
+		name        string
+		url         string
+		ref         *sourcev1.OCIRepositoryRef
+		version     string
+		wantVersion string
+		wantErr     string
+	}{
+		{name: "tag", url: url, ref: &sourcev1.OCIRepositoryRef{Tag: "0.1.0"}, wantVersion: "0.1.0"},
+		{name: "semver", url: url, ref: &sourcev1.OCIRepositoryRef{SemVer: "~0.1"}, wantVersion: "0.1.1"},
+		{name: "chart version overrides ref", url: url, ref: &sourcev1.OCIRepositoryRef{Tag: "0.1.0"}, version: "0.2.0", wantVersion: "0.2.0"},
+		{name: "unmatched semver", url: url, ref: &sourcev1.OCIRepositoryRef{SemVer: ">1.0.0"}, wantErr: "no match found for semver"},
+		{name: "unknown tag", url: url, ref: &sourcev1.OCIRepositoryRef{Tag: "1.0.0"}, wantErr: "failed to pull artifact"},
+		{name: "URL without oci scheme", url: strings.TrimPrefix(url, "oci://"), wantErr: "URL must be in format"},
+		{name: "URL with tag", url: url + ":0.1.0", wantErr: "URL must not contain a tag"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := New(&fakeClient{objects: []client.Object{ociRepository(tt.url, tt.ref)}})
+			result, err := l.Load(context.TODO(), ociChartSourceRef(tt.version))
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(result.Version).To(Equal(tt.wantVersion))
+			g.Expect(result.URL).To(Equal(fmt.Sprintf("%s:%s", url, tt.wantVersion)))
+			g.Expect(result.Chart.Metadata.Name).To(Equal("hello"))
+			g.Expect(result.ManifestDigest).To(HavePrefix("sha256:"))
+			g.Expect(result.Reference).To(Equal(fmt.Sprintf("%s@%s", result.URL, result.ManifestDigest)))
+			g.Expect(result.Digest).ToNot(Equal(result.ManifestDigest))
+		})
+	}
+
+	t.Run("digest", func(t *testing.T) {
+		g := NewWithT(t)
+
+		resp, err := http.Get(server.URL + "/v2/charts/hello/manifests/0.1.1")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		manifest, err := io.ReadAll(resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		dgst := digest.FromBytes(manifest).String()
+
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Digest: dgst, Tag: "0.2.0"})}})
+		result, err := l.Load(context.TODO(), ociChartSourceRef(""))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Version).To(Equal("0.1.1"))
+		g.Expect(result.URL).To(Equal(fmt.Sprintf("%s@%s", url, dgst)))
+		g.Expect(result.Reference).To(Equal(result.URL))
+		g.Expect(result.ManifestDigest).To(Equal(dgst))
+	})
+
+	t.Run("chart version tag", func(t *testing.T) {
+		g := NewWithT(t)
+
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Tag: "0.1.0"})}})
+		result, err := l.Load(context.TODO(), ociChartSourceRef("latest"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Version).To(Equal("0.2.0"))
+		g.Expect(result.URL).To(Equal(url + ":latest"))
+	})
+
+	t.Run("tag pinned to digest", func(t *testing.T) {
+		g := NewWithT(t)
+
+		pulled, err := New(&fakeClient{objects: []client.Object{ociRepository(url, nil)}}).Load(context.TODO(), ociChartSourceRef("0.1.1"))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Tag: "0.2.0"})}})
+		result, err := l.Load(context.TODO(), ociChartSourceRef("0.1.1@"+pulled.ManifestDigest))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Version).To(Equal("0.1.1"))
+		g.Expect(result.URL).To(Equal(fmt.Sprintf("%s:0.1.1@%s", url, pulled.ManifestDigest)))
+		g.Expect(result.Reference).To(Equal(result.URL))
+		g.Expect(result.ManifestDigest).To(Equal(pulled.ManifestDigest))
+	})
+
+	t.Run("verified", func(t *testing.T) {
+		g := NewWithT(t)
+
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Tag: "0.1.0"})}},
+			WithVerifiers(&fakeVerifier{name: "a.pub"}, &fakeVerifier{name: "b.pub", verified: true}))
+		result, err := l.Load(context.TODO(), ociChartSourceRef(""))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(result.Verified).To(BeTrue())
+		g.Expect(result.Verifier).To(Equal("b.pub"))
+
+		l = New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Tag: "0.1.0"})}},
+			WithVerifiers(&fakeVerifier{name: "a.pub"}))
+		_, err = l.Load(context.TODO(), ociChartSourceRef(""))
+		g.Expect(err).To(MatchError(ErrVerificationFailed))
+	})
+
+	t.Run("chart name mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, &sourcev1.OCIRepositoryRef{Tag: "0.1.0"})}})
+		ref := ociChartSourceRef("")
+		ref.Name = "world"
+		_, err := l.Load(context.TODO(), ref)
+		g.Expect(err).To(MatchError(ContainSubstring("contains chart 'hello', expected 'world'")))
+	})
+}
+
+func TestChartLoader_LoadFromOCIRepositoryStore(t *testing.T) {
+	g := NewWithT(t)
+
+	server := newRegistryServer(t, "0.1.0", "0.2.0")
+	url := fmt.Sprintf("oci://%s/charts/hello", strings.TrimPrefix(server.URL, "http://"))
+	s, err := NewStore(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	load := func(ref *sourcev1.OCIRepositoryRef) (*Result, error) {
+		l := New(&fakeClient{objects: []client.Object{ociRepository(url, ref)}}, WithStore(s))
+		return l.Load(context.TODO(), ociChartSourceRef(""))
+	}
+
+	pulled, err := load(&sourcev1.OCIRepositoryRef{Tag: "0.1.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server.Close()
+	result, err := load(&sourcev1.OCIRepositoryRef{Tag: "0.1.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Version).To(Equal("0.1.0"))
+	g.Expect(result.URL).To(Equal(url + ":0.1.0"))
+	g.Expect(result.ManifestDigest).To(Equal(pulled.ManifestDigest))
+	g.Expect(result.Digest).To(Equal(pulled.Digest))
+
+	_, err = load(&sourcev1.OCIRepositoryRef{SemVer: "~0.1"})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,209 @@
+package chartloader
+
+import (
+	"crypto/tls"
+	"io/fs"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
+	helmgetter "helm.sh/helm/v3/pkg/getter"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/cache"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+	"github.com/tamalsaha/learn-helm-oci/internal/oci"
+)
+
+// Verifier verifies the signature of an OCI artifact.
+type Verifier = oci.Verifier
+
+// Cache is a thread-safe in-memory cache used to store
+// the indexes of HTTP chart repositories.
+type Cache = cache.Cache
+
+// NewCache returns a Cache that holds at most maxItems items, and
+// removes expired items every interval.
+func NewCache(maxItems int, interval time.Duration) *Cache {
+	return cache.New(maxItems, interval)
+}
+
+// CacheRecorder records the hits and misses of the caches of a ChartLoader,
+// by chart source.
+type CacheRecorder = cache.CacheRecorder
+
+// MustMakeCacheMetrics returns a CacheRecorder whose metrics are registered in
+// the controller-runtime metrics registry. It panics if they are already registered.
+func MustMakeCacheMetrics() *CacheRecorder {
+	return cache.MustMakeMetrics()
+}
+
+// RegistryClient is the client used to list the tags of, and to download the
+// charts from, OCI chart repositories.
+type RegistryClient = registry.Client
+
+// RegistryClientOption configures a RegistryClient.
+type RegistryClientOption = registry.ClientOption
+
+// RegistryClientFactory returns a registry client configured with the given
+// TLS configuration, which connects to registries over plain HTTP if
+// insecureHTTP is true, and with the given options, e.g. the metrics recorder
+// of the ChartLoader. The credentials of the chart sources are set on the
+// client by the ChartLoader, and are only kept in memory.
+type RegistryClientFactory func(tlsConfig *tls.Config, insecureHTTP bool, opts ...RegistryClientOption) (*RegistryClient, error)
+
+// MetricsRecorder records the metrics of the chart resolutions and downloads
+// from registries, e.g. the tag listing latency and the downloaded bytes per
+// registry host.
+type MetricsRecorder = registry.MetricsRecorder
+
+// MustMakeMetrics returns a MetricsRecorder whose metrics are registered in
+// the controller-runtime metrics registry. It panics if they are already registered.
+func MustMakeMetrics() *MetricsRecorder {
+	return registry.MustMakeMetrics()
+}
+
+// RetryPolicy configures the retries of the operations on OCI chart
+// repositories. Only transient errors, e.g. timeouts and '429 Too Many
+// Requests' responses, are retried.
+type RetryPolicy = repository.RetryPolicy
+
+// Option configures a ChartLoader.
+type Option func(*ChartLoader)
+
+// WithGetters sets the getter providers used to download chart repository
+// indexes and charts from HTTP chart repositories. The charts of OCI chart
+// repositories are downloaded with the registry client.
+func WithGetters(getters helmgetter.Providers) Option {
+	return func(l *ChartLoader) {
+		l.getters = getters
+	}
+}
+
+// WithRegistryClientFactory sets the factory used to create the
+// registry client for OCI chart repositories.
+func WithRegistryClientFactory(factory RegistryClientFactory) Option {
+	return func(l *ChartLoader) {
+		l.registryClientFactory = factory
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the operations on OCI chart
+// repositories, which defaults to repository.DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(l *ChartLoader) {
+		l.retryPolicy = policy
+	}
+}
+
+// WithMetricsRecorder sets the recorder of the metrics of the operations on
+// OCI chart repositories and OCIRepositories.
+func WithMetricsRecorder(recorder *MetricsRecorder) Option {
+	return func(l *ChartLoader) {
+		l.metrics = recorder
+	}
+}
+
+// WithTracerProvider sets the TracerProvider of the traces of the chart loads,
+// which defaults to the global TracerProvider of OpenTelemetry.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(l *ChartLoader) {
+		l.tracer = tp.Tracer(registry.TracerName)
+	}
+}
+
+// WithCache sets the cache used to store the indexes of HTTP chart
+// repositories for the given ttl.
+func WithCache(c *Cache, ttl time.Duration) Option {
+	return func(l *ChartLoader) {
+		l.cache = c
+		l.cacheTTL = ttl
+	}
+}
+
+// WithTagCache sets the cache used to store the tag listings of the charts of
+// OCI chart repositories, so that version constraints are resolved without
+// listing the tags of the chart on every load. The listings are fresh for ttl,
+// and are then revalidated with the registry, with a conditional request if
+// the registry supports it. See ForceRefresh to revalidate fresh listings.
+func WithTagCache(c *Cache, ttl time.Duration) Option {
+	return func(l *ChartLoader) {
+		l.tagCache = c
+		l.tagCacheTTL = ttl
+	}
+}
+
+// WithCacheRecorder sets the recorder of the cache events of the index and tag caches.
+func WithCacheRecorder(rec *CacheRecorder) Option {
+	return func(l *ChartLoader) {
+		l.cacheRecorder = rec
+	}
+}
+
+// WithVersionPolicy sets the default policy selecting the tags of the requested
+// chart versions of OCI chart repositories, which defaults to semantic versions
+// without prereleases. The version policy annotations of a HelmRepository, e.g.
+// AnnotationVersionPolicy, override it.
+func WithVersionPolicy(policy VersionPolicy) Option {
+	return func(l *ChartLoader) {
+		l.versionPolicy = policy
+	}
+}
+
+// WithVerifiers sets the verifiers used to verify the signature of charts
+// loaded from OCI chart repositories and OCIRepositories. When set, every OCI
+// chart must be verified, and the verification settings of the source are ignored.
+func WithVerifiers(verifiers ...Verifier) Option {
+	return func(l *ChartLoader) {
+		l.verifiers = verifiers
+	}
+}
+
+// WithEmbeddedCharts sets the file system, usually an embed.FS, the charts
+// of Embed chart sources are loaded from.
+func WithEmbeddedCharts(fsys fs.FS) Option {
+	return func(l *ChartLoader) {
+		l.embedFS = fsys
+	}
+}
+
+// WithConcurrency sets the maximum number of charts loaded concurrently by
+// LoadBatch, which defaults to DefaultConcurrency. Values lower than 1 are ignored.
+func WithConcurrency(n int) Option {
+	return func(l *ChartLoader) {
+		if n > 0 {
+			l.concurrency = n
+		}
+	}
+}
+
+// WithRegistrySessions makes the ChartLoader keep the registry clients,
+// credentials and connections of the chart sources between loads, for
+// long-running loaders which load charts of the same sources repeatedly.
+// The session of a chart source is invalidated when its URL, or one of the
+// Secrets it references, changes; see WatchSources to invalidate them as soon
+// as the objects change. It is also renewed before the token of its cloud
+// provider expires, and dropped when the registry rejects its credentials.
+func WithRegistrySessions() Option {
+	return func(l *ChartLoader) {
+		l.sessions = &sessionCache{}
+	}
+}
+
+// WithLogger sets the logger of the ChartLoader.
+func WithLogger(logger logr.Logger) Option {
+	return func(l *ChartLoader) {
+		l.logger = logger
+	}
+}
+
+// DefaultGetters are the getter providers used when none are configured.
+var DefaultGetters = helmgetter.Providers{
+	helmgetter.Provider{
+		Schemes: []string{"http", "https"},
+		New:     helmgetter.NewHTTPGetter,
+	},
+}
+
+// DefaultRegistryClientFactory is the RegistryClientFactory used when none is configured.
+var DefaultRegistryClientFactory RegistryClientFactory = registry.ClientGenerator
@@ -0,0 +1,58 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// PushOptions configures the chart artifact pushed by ChartLoader.Push.
+type PushOptions struct {
+	// Provenance is the provenance file of the chart archive, pushed
+	// alongside it, if set.
+	Provenance []byte
+	// Source is the URL of the source of the chart, e.g. its Git repository,
+	// set as the 'org.opencontainers.image.source' annotation, if set.
+	Source string
+	// Revision is the revision of the source of the chart, e.g. a Git commit,
+	// set as the 'org.opencontainers.image.revision' annotation, if set.
+	Revision string
+	// Created is the creation time of the artifact, set as the
+	// 'org.opencontainers.image.created' annotation, the time of the push if
+	// it is zero.
+	Created time.Time
+}
+
+// Push uploads the given chart archive to the HelmRepository of type 'oci'
+// referenced by srcref, as the artifact '<url>/<name>:<version>' of the chart,
+// like 'helm push' does, with the credentials and TLS configuration of the
+// HelmRepository, and returns the digest of its manifest. The name and the
+// version of srcref are ignored. As the push replaces the artifact of the
+// version, if any, the charts stored for the version are forgotten.
+func (l *ChartLoader) Push(ctx context.Context, srcref releasesapi.ChartSourceRef, archive []byte, opts PushOptions) (digest string, err error) {
+	pushOpts := []repository.PushOption{
+		repository.WithProvenance(opts.Provenance),
+		repository.WithSource(opts.Source),
+		repository.WithRevision(opts.Revision),
+	}
+	if !opts.Created.IsZero() {
+		pushOpts = append(pushOpts, repository.WithCreated(opts.Created))
+	}
+	err = l.withOCIChartRepository(ctx, srcref, "pushing charts", func(r *repository.OCIChartRepository, scope string) error {
+		res, err := r.Push(archive, pushOpts...)
+		if err != nil {
+			return err
+		}
+		l.forgetStoredChart(scope, res.Metadata.Name, res.Metadata.Version)
+		digest = res.Digest
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to push chart: %w", err)
+	}
+	return digest, nil
+}
@@ -0,0 +1,118 @@
+package chartloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+	"github.com/tamalsaha/learn-helm-oci/internal/store"
+)
+
+// newPushRegistryServer starts an OCI registry accepting blob uploads and
+// manifests, and returns the pushed manifests by path.
+func newPushRegistryServer(t *testing.T) (*httptest.Server, map[string][]byte) {
+	t.Helper()
+
+	var mu sync.Mutex
+	manifests := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/blobs/uploads/"):
+			w.Header().Set("Location", "/upload")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/upload" && r.Method == http.MethodPatch:
+			w.Header().Set("Location", "/upload")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/upload" && r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		case strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodPut:
+			manifests[r.URL.Path] = body
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, manifests
+}
+
+func TestChartLoader_Push(t *testing.T) {
+	g := NewWithT(t)
+
+	p, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "0.3.0"},
+	}, t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	archive, err := os.ReadFile(p)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server, manifests := newPushRegistryServer(t)
+	source := helmRepository(fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://")))
+	source.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	s, err := NewStore(t.TempDir(), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	l := New(&fakeClient{objects: []client.Object{source}}, WithStore(s))
+
+	// The chart previously stored for the version is replaced by the push
+	scope := sourceScope(source, repository.NormalizeURL(source.Spec.URL), nil)
+	l.storeChart(artifactRef(scope, "hello", "0.3.0"), []byte("stale"), store.Ref{Version: "0.3.0"})
+	l.storeChart(artifactRef(scope, "hello", "v0.3.0"), []byte("stale"), store.Ref{Version: "0.3.0"})
+	stored, _ := l.storedChart(artifactRef(scope, "hello", "0.3.0"))
+	g.Expect(stored).ToNot(BeNil())
+
+	digest, err := l.Push(context.TODO(), chartSourceRef(""), archive, PushOptions{
+		Source:   "https://github.com/example/hello",
+		Revision: "main@sha1:4b1b9b3",
+		Created:  time.Date(2023, 8, 18, 10, 0, 0, 0, time.UTC),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	manifest, ok := manifests["/v2/charts/hello/manifests/0.3.0"]
+	g.Expect(ok).To(BeTrue())
+	want, _, err := v1.SHA256(bytes.NewReader(manifest))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(digest).To(Equal(want.String()))
+	var m v1.Manifest
+	g.Expect(json.Unmarshal(manifest, &m)).To(Succeed())
+	g.Expect(m.Annotations).To(Equal(map[string]string{
+		"org.opencontainers.image.source":   "https://github.com/example/hello",
+		"org.opencontainers.image.revision": "main@sha1:4b1b9b3",
+		"org.opencontainers.image.created":  "2023-08-18T10:00:00Z",
+	}))
+	for _, v := range []string{"0.3.0", "v0.3.0"} {
+		ref, _ := l.storedChart(artifactRef(scope, "hello", v))
+		g.Expect(ref).To(BeNil())
+	}
+
+	t.Run("not an OCI HelmRepository", func(t *testing.T) {
+		g := NewWithT(t)
+
+		server := newChartServer(t, "0.1.0")
+		l := New(&fakeClient{objects: []client.Object{helmRepository(server.URL)}})
+		_, err := l.Push(context.TODO(), chartSourceRef(""), archive, PushOptions{})
+		g.Expect(err).To(MatchError(ContainSubstring("pushing charts is only supported for HelmRepositories of type 'oci'")))
+	})
+}
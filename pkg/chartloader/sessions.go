@@ -0,0 +1,311 @@
+package chartloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// registrySession holds the registry credentials and connections of a chart
+// source, which are reused by the loads of its charts until the chart source,
+// or one of the Secrets and ServiceAccounts it references, changes, the token
+// of its cloud provider is about to expire, or the registry rejects them.
+type registrySession struct {
+	// version identifies the state of the objects the session was created from.
+	version string
+	// expiresAt is the time the session must be renewed, before the token of
+	// the cloud provider of the chart source expires. It is zero if the
+	// session does not expire.
+	expiresAt time.Time
+	// objects are the keys of the Secrets and ServiceAccounts the session was created from.
+	objects []string
+	// auth is the authenticator of the cloud provider of the chart source, if any.
+	auth authn.Authenticator
+	// client is the registry client of a HelmRepository of type 'oci',
+	// holding the credentials of the repository.
+	client *RegistryClient
+	// transport is the transport of an OCIRepository with a custom TLS configuration.
+	transport http.RoundTripper
+}
+
+// sessionCache holds the registry sessions of chart sources, keyed by
+// sessionKey. A nil sessionCache holds no sessions.
+type sessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]*registrySession
+}
+
+// get returns the session of the given key, or nil if there is none, or if it
+// was created from another version of the objects, or has expired, in which
+// case it is removed.
+func (c *sessionCache) get(key, version string) *registrySession {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[key]
+	if ok && (s.version != version || (!s.expiresAt.IsZero() && !time.Now().Before(s.expiresAt))) {
+		delete(c.sessions, key)
+		return nil
+	}
+	return s
+}
+
+// put stores the session of the given key, replacing the previous one, if any.
+func (c *sessionCache) put(key string, s *registrySession) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions == nil {
+		c.sessions = map[string]*registrySession{}
+	}
+	c.sessions[key] = s
+}
+
+// invalidate removes the session of the given key.
+func (c *sessionCache) invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, key)
+}
+
+// invalidateOnUnauthorized removes the session of the given key if err means
+// the registry rejected its credentials, e.g. because the token of its cloud
+// provider expired, so that the next load creates a new session.
+func (c *sessionCache) invalidateOnUnauthorized(key string, err error) {
+	if key != "" && errors.Is(err, ErrUnauthorized) {
+		c.invalidate(key)
+	}
+}
+
+// providerTokenLifetimes are the lifetimes of the registry tokens of the cloud
+// providers: 12 hours for ECR, 3 hours for the refresh tokens of ACR, and at
+// least 5 minutes for the access tokens of the GCP metadata server, which
+// serves the same token until shortly before it expires.
+var providerTokenLifetimes = map[string]time.Duration{
+	sourcev1.AmazonOCIProvider: 12 * time.Hour,
+	sourcev1.AzureOCIProvider:  3 * time.Hour,
+	sourcev1.GoogleOCIProvider: 5 * time.Minute,
+}
+
+// providerSessionExpiry returns the time the session of a chart source whose
+// token was issued by the given cloud provider at the given time must be
+// renewed, i.e. when 80% of the lifetime of the token has elapsed, or the zero
+// time if the tokens of the provider are not known to expire.
+func providerSessionExpiry(provider string, issuedAt time.Time) time.Time {
+	lifetime, ok := providerTokenLifetimes[provider]
+	if !ok {
+		return time.Time{}
+	}
+	return issuedAt.Add(lifetime - lifetime/5)
+}
+
+// invalidateObject removes the sessions created from the object of the given key.
+func (c *sessionCache) invalidateObject(objKey string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, s := range c.sessions {
+		for _, o := range s.objects {
+			if o == objKey {
+				delete(c.sessions, key)
+				break
+			}
+		}
+	}
+}
+
+// sessionKey returns the key of the session of the chart source of the given kind.
+func sessionKey(kind string, obj client.Object) string {
+	return fmt.Sprintf("%s/%s/%s", kind, obj.GetNamespace(), obj.GetName())
+}
+
+// objectKey returns the key identifying the given object among the objects
+// the sessions are created from.
+func objectKey(obj client.Object) string {
+	return fmt.Sprintf("%T %s/%s", obj, obj.GetNamespace(), obj.GetName())
+}
+
+// sourceFingerprint returns the state of the given chart source the registry
+// sessions depend on. Unlike its resource version, it does not change when the
+// status of the chart source is updated.
+func sourceFingerprint(obj client.Object) string {
+	var url string
+	switch src := obj.(type) {
+	case *sourcev1.HelmRepository:
+		url = src.Spec.URL
+	case *sourcev1.OCIRepository:
+		url = src.Spec.URL
+	}
+	return fmt.Sprintf("%d %s %v", obj.GetGeneration(), url, obj.GetAnnotations())
+}
+
+// recordingSources is a SourceProvider recording the objects read from it,
+// so that a session can be invalidated when one of them changes.
+type recordingSources struct {
+	SourceProvider
+
+	mu sync.Mutex
+	// reads are the keys and resource versions of the objects read.
+	reads []string
+	keys  []string
+	// creds are the keys and the states of the objects read, i.e. the hash of
+	// the data of the Secrets, and the UID and resource version of the others.
+	creds []string
+}
+
+func (r *recordingSources) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := r.SourceProvider.Get(ctx, key, obj, opts...); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, objectKey(obj))
+	r.reads = append(r.reads, objectKey(obj)+"@"+obj.GetResourceVersion())
+	state := fmt.Sprintf("%s/%s", obj.GetUID(), obj.GetResourceVersion())
+	if secret, ok := obj.(*corev1.Secret); ok {
+		state = secretDigest(secret)
+	}
+	r.creds = append(r.creds, objectKey(obj)+"@"+state)
+	return nil
+}
+
+// credentials returns the keys and the states of the objects read so far,
+// sorted, which identify the credentials of the chart source they were read for.
+func (r *recordingSources) credentials() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	creds := append([]string(nil), r.creds...)
+	sort.Strings(creds)
+	return creds
+}
+
+// secretDigest returns the hash of the data of the given Secret.
+func secretDigest(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data)+len(secret.StringData))
+	for k := range secret.Data {
+		keys = append(keys, k)
+	}
+	for k := range secret.StringData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		v, ok := secret.StringData[k]
+		if !ok {
+			v = string(secret.Data[k])
+		}
+		_, _ = fmt.Fprintf(h, "%d:%s=%d:%s\n", len(k), k, len(v), v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// session returns the session of the given chart source, if it is still valid,
+// and a new empty session to store for the chart source otherwise. The session
+// is valid if neither the chart source nor the objects read so far changed
+// since it was created.
+func (r *recordingSources) session(sessions *sessionCache, kind string, src client.Object) (s *registrySession, reused bool) {
+	r.mu.Lock()
+	reads := append([]string(nil), r.reads...)
+	keys := append([]string(nil), r.keys...)
+	r.mu.Unlock()
+	sort.Strings(reads)
+
+	version := sourceFingerprint(src) + " " + strings.Join(reads, ",")
+	if s := sessions.get(sessionKey(kind, src), version); s != nil {
+		return s, true
+	}
+	return &registrySession{version: version, objects: keys}, false
+}
+
+// WatchSources invalidates the registry sessions enabled with WithRegistrySessions
+// as soon as their HelmRepository or OCIRepository, or one of the Secrets it
+// references, is updated or deleted, using the informers of the given cache,
+// usually the cache of sourceprovider.NewCache the chart sources are read from.
+// Otherwise, a stale session is only invalidated by the next load of its chart
+// source, and keeps its credentials in memory until then.
+func (l *ChartLoader) WatchSources(ctx context.Context, informers crcache.Informers) error {
+	if l.sessions == nil {
+		return fmt.Errorf("registry sessions are not enabled")
+	}
+
+	sources := map[string]client.Object{
+		sourcev1.HelmRepositoryKind: &sourcev1.HelmRepository{},
+		sourcev1.OCIRepositoryKind:  &sourcev1.OCIRepository{},
+	}
+	for kind, obj := range sources {
+		kind := kind
+		informer, err := informers.GetInformer(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to get informer of %s: %w", kind, err)
+		}
+		informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				o, ok1 := oldObj.(client.Object)
+				n, ok2 := newObj.(client.Object)
+				if ok1 && ok2 && sourceFingerprint(o) != sourceFingerprint(n) {
+					l.sessions.invalidate(sessionKey(kind, n))
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if o, ok := deletedObject(obj); ok {
+					l.sessions.invalidate(sessionKey(kind, o))
+				}
+			},
+		})
+	}
+
+	informer, err := informers.GetInformer(ctx, &corev1.Secret{})
+	if err != nil {
+		return fmt.Errorf("failed to get informer of Secret: %w", err)
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o, ok1 := oldObj.(client.Object)
+			n, ok2 := newObj.(client.Object)
+			// Resyncs deliver the same version of the Secret
+			if ok1 && ok2 && o.GetResourceVersion() != n.GetResourceVersion() {
+				l.sessions.invalidateObject(objectKey(n))
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if o, ok := deletedObject(obj); ok {
+				l.sessions.invalidateObject(objectKey(o))
+			}
+		},
+	})
+	return nil
+}
+
+// deletedObject returns the object of a delete event, which is the last known
+// state of the object if the informer missed its deletion.
+func deletedObject(obj interface{}) (client.Object, bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	o, ok := obj.(client.Object)
+	return o, ok
+}
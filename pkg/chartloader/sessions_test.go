@@ -0,0 +1,232 @@
+package chartloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeInformers records the event handlers added to the informers of the object types.
+type fakeInformers struct {
+	crcache.Informers
+	handlers map[reflect.Type]toolscache.ResourceEventHandler
+}
+
+func (f *fakeInformers) GetInformer(_ context.Context, obj client.Object) (crcache.Informer, error) {
+	return &fakeInformer{informers: f, typ: reflect.TypeOf(obj)}, nil
+}
+
+type fakeInformer struct {
+	crcache.Informer
+	informers *fakeInformers
+	typ       reflect.Type
+}
+
+func (i *fakeInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	i.informers.handlers[i.typ] = handler
+}
+
+func TestChartLoader_RegistrySessions(t *testing.T) {
+	handler := registryHandler(t, "0.1.0", "0.1.1")
+	var expired int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" || atomic.LoadInt32(&expired) == 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	repoURL := fmt.Sprintf("oci://%s/charts", strings.TrimPrefix(server.URL, "http://"))
+
+	g := NewWithT(t)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-auth", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pass")},
+	}
+	repo := helmRepository(repoURL)
+	repo.Spec.Type = sourcev1.HelmRepositoryTypeOCI
+	repo.Spec.SecretRef = &meta.LocalObjectReference{Name: secret.Name}
+	sources := &fakeClient{objects: []client.Object{repo, secret}}
+
+	var clients int
+	factory := func(tlsConfig *tls.Config, insecureHTTP bool, opts ...RegistryClientOption) (*RegistryClient, error) {
+		clients++
+		return DefaultRegistryClientFactory(tlsConfig, insecureHTTP, opts...)
+	}
+	l := New(sources, WithRegistryClientFactory(factory), WithRegistrySessions())
+
+	// The session of the repository is reused
+	for i := 0; i < 2; i++ {
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(clients).To(Equal(1))
+
+	// A changed Secret invalidates the session
+	changed := secret.DeepCopy()
+	changed.ResourceVersion = "2"
+	changed.Data["password"] = []byte("wrong")
+	sources.objects = []client.Object{repo, changed}
+	_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).To(MatchError(ErrUnauthorized))
+	g.Expect(clients).To(Equal(2))
+
+	// A changed URL invalidates the session
+	sources.objects = []client.Object{repo, secret}
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	moved := repo.DeepCopy()
+	moved.Spec.URL = repoURL + "/"
+	sources.objects = []client.Object{moved, secret}
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(4))
+
+	// A session whose credentials are rejected is dropped
+	atomic.StoreInt32(&expired, 1)
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).To(MatchError(ErrUnauthorized))
+	g.Expect(clients).To(Equal(4))
+	atomic.StoreInt32(&expired, 0)
+	_, err = l.Load(context.TODO(), chartSourceRef("0.1.0"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(5))
+
+	// Without sessions, every load creates a registry client
+	clients = 0
+	l = New(sources, WithRegistryClientFactory(factory))
+	for i := 0; i < 2; i++ {
+		_, err := l.Load(context.TODO(), chartSourceRef("0.1.0"))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(clients).To(Equal(2))
+}
+
+func Test_sessionCache(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &sessionCache{}
+	c.put("a", &registrySession{version: "1"})
+	c.put("b", &registrySession{version: "1", expiresAt: time.Now().Add(time.Hour)})
+	c.put("c", &registrySession{version: "1", expiresAt: time.Now().Add(-time.Second)})
+	g.Expect(c.get("a", "1")).ToNot(BeNil())
+	g.Expect(c.get("a", "2")).To(BeNil())
+	g.Expect(c.get("a", "1")).To(BeNil())
+	g.Expect(c.get("b", "1")).ToNot(BeNil())
+	g.Expect(c.get("c", "1")).To(BeNil())
+
+	c.invalidateOnUnauthorized("b", fmt.Errorf("wrapped: %w", ErrChartNotFound))
+	g.Expect(c.get("b", "1")).ToNot(BeNil())
+	c.invalidateOnUnauthorized("b", fmt.Errorf("wrapped: %w", ErrUnauthorized))
+	g.Expect(c.get("b", "1")).To(BeNil())
+
+	issued := time.Date(2023, 8, 18, 10, 0, 0, 0, time.UTC)
+	g.Expect(providerSessionExpiry(sourcev1.AmazonOCIProvider, issued)).To(Equal(issued.Add(576 * time.Minute)))
+	g.Expect(providerSessionExpiry(sourcev1.GoogleOCIProvider, issued)).To(Equal(issued.Add(4 * time.Minute)))
+	g.Expect(providerSessionExpiry(sourcev1.GenericOCIProvider, issued).IsZero()).To(BeTrue())
+}
+
+func TestChartLoader_WatchSources(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-auth", Namespace: "default", ResourceVersion: "1"},
+	}
+	repo := ociRepository("oci://registry.example.com/charts/hello", nil)
+	repo.Spec.SecretRef = &meta.LocalObjectReference{Name: secret.Name}
+
+	tests := []struct {
+		name     string
+		typ      reflect.Type
+		event    func(h toolscache.ResourceEventHandler)
+		wantKept bool
+	}{
+		{
+			name: "secret resync",
+			typ:  reflect.TypeOf(secret),
+			event: func(h toolscache.ResourceEventHandler) {
+				h.OnUpdate(secret, secret.DeepCopy())
+			},
+			wantKept: true,
+		},
+		{
+			name: "secret updated",
+			typ:  reflect.TypeOf(secret),
+			event: func(h toolscache.ResourceEventHandler) {
+				updated := secret.DeepCopy()
+				updated.ResourceVersion = "2"
+				h.OnUpdate(secret, updated)
+			},
+		},
+		{
+			name: "secret deleted",
+			typ:  reflect.TypeOf(secret),
+			event: func(h toolscache.ResourceEventHandler) {
+				h.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/registry-auth", Obj: secret})
+			},
+		},
+		{
+			name: "repository status updated",
+			typ:  reflect.TypeOf(repo),
+			event: func(h toolscache.ResourceEventHandler) {
+				updated := repo.DeepCopy()
+				updated.ResourceVersion = "2"
+				updated.Status.ObservedGeneration = 1
+				h.OnUpdate(repo, updated)
+			},
+			wantKept: true,
+		},
+		{
+			name: "repository URL updated",
+			typ:  reflect.TypeOf(repo),
+			event: func(h toolscache.ResourceEventHandler) {
+				updated := repo.DeepCopy()
+				updated.Spec.URL = "oci://registry.example.com/charts/world"
+				h.OnUpdate(repo, updated)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			g.Expect(New(nil).WatchSources(context.TODO(), &fakeInformers{})).ToNot(Succeed())
+
+			l := New(nil, WithRegistrySessions())
+			informers := &fakeInformers{handlers: map[reflect.Type]toolscache.ResourceEventHandler{}}
+			g.Expect(l.WatchSources(context.TODO(), informers)).To(Succeed())
+			g.Expect(informers.handlers).To(HaveLen(3))
+
+			rec := &recordingSources{SourceProvider: &fakeClient{objects: []client.Object{secret}}}
+			g.Expect(rec.Get(context.TODO(), client.ObjectKeyFromObject(secret), &corev1.Secret{})).To(Succeed())
+			session, reused := rec.session(l.sessions, sourcev1.OCIRepositoryKind, repo)
+			g.Expect(reused).To(BeFalse())
+			l.sessions.put(sessionKey(sourcev1.OCIRepositoryKind, repo), session)
+
+			tt.event(informers.handlers[tt.typ])
+			if tt.wantKept {
+				g.Expect(l.sessions.sessions).To(HaveLen(1))
+			} else {
+				g.Expect(l.sessions.sessions).To(BeEmpty())
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package chartloader
+
+import (
+	"fmt"
+	"sync"
+)
+
+// call is an in-flight or completed call of a flightGroup.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+	// dups is the number of callers which joined the call.
+	dups int
+}
+
+// flightGroup collapses concurrent calls with the same key into a single
+// call, whose result is shared by all callers, like golang.org/x/sync/singleflight.
+// The zero value is ready to use.
+type flightGroup struct {
+	// keep makes the group remember the results of completed calls, so the
+	// function of a key is only called once during the lifetime of the group.
+	keep bool
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do calls fn and returns its result, unless a call with the same key is in
+// flight, or completed if the group keeps the results, in which case it returns
+// the result of that call. shared is true if the result is shared with other callers.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	func() {
+		normalReturn := false
+		defer func() {
+			// Release the waiting callers with an error, and forget the call,
+			// even if fn panics, and panic again in the calling goroutine
+			if !normalReturn {
+				c.val, c.err = nil, fmt.Errorf("call of %q panicked or exited", key)
+				if r := recover(); r != nil {
+					c.err = fmt.Errorf("call of %q panicked: %v", key, r)
+					defer panic(r)
+				}
+			}
+			g.mu.Lock()
+			if !g.keep || !normalReturn {
+				delete(g.calls, key)
+			}
+			shared = c.dups > 0
+			g.mu.Unlock()
+			c.wg.Done()
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+	return c.val, c.err, shared
+}
@@ -0,0 +1,176 @@
+package chartloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/opencontainers/go-digest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+	"github.com/tamalsaha/learn-helm-oci/internal/store"
+)
+
+// Store is a persistent on-disk store of chart artifacts.
+type Store = store.Store
+
+// NewStore returns a Store in the given directory, which garbage collects the
+// least recently used charts once the stored charts exceed maxSize bytes.
+// If maxSize is zero, the size of the store is not limited.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	return store.New(dir, maxSize)
+}
+
+// WithStore sets the store used to keep the downloaded charts on disk.
+// Charts referenced by digest or by an exact version are then only
+// downloaded once.
+func WithStore(s *Store) Option {
+	return func(l *ChartLoader) {
+		l.store = s
+	}
+}
+
+// artifactRef returns the store reference of the given chart version of a chart
+// source, or an empty string if the version may resolve to different charts over
+// time. Only digests, versions pinned to a digest, i.e. 'tag@sha256:...', and
+// exact semantic versions are considered immutable.
+// The scope must identify the chart source, including its credentials, so the
+// charts of a source requiring authentication are not served to other sources,
+// see sourceScope.
+func artifactRef(scope, chartName, version string) string {
+	_, d, pinned := registry.SplitDigest(version)
+	switch {
+	case pinned:
+		if digest.Digest(d).Validate() != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s/%s@%s", scope, chartName, d)
+	case isExactVersion(version):
+		return fmt.Sprintf("%s/%s:%s", scope, chartName, version)
+	default:
+		return ""
+	}
+}
+
+// sourceScope returns the scope of the charts of the given chart source with
+// the given URL in the store, made of its namespace, name and URL, and of a
+// hash of its credentials: the references to its Secrets, ServiceAccount and
+// cloud provider, and the given states of the objects read for it, see
+// recordingSources.credentials. The charts stored with other credentials, e.g.
+// before the Secret of the chart source was replaced or its data rotated, are
+// thus not served with these. The credentials of a cloud provider are only
+// identified by the provider, as its tokens are renewed.
+func sourceScope(obj client.Object, url string, credentials []string) string {
+	var creds []string
+	switch src := obj.(type) {
+	case *sourcev1.HelmRepository:
+		if src.Spec.SecretRef != nil {
+			creds = append(creds, "secret="+src.Spec.SecretRef.Name)
+		}
+		creds = append(creds, "provider="+src.Spec.Provider)
+	case *sourcev1.OCIRepository:
+		if src.Spec.SecretRef != nil {
+			creds = append(creds, "secret="+src.Spec.SecretRef.Name)
+		}
+		if src.Spec.CertSecretRef != nil {
+			creds = append(creds, "certSecret="+src.Spec.CertSecretRef.Name)
+		}
+		creds = append(creds, "serviceAccount="+src.Spec.ServiceAccountName, "provider="+src.Spec.Provider)
+	}
+	creds = append(creds, credentials...)
+	sum := sha256.Sum256([]byte(strings.Join(creds, "\n")))
+	return fmt.Sprintf("%s/%s/%s@%s", obj.GetNamespace(), obj.GetName(), url, hex.EncodeToString(sum[:8]))
+}
+
+// isExactVersion returns true if the given version is a complete semantic
+// version, optionally prefixed with 'v', rather than a constraint.
+func isExactVersion(version string) bool {
+	_, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	return err == nil
+}
+
+// storedChart returns the chart archive the given store reference resolves to,
+// or nil if it is not stored.
+func (l *ChartLoader) storedChart(ref string) (*store.Ref, *bytes.Buffer) {
+	if l.store == nil || ref == "" {
+		return nil, nil
+	}
+	r, err := l.store.Resolve(ref)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			l.logger.Error(err, "failed to resolve stored chart", "ref", ref)
+		}
+		return nil, nil
+	}
+	data := l.storedBlob(r.Digest)
+	if data == nil {
+		return nil, nil
+	}
+	l.logger.V(1).Info("loading chart from store", "ref", ref, "digest", r.Digest.String())
+	return r, data
+}
+
+// storedBlob returns the chart archive with the given digest, or nil if it is not stored.
+func (l *ChartLoader) storedBlob(d digest.Digest) *bytes.Buffer {
+	if l.store == nil || d == "" {
+		return nil
+	}
+	data, err := l.store.Get(d)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			l.logger.Error(err, "failed to read stored chart", "digest", d.String())
+		}
+		return nil
+	}
+	return bytes.NewBuffer(data)
+}
+
+// forgetStoredChart removes the store references of the given chart version of
+// a chart source, requested with or without a 'v' prefix, e.g. once another
+// chart archive is pushed as the version. The chart archive itself is kept
+// until it is garbage collected.
+func (l *ChartLoader) forgetStoredChart(scope, chartName, version string) {
+	if l.store == nil {
+		return
+	}
+	version = strings.TrimPrefix(version, "v")
+	for _, v := range []string{version, "v" + version} {
+		ref := artifactRef(scope, chartName, v)
+		if ref == "" {
+			continue
+		}
+		if err := l.store.Untag(ref); err != nil {
+			l.logger.Error(err, "failed to forget stored chart", "ref", ref)
+		}
+	}
+}
+
+// storeChart stores the given chart archive, and tags it with the given store
+// reference if it is not empty. The digest of ref is set to the digest of the
+// archive. Failures are logged, as the store is only a cache.
+func (l *ChartLoader) storeChart(storeRef string, data []byte, ref store.Ref) {
+	if l.store == nil {
+		return
+	}
+	d, err := l.store.Put(data)
+	if err != nil {
+		l.logger.Error(err, "failed to store chart", "url", ref.URL)
+		if d == "" {
+			return
+		}
+	}
+	if storeRef == "" {
+		return
+	}
+	ref.Digest = d
+	if err := l.store.Tag(storeRef, ref); err != nil {
+		l.logger.Error(err, "failed to tag stored chart", "ref", storeRef)
+	}
+}
@@ -0,0 +1,35 @@
+package chartloader
+
+import (
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/registry"
+)
+
+// Attributes of the spans of the chart loads, besides the attributes of the
+// chart and its registry shared with the registry operations.
+const (
+	attributeSourceKind      = attribute.Key("source.kind")
+	attributeSourceNamespace = attribute.Key("source.namespace")
+	attributeSourceName      = attribute.Key("source.name")
+	// attributeProvider is the cloud provider of the registry credentials.
+	attributeProvider = attribute.Key("registry.provider")
+)
+
+// resultAttributes returns the span attributes of the resolved chart of a load.
+func resultAttributes(result *Result) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		registry.AttributeVersion.String(result.Version),
+	}
+	if d := result.ManifestDigest; d != "" {
+		attrs = append(attrs, registry.AttributeDigest.String(d))
+	} else if d := result.Digest; d != "" {
+		attrs = append(attrs, registry.AttributeDigest.String(d))
+	}
+	if u, err := url.Parse(result.URL); err == nil && u.Host != "" {
+		attrs = append(attrs, registry.AttributeRegistryHost.String(u.Host))
+	}
+	return attrs
+}
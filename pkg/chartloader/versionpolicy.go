@@ -0,0 +1,109 @@
+package chartloader
+
+import (
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+const (
+	// AnnotationVersionPolicy is the annotation on a HelmRepository of type 'oci'
+	// that sets the policy selecting the tags of the requested chart versions:
+	// "semver" (the default), "calver", "numerical" or "alphabetical".
+	AnnotationVersionPolicy = "charts.x-helm.dev/version-policy"
+	// AnnotationCalVerLayout is the annotation on a HelmRepository of type 'oci'
+	// that sets the Go time layout of the dates of the versions of the "calver"
+	// policy, "2006.1.2" by default.
+	AnnotationCalVerLayout = "charts.x-helm.dev/calver-layout"
+	// AnnotationVersionPattern is the annotation on a HelmRepository of type 'oci'
+	// that restricts the versions to the tags matching the given regular
+	// expression, the values extracted from them being compared with the policy.
+	AnnotationVersionPattern = "charts.x-helm.dev/version-pattern"
+	// AnnotationVersionExtract is the annotation on a HelmRepository of type 'oci'
+	// that sets the template of the values extracted from the tags matching the
+	// version pattern, e.g. "$ts", the whole match by default.
+	AnnotationVersionExtract = "charts.x-helm.dev/version-extract"
+	// AnnotationIncludePrereleases is the annotation on a HelmRepository of type
+	// 'oci' that includes prereleases in the latest version of the "semver" and
+	// "calver" policies when set to "true", i.e. when the chart version is empty
+	// or "*". Constraints only match prereleases if they have a prerelease
+	// themselves, e.g. ">=1.0.0-0", whatever the annotation.
+	AnnotationIncludePrereleases = "charts.x-helm.dev/include-prereleases"
+)
+
+// VersionPolicy selects the tag of a chart among the tags of an OCI chart
+// repository, e.g. the latest semantic version matching a constraint.
+type VersionPolicy = repository.VersionPolicy
+
+// The names of the version policies.
+const (
+	VersionPolicySemver       = repository.VersionPolicySemver
+	VersionPolicyCalVer       = repository.VersionPolicyCalVer
+	VersionPolicyNumerical    = repository.VersionPolicyNumerical
+	VersionPolicyAlphabetical = repository.VersionPolicyAlphabetical
+)
+
+// NewVersionPolicy returns the version policy with the given name, including
+// prereleases in the latest version if prereleases is true. The dates of the
+// "calver" policy are parsed with the given time layout, "2006.1.2" if it is empty.
+func NewVersionPolicy(name string, prereleases bool, layout string) (VersionPolicy, error) {
+	return repository.NewVersionPolicy(name, prereleases, layout)
+}
+
+// NewRegexVersionPolicy returns the version policy selecting the tags matching
+// the given regular expression, by comparing the values extracted from them
+// with the given template, e.g. "$ts", with policy.
+func NewRegexVersionPolicy(pattern, extract string, policy VersionPolicy) (VersionPolicy, error) {
+	return repository.NewRegexPolicy(pattern, extract, policy)
+}
+
+// versionPolicyAnnotations are the annotations declaring a version policy.
+var versionPolicyAnnotations = []string{
+	AnnotationVersionPolicy,
+	AnnotationCalVerLayout,
+	AnnotationVersionPattern,
+	AnnotationVersionExtract,
+	AnnotationIncludePrereleases,
+}
+
+// hasVersionPolicy returns true if the given chart source declares a version
+// policy with its annotations.
+func hasVersionPolicy(obj client.Object) bool {
+	for _, annotation := range versionPolicyAnnotations {
+		if _, ok := obj.GetAnnotations()[annotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// versionPolicyFor returns the version policy declared by the annotations of
+// the given chart source, or def if none is declared.
+func versionPolicyFor(obj client.Object, def VersionPolicy) (VersionPolicy, error) {
+	if !hasVersionPolicy(obj) {
+		return def, nil
+	}
+	annotations := obj.GetAnnotations()
+
+	var prereleases bool
+	if v, ok := annotations[AnnotationIncludePrereleases]; ok {
+		var err error
+		if prereleases, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid value %q of annotation '%s': %w", v, AnnotationIncludePrereleases, err)
+		}
+	}
+	v := annotations[AnnotationVersionPolicy]
+	policy, err := repository.NewVersionPolicy(v, prereleases, annotations[AnnotationCalVerLayout])
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q of annotation '%s': %w", v, AnnotationVersionPolicy, err)
+	}
+	if pattern, ok := annotations[AnnotationVersionPattern]; ok {
+		if policy, err = repository.NewRegexPolicy(pattern, annotations[AnnotationVersionExtract], policy); err != nil {
+			return nil, fmt.Errorf("invalid value %q of annotation '%s': %w", pattern, AnnotationVersionPattern, err)
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,152 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/internal/helm/repository"
+)
+
+// versionLister lists the versions of the charts of a chart repository.
+type versionLister interface {
+	ListChartVersions(name string) ([]string, error)
+}
+
+// Versions returns the versions of the chart referenced by srcref which can be
+// requested from its chart source, newest first. The version of srcref is ignored.
+// For OCIRepositories, these are the tags of the repository. For OCI chart
+// repositories, these are the tags which are versions under the version policy
+// of the repository, in its order.
+func (l *ChartLoader) Versions(ctx context.Context, srcref releasesapi.ChartSourceRef) ([]string, error) {
+	srcref.SetDefaults()
+
+	var (
+		versions []string
+		ordered  bool
+		err      error
+	)
+	switch srcref.SourceRef.Kind {
+	case releasesapi.SourceKindHelmRepository, releasesapi.SourceKindLegacy:
+		open := l.openHelmRepository
+		if srcref.SourceRef.Kind == releasesapi.SourceKindLegacy {
+			open = l.openLegacyRepository
+		}
+		src, err := open(ctx, srcref)
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+
+		lister, ok := src.chartRepo.(versionLister)
+		if !ok {
+			return nil, fmt.Errorf("listing chart versions is not supported by %T", src.chartRepo)
+		}
+		versions, err = lister.ListChartVersions(srcref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of chart '%s': %w", srcref.Name, err)
+		}
+		_, ordered = src.chartRepo.(*repository.OCIChartRepository)
+	case sourcev1.OCIRepositoryKind:
+		versions, err = l.ociRepositoryTags(ctx, srcref)
+	case releasesapi.SourceKindLocal:
+		versions, err = localVersions(srcref)
+	case releasesapi.SourceKindEmbed:
+		chrt, _, err := l.loadEmbeddedChart(srcref)
+		if err != nil {
+			return nil, err
+		}
+		versions = []string{chrt.Metadata.Version}
+	default:
+		return nil, fmt.Errorf("unsupported chart source kind %q", srcref.SourceRef.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !ordered {
+		sortVersions(versions)
+	}
+	return versions, nil
+}
+
+// ociRepositoryTags returns the tags of the OCIRepository referenced by srcref.
+func (l *ChartLoader) ociRepositoryTags(ctx context.Context, srcref releasesapi.ChartSourceRef) ([]string, error) {
+	o, err := l.openOCIRepository(ctx, srcref)
+	if err != nil {
+		return nil, err
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	repo, err := name.NewRepository(o.url, o.nameOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return l.listTags(ctx, repo, append(o.remoteOpts, remote.WithContext(ctxTimeout)))
+}
+
+// localVersions returns the version of the chart directory, and the versions
+// of the chart archives, in the directory named by the source ref of srcref.
+func localVersions(srcref releasesapi.ChartSourceRef) ([]string, error) {
+	if err := validateChartName(srcref.Name); err != nil {
+		return nil, err
+	}
+	if srcref.SourceRef.Name == "" {
+		return nil, fmt.Errorf("missing chart directory for source kind %q", srcref.SourceRef.Kind)
+	}
+
+	var versions []string
+	chartPath := filepath.Join(srcref.SourceRef.Name, srcref.Name)
+	if fi, err := os.Stat(chartPath); err == nil && fi.IsDir() {
+		chrt, err := loader.LoadDir(chartPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chart from '%s': %w", chartPath, err)
+		}
+		versions = append(versions, chrt.Metadata.Version)
+	}
+
+	archives, err := filepath.Glob(chartPath + "-*.tgz")
+	if err != nil {
+		return nil, err
+	}
+	prefix := srcref.Name + "-"
+	for _, archive := range archives {
+		v := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(archive), prefix), ".tgz")
+		// Skip the archives of other charts whose name starts with the chart name
+		if _, err := semver.NewVersion(v); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, repository.NewError(ErrChartNotFound, chartPath, fmt.Errorf("no chart '%s' found in '%s'", srcref.Name, srcref.SourceRef.Name))
+	}
+	return versions, nil
+}
+
+// sortVersions sorts the given versions, newest first. Semantic versions
+// precede other versions, which are kept in their original order.
+func sortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := semver.NewVersion(versions[i])
+		vj, errj := semver.NewVersion(versions[j])
+		switch {
+		case erri != nil:
+			return false
+		case errj != nil:
+			return true
+		default:
+			return vi.GreaterThan(vj)
+		}
+	})
+}
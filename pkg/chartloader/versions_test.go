@@ -0,0 +1,74 @@
+package chartloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+func TestChartLoader_Versions(t *testing.T) {
+	chartServer := newChartServer(t, "0.1.0", "0.2.0", "0.1.1")
+	registryServer := newRegistryServer(t, "0.1.0", "0.2.0")
+	ociURL := fmt.Sprintf("oci://%s/charts/hello", strings.TrimPrefix(registryServer.URL, "http://"))
+
+	dir := t.TempDir()
+	for _, c := range []*chart.Chart{
+		{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "1.0.0"}},
+		{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "1.1.0"}},
+		{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello-world", Version: "2.0.0"}},
+	} {
+		if _, err := chartutil.Save(c, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kc := &fakeClient{objects: []client.Object{helmRepository(chartServer.URL), ociRepository(ociURL, nil)}}
+	fsys := fstest.MapFS{
+		"charts/hello/Chart.yaml": {Data: []byte("apiVersion: v2\nname: hello\nversion: 0.3.0\n")},
+	}
+
+	tests := []struct {
+		name    string
+		srcref  releasesapi.ChartSourceRef
+		want    []string
+		wantErr string
+	}{
+		{name: "HelmRepository", srcref: chartSourceRef(""), want: []string{"0.2.0", "0.1.1", "0.1.0"}},
+		{name: "OCIRepository", srcref: ociChartSourceRef(""), want: []string{"0.2.0", "0.1.0"}},
+		{name: "Legacy", srcref: legacyChartSourceRef(releasesapi.SourceKindLegacy, chartServer.URL, "hello", ""), want: []string{"0.2.0", "0.1.1", "0.1.0"}},
+		{name: "Local", srcref: legacyChartSourceRef(releasesapi.SourceKindLocal, dir, "hello", ""), want: []string{"1.1.0", "1.0.0"}},
+		{name: "Embed", srcref: legacyChartSourceRef(releasesapi.SourceKindEmbed, "charts", "hello", ""), want: []string{"0.3.0"}},
+		{name: "unknown chart", srcref: legacyChartSourceRef(releasesapi.SourceKindLegacy, chartServer.URL, "unknown", ""), wantErr: "no chart name found"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := New(kc, WithEmbeddedCharts(fsys))
+			versions, err := l.Versions(context.TODO(), tt.srcref)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(versions).To(Equal(tt.want))
+		})
+	}
+}
+
+func Test_sortVersions(t *testing.T) {
+	g := NewWithT(t)
+
+	versions := []string{"latest", "1.0.0", "v1.10.0", "main", "1.2.0"}
+	sortVersions(versions)
+	g.Expect(versions).To(Equal([]string{"v1.10.0", "1.2.0", "1.0.0", "latest", "main"}))
+}
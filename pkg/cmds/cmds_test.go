@@ -0,0 +1,91 @@
+package cmds
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestRootCmd(t *testing.T) {
+	dir := t.TempDir()
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "hello", Version: "0.1.0"},
+		Raw:      []*chart.File{{Name: chartutil.ValuesfileName, Data: []byte("# replicas of the app\nreplicas: 1\n")}},
+		Values:   map[string]interface{}{"replicas": 1},
+		Files:    []*chart.File{{Name: "README.md", Data: []byte("# hello\n")}},
+	}
+	if err := chartutil.SaveDir(c, dir); err != nil {
+		t.Fatal(err)
+	}
+	c.Metadata.Version = "0.2.0"
+	if _, err := chartutil.Save(c, dir); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+
+	source := []string{"--kind", "Local", "--name", dir, "--chart", "hello"}
+	tests := []struct {
+		name       string
+		args       []string
+		want       string
+		wantStderr string
+		wantErr    string
+	}{
+		{name: "show chart", args: []string{"show", "chart"}, want: "apiVersion: v2\nname: hello\nversion: 0.1.0\n"},
+		{name: "show values", args: []string{"show", "values"}, want: "# replicas of the app\nreplicas: 1\n"},
+		{name: "show readme", args: []string{"show", "readme"}, want: "# hello\n"},
+		{name: "versions", args: []string{"versions"}, want: "0.2.0\n0.1.0\n"},
+		{name: "resolve", args: []string{"resolve", "--version", "0.2.0"}, want: "digest: sha256:"},
+		{name: "resolve json", args: []string{"resolve", "--version", "0.2.0", "-o", "json"}, want: "{\n  \"name\": \"hello\",\n  \"version\": \"0.2.0\","},
+		{name: "pull", args: []string{"pull", "--version", "0.2.0", "-d", out}, want: filepath.Join(out, "hello-0.2.0.tgz") + "\n"},
+		{name: "trace", args: []string{"resolve", "--version", "0.2.0", "--trace-exporter", "stdout"}, want: "digest: sha256:", wantStderr: `"name":"ChartLoader.Load"`},
+		{name: "unsupported trace exporter", args: []string{"versions", "--trace-exporter", "jaeger"}, wantErr: "unsupported trace exporter"},
+		{name: "missing chart", args: []string{"show", "chart", "--chart", ""}, wantErr: "--chart is required"},
+		{name: "embed", args: []string{"show", "chart", "--kind", "Embed"}, wantErr: "source kind \"Embed\" is not supported by the CLI"},
+		{name: "search", args: []string{"search", "hello"}, wantErr: "listing charts is not supported for chart source kind \"Local\""},
+		{name: "index", args: []string{"index"}, wantErr: "listing charts is not supported for chart source kind \"Local\""},
+		{name: "push", args: []string{"push", filepath.Join(dir, "hello-0.2.0.tgz")}, wantErr: "pushing charts is not supported for chart source kind \"Local\""},
+		{name: "push invalid created", args: []string{"push", filepath.Join(dir, "hello-0.2.0.tgz"), "--created", "yesterday"}, wantErr: "invalid --created"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var stdout, stderr bytes.Buffer
+			cmd := NewRootCmd()
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+			cmd.SetArgs(append(append([]string{}, source...), tt.args...))
+			err := cmd.Execute()
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(stdout.String()).To(HavePrefix(tt.want))
+			g.Expect(stderr.String()).To(ContainSubstring(tt.wantStderr))
+		})
+	}
+
+	t.Run("pull writes the archive unchanged", func(t *testing.T) {
+		g := NewWithT(t)
+
+		out := t.TempDir()
+		cmd := NewRootCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs(append(append([]string{}, source...), "pull", "--version", "0.2.0", "-d", out))
+		g.Expect(cmd.Execute()).To(Succeed())
+
+		want, err := os.ReadFile(filepath.Join(dir, "hello-0.2.0.tgz"))
+		g.Expect(err).ToNot(HaveOccurred())
+		got, err := os.ReadFile(filepath.Join(out, "hello-0.2.0.tgz"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(Equal(want))
+	})
+}
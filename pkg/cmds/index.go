@@ -0,0 +1,38 @@
+package cmds
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+func NewCmdIndex(opts *sourceOptions) *cobra.Command {
+	var downloadURL string
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "Print the index.yaml of the charts of an OCI HelmRepository, as served by a classic chart repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.name == "" {
+				return fmt.Errorf("--name is required")
+			}
+			l, err := opts.NewChartLoader()
+			if err != nil {
+				return err
+			}
+			index, err := l.Index(cmd.Context(), opts.ChartSourceRef(), downloadURL)
+			if err != nil {
+				return err
+			}
+			data, err := yaml.Marshal(index)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&downloadURL, "download-url", "", "Base URL of the chart archives, e.g. of a download proxy; defaults to the oci:// references of the charts")
+	return cmd
+}
@@ -0,0 +1,105 @@
+package cmds
+
+import (
+	"context"
+	"fmt"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"github.com/spf13/pflag"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+
+	"github.com/tamalsaha/learn-helm-oci/pkg/chartloader"
+	"github.com/tamalsaha/learn-helm-oci/pkg/sourceprovider"
+)
+
+// sourceOptions are the flags selecting a chart of a chart source.
+type sourceOptions struct {
+	kind      string
+	namespace string
+	name      string
+	chart     string
+	version   string
+	manifests string
+}
+
+func (o *sourceOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.kind, "kind", releasesapi.SourceKindHelmRepository, "Kind of the chart source: HelmRepository, OCIRepository, Legacy or Local")
+	fs.StringVarP(&o.namespace, "namespace", "n", "default", "Namespace of the HelmRepository or OCIRepository")
+	fs.StringVar(&o.name, "name", "", "Name of the chart source; the repository URL of Legacy sources, and the chart directory of Local sources")
+	fs.StringVar(&o.chart, "chart", "", "Name of the chart")
+	fs.StringVar(&o.version, "version", "", "Version, semver constraint or digest of the chart; defaults to the latest version")
+	fs.StringVar(&o.manifests, "manifests", "", "Directory of YAML manifests declaring the chart sources and their Secrets, to use instead of a cluster")
+}
+
+// Validate returns an error if the chart source or the chart is not set, or if
+// the chart source is of kind Embed, as the CLI embeds no charts.
+// The chart of an OCIRepository is the artifact of the repository, so its name is optional.
+func (o *sourceOptions) Validate() error {
+	if o.kind == releasesapi.SourceKindEmbed {
+		return fmt.Errorf("source kind %q is not supported by the CLI, which embeds no charts", o.kind)
+	}
+	if o.name == "" {
+		return fmt.Errorf("--name is required")
+	}
+	if o.chart == "" && o.kind != sourcev1.OCIRepositoryKind {
+		return fmt.Errorf("--chart is required for source kind %q", o.kind)
+	}
+	return nil
+}
+
+// ChartSourceRef returns the chart source ref selected by the flags.
+func (o *sourceOptions) ChartSourceRef() releasesapi.ChartSourceRef {
+	srcref := releasesapi.ChartSourceRef{
+		Name:    o.chart,
+		Version: o.version,
+		SourceRef: kmapi.TypedObjectReference{
+			Kind: o.kind,
+			Name: o.name,
+		},
+	}
+	if o.kind == releasesapi.SourceKindHelmRepository || o.kind == sourcev1.OCIRepositoryKind {
+		srcref.SourceRef.Namespace = o.namespace
+	}
+	return srcref
+}
+
+// NewChartLoader returns the ChartLoader of the chart source. The chart source
+// objects are read from the manifests if set, or else from the cluster of the
+// current kubeconfig context. A cluster is only used for chart sources which
+// are Kubernetes objects.
+func (o *sourceOptions) NewChartLoader() (*chartloader.ChartLoader, error) {
+	var sources chartloader.SourceProvider
+	switch {
+	case o.manifests != "":
+		m, err := sourceprovider.NewManifests(o.manifests)
+		if err != nil {
+			return nil, err
+		}
+		sources = m
+	case o.kind == releasesapi.SourceKindHelmRepository || o.kind == sourcev1.OCIRepositoryKind:
+		cfg, err := ctrl.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		kc, err := sourceprovider.NewCluster(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sources = kc
+	}
+	return chartloader.New(sources), nil
+}
+
+// Load validates the flags and loads the selected chart.
+func (o *sourceOptions) Load(ctx context.Context) (*chartloader.Result, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	l, err := o.NewChartLoader()
+	if err != nil {
+		return nil, err
+	}
+	return l.Load(ctx, o.ChartSourceRef())
+}
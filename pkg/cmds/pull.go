@@ -0,0 +1,40 @@
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func NewCmdPull(opts *sourceOptions) *cobra.Command {
+	var destination string
+	cmd := &cobra.Command{
+		Use:   "pull",
+		Short: "Download a chart and write its chart archive",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := opts.Load(cmd.Context())
+			if err != nil {
+				return err
+			}
+			// The downloaded archive is written unchanged, so it matches the
+			// reported digest. Charts loaded from a directory are packaged.
+			path := filepath.Join(destination, fmt.Sprintf("%s-%s.tgz", result.Chart.Name(), result.Chart.Metadata.Version))
+			if result.Archive != nil {
+				err = os.WriteFile(path, result.Archive, 0o644)
+			} else {
+				path, err = chartutil.Save(result.Chart, destination)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write chart archive: %w", err)
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), path)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&destination, "destination", "d", ".", "Directory to write the chart archive to")
+	return cmd
+}
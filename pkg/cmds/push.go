@@ -0,0 +1,60 @@
+package cmds
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/tamalsaha/learn-helm-oci/pkg/chartloader"
+)
+
+func NewCmdPush(opts *sourceOptions) *cobra.Command {
+	var (
+		provFile string
+		created  string
+		pushOpts chartloader.PushOptions
+	)
+	cmd := &cobra.Command{
+		Use:   "push ARCHIVE",
+		Short: "Push a packaged chart to an OCI HelmRepository and print the digest of its manifest",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.name == "" {
+				return fmt.Errorf("--name is required")
+			}
+			if created != "" {
+				t, err := time.Parse(time.RFC3339, created)
+				if err != nil {
+					return fmt.Errorf("invalid --created: %w", err)
+				}
+				pushOpts.Created = t
+			}
+			archive, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			if provFile != "" {
+				if pushOpts.Provenance, err = os.ReadFile(provFile); err != nil {
+					return err
+				}
+			}
+			l, err := opts.NewChartLoader()
+			if err != nil {
+				return err
+			}
+			digest, err := l.Push(cmd.Context(), opts.ChartSourceRef(), archive, pushOpts)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), digest)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&provFile, "prov", "", "Path of the provenance file of the chart archive")
+	cmd.Flags().StringVar(&pushOpts.Source, "source", "", "URL of the source of the chart, e.g. its Git repository")
+	cmd.Flags().StringVar(&pushOpts.Revision, "revision", "", "Revision of the source of the chart, e.g. a Git commit")
+	cmd.Flags().StringVar(&created, "created", "", "Creation time of the artifact in RFC 3339 format, e.g. 2023-08-18T10:00:00Z; defaults to the time of the push")
+	return cmd
+}
@@ -0,0 +1,45 @@
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+func NewCmdResolve(opts *sourceOptions) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Print the version, digests and verification status the requested chart resolves to",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var marshal func(interface{}) ([]byte, error)
+			switch output {
+			case "yaml":
+				marshal = yaml.Marshal
+			case "json":
+				marshal = func(v interface{}) ([]byte, error) {
+					data, err := json.MarshalIndent(v, "", "  ")
+					return append(data, '\n'), err
+				}
+			default:
+				return fmt.Errorf("unsupported output format %q", output)
+			}
+
+			result, err := opts.Load(cmd.Context())
+			if err != nil {
+				return err
+			}
+			data, err := marshal(result)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "yaml", "Output format: yaml or json")
+	return cmd
+}
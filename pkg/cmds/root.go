@@ -0,0 +1,61 @@
+package cmds
+
+import (
+	"context"
+	"flag"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
+	"k8s.io/klog/v2"
+
+	"github.com/tamalsaha/learn-helm-oci/pkg/tracing"
+)
+
+// NewRootCmd returns the root command of the CLI. Its subcommands load charts
+// with the chartloader package, the same way they are loaded in a cluster.
+func NewRootCmd() *cobra.Command {
+	opts := &sourceOptions{}
+	var (
+		traceExporter string
+		shutdown      func(context.Context) error
+	)
+	cmd := &cobra.Command{
+		Use:               "learn-helm-oci",
+		Short:             "Load and inspect Helm charts from chart sources",
+		SilenceUsage:      true,
+		DisableAutoGenTag: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// The spans are printed to stderr, so they do not mix with the output of the commands
+			tp, stop, err := tracing.NewTracerProvider(cmd.Context(), cmd.Root().Name(), traceExporter, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			otel.SetTracerProvider(tp)
+			shutdown = stop
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if shutdown == nil {
+				return nil
+			}
+			return shutdown(context.Background())
+		},
+	}
+	opts.AddFlags(cmd.PersistentFlags())
+	cmd.PersistentFlags().StringVar(&traceExporter, "trace-exporter", tracing.ExporterNone, "Exporter of the traces of the chart loads: none, stdout (printed to stderr) or otlp (configured with the OTEL_EXPORTER_OTLP_* environment variables)")
+	// Adds the flags of klog, and --kubeconfig. The flags of klog take precedence
+	// over those of glog with the same names, which glog registers as Go flags.
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	cmd.PersistentFlags().AddGoFlagSet(klogFlags)
+	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+
+	cmd.AddCommand(NewCmdPull(opts))
+	cmd.AddCommand(NewCmdShow(opts))
+	cmd.AddCommand(NewCmdVersions(opts))
+	cmd.AddCommand(NewCmdResolve(opts))
+	cmd.AddCommand(NewCmdSearch(opts))
+	cmd.AddCommand(NewCmdIndex(opts))
+	cmd.AddCommand(NewCmdPush(opts))
+	return cmd
+}
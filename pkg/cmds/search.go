@@ -0,0 +1,40 @@
+package cmds
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCmdSearch(opts *sourceOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "search [QUERY]",
+		Short: "Search the charts of an OCI HelmRepository by name, description or keyword",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.name == "" {
+				return fmt.Errorf("--name is required")
+			}
+			l, err := opts.NewChartLoader()
+			if err != nil {
+				return err
+			}
+			var query string
+			if len(args) > 0 {
+				query = args[0]
+			}
+			charts, err := l.Search(cmd.Context(), opts.ChartSourceRef(), query)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "NAME\tVERSION\tAPP VERSION\tDESCRIPTION")
+			for _, c := range charts {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name, c.Versions[0], c.Metadata.AppVersion, c.Metadata.Description)
+			}
+			return w.Flush()
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package cmds
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// readmeFileNames are the names of the README file of a chart, in lower case.
+var readmeFileNames = []string{"readme.md", "readme.txt", "readme"}
+
+func NewCmdShow(opts *sourceOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the information of a chart",
+	}
+	cmd.AddCommand(newCmdShowPart(opts, "chart", "Show the definition of a chart", showChart))
+	cmd.AddCommand(newCmdShowPart(opts, "values", "Show the default values of a chart", showValues))
+	cmd.AddCommand(newCmdShowPart(opts, "readme", "Show the README of a chart", showReadme))
+	return cmd
+}
+
+func newCmdShowPart(opts *sourceOptions, use, short string, show func(*chart.Chart) ([]byte, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := opts.Load(cmd.Context())
+			if err != nil {
+				return err
+			}
+			data, err := show(result.Chart)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+}
+
+func showChart(chrt *chart.Chart) ([]byte, error) {
+	return yaml.Marshal(chrt.Metadata)
+}
+
+// showValues returns the values file of the chart as is, to keep its comments.
+func showValues(chrt *chart.Chart) ([]byte, error) {
+	for _, f := range chrt.Raw {
+		if f.Name == chartutil.ValuesfileName {
+			return f.Data, nil
+		}
+	}
+	return nil, nil
+}
+
+func showReadme(chrt *chart.Chart) ([]byte, error) {
+	for _, name := range readmeFileNames {
+		for _, f := range chrt.Files {
+			if strings.EqualFold(f.Name, name) {
+				return f.Data, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("chart '%s' has no README", chrt.Name())
+}
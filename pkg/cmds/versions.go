@@ -0,0 +1,34 @@
+package cmds
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCmdVersions(opts *sourceOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "versions",
+		Short: "List the versions of a chart, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			l, err := opts.NewChartLoader()
+			if err != nil {
+				return err
+			}
+			versions, err := l.Versions(cmd.Context(), opts.ChartSourceRef())
+			if err != nil {
+				return err
+			}
+			for _, v := range versions {
+				if _, err := fmt.Fprintln(cmd.OutOrStdout(), v); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
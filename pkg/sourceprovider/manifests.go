@@ -0,0 +1,150 @@
+package sourceprovider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// manifestKey identifies an object of the manifests.
+type manifestKey struct {
+	gvk schema.GroupVersionKind
+	client.ObjectKey
+}
+
+// Manifests provides the chart source objects declared by a directory of
+// YAML or JSON manifests, so charts can be loaded without a cluster.
+// It is safe for concurrent use.
+type Manifests struct {
+	objects map[manifestKey]client.Object
+}
+
+// NewManifests reads the manifests of the '*.yaml', '*.yml' and '*.json' files
+// in the given directory and its subdirectories. Files may contain multiple
+// YAML documents. Objects of kinds which are not in the Scheme, e.g. HelmReleases,
+// are ignored, and objects without a namespace are in the 'default' namespace.
+func NewManifests(dir string) (*Manifests, error) {
+	m := &Manifests{objects: map[manifestKey]client.Object{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+			return m.readFile(path)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manifests) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := serializer.NewCodecFactory(Scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", path, err)
+		}
+		if isEmptyDocument(doc) {
+			continue
+		}
+
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if runtime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode '%s': %w", path, err)
+		}
+		cobj, ok := obj.(client.Object)
+		if !ok {
+			continue
+		}
+		if err := m.add(*gvk, cobj); err != nil {
+			return fmt.Errorf("invalid manifest in '%s': %w", path, err)
+		}
+	}
+}
+
+func (m *Manifests) add(gvk schema.GroupVersionKind, obj client.Object) error {
+	if obj.GetName() == "" {
+		return fmt.Errorf("%s has no name", gvk.Kind)
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(corev1.NamespaceDefault)
+	}
+	// The API server merges the string data into the data of Secrets
+	if secret, ok := obj.(*corev1.Secret); ok && len(secret.StringData) > 0 {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for k, v := range secret.StringData {
+			secret.Data[k] = []byte(v)
+		}
+		secret.StringData = nil
+	}
+
+	key := manifestKey{gvk: gvk, ObjectKey: client.ObjectKeyFromObject(obj)}
+	if _, ok := m.objects[key]; ok {
+		return fmt.Errorf("duplicate %s '%s'", gvk.Kind, key.ObjectKey)
+	}
+	m.objects[key] = obj
+	return nil
+}
+
+// Get returns the object with the given key and the kind of obj,
+// or a NotFound error if it is not declared by the manifests.
+func (m *Manifests) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	gvk, err := apiutil.GVKForObject(obj, Scheme)
+	if err != nil {
+		return err
+	}
+	o, ok := m.objects[manifestKey{gvk: gvk, ObjectKey: key}]
+	if !ok || reflect.TypeOf(o) != reflect.TypeOf(obj) {
+		return apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}, key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(o.DeepCopyObject()).Elem())
+	return nil
+}
+
+// isEmptyDocument returns true if the given YAML document only holds comments.
+func isEmptyDocument(doc []byte) bool {
+	for _, line := range bytes.Split(doc, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package sourceprovider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const repoManifests = `# The chart repository
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: charts
+  namespace: flux-system
+spec:
+  type: oci
+  url: oci://ghcr.io/charts
+  secretRef:
+    name: regcred
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: regcred
+  namespace: flux-system
+data:
+  username: dXNlcg==
+stringData:
+  password: pass
+---
+# No resource
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: hello
+`
+
+const ociRepositoryManifest = `{
+  "apiVersion": "source.toolkit.fluxcd.io/v1beta2",
+  "kind": "OCIRepository",
+  "metadata": {"name": "hello"},
+  "spec": {"url": "oci://ghcr.io/charts/hello"}
+}
+`
+
+func TestManifests(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	g.Expect(os.MkdirAll(filepath.Join(dir, "oci"), 0o755)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "repo.yaml"), []byte(repoManifests), 0o644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "oci", "repo.json"), []byte(ociRepositoryManifest), 0o644)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "README.md"), []byte("# manifests"), 0o644)).To(Succeed())
+
+	m, err := NewManifests(dir)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var repo sourcev1.HelmRepository
+	g.Expect(m.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "charts"}, &repo)).To(Succeed())
+	g.Expect(repo.Spec.URL).To(Equal("oci://ghcr.io/charts"))
+	g.Expect(repo.Spec.SecretRef.Name).To(Equal("regcred"))
+
+	var secret corev1.Secret
+	g.Expect(m.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "regcred"}, &secret)).To(Succeed())
+	g.Expect(secret.Data).To(Equal(map[string][]byte{"username": []byte("user"), "password": []byte("pass")}))
+
+	var ociRepo sourcev1.OCIRepository
+	g.Expect(m.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "hello"}, &ociRepo)).To(Succeed())
+	g.Expect(ociRepo.Spec.URL).To(Equal("oci://ghcr.io/charts/hello"))
+
+	// Objects are looked up by kind
+	err = m.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "charts"}, &sourcev1.OCIRepository{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	err = m.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "regcred"}, &corev1.Secret{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	// Returned objects are copies
+	repo.Spec.URL = "oci://example.com"
+	g.Expect(m.Get(context.TODO(), client.ObjectKey{Namespace: "flux-system", Name: "charts"}, &repo)).To(Succeed())
+	g.Expect(repo.Spec.URL).To(Equal("oci://ghcr.io/charts"))
+}
+
+func TestManifests_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{name: "duplicate", manifest: "kind: Secret\napiVersion: v1\nmetadata:\n  name: a\n---\nkind: Secret\napiVersion: v1\nmetadata:\n  name: a\n  namespace: default\n", wantErr: "duplicate Secret"},
+		{name: "no name", manifest: "kind: Secret\napiVersion: v1\n", wantErr: "has no name"},
+		{name: "invalid", manifest: "kind: Secret\napiVersion: v1\nmetadata: []\n", wantErr: "failed to decode"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+			g.Expect(os.WriteFile(filepath.Join(dir, "manifests.yaml"), []byte(tt.manifest), 0o644)).To(Succeed())
+			_, err := NewManifests(dir)
+			g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+		})
+	}
+}
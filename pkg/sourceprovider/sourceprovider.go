@@ -0,0 +1,75 @@
+// Package sourceprovider provides the chart source objects read by a
+// chartloader.ChartLoader, either from a cluster or from YAML manifests.
+package sourceprovider
+
+import (
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2/klogr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Scheme holds the kinds of the chart source objects, and of the
+// Kubernetes objects they reference.
+var Scheme = runtime.NewScheme()
+
+func init() {
+	_ = clientgoscheme.AddToScheme(Scheme)
+	_ = sourcev1.AddToScheme(Scheme)
+}
+
+// NewCluster returns a Kubernetes client which reads the chart source
+// objects from the API server of the given config.
+func NewCluster(cfg *rest.Config) (client.Client, error) {
+	cfg, mapper, err := clusterConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(cfg, client.Options{
+		Scheme: Scheme,
+		Mapper: mapper,
+	})
+}
+
+// NewCache returns an informer-backed cache of the chart source objects of the
+// cluster of the given config, for long-running loaders which load charts
+// repeatedly. Unlike the client of NewCluster, reading an object does not
+// request the API server once the informer of its kind is synced; the informers
+// of HelmRepositories, OCIRepositories and Secrets are created on first read.
+// If namespace is not empty, only the objects of the namespace are cached.
+// The cache must be started with Start before it is read, and needs the
+// permissions to list and watch the objects it caches.
+func NewCache(cfg *rest.Config, namespace string) (cache.Cache, error) {
+	cfg, mapper, err := clusterConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.New(cfg, cache.Options{
+		Scheme:    Scheme,
+		Mapper:    mapper,
+		Namespace: namespace,
+	})
+}
+
+// clusterConfig returns a copy of the given config with the rate limits of
+// the chart loaders, and its REST mapper.
+func clusterConfig(cfg *rest.Config) (*rest.Config, meta.RESTMapper, error) {
+	ctrl.SetLogger(klogr.New())
+	cfg = rest.CopyConfig(cfg)
+	cfg.QPS = 100
+	cfg.Burst = 100
+
+	mapper, err := apiutil.NewDynamicRESTMapper(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, mapper, nil
+}
@@ -0,0 +1,118 @@
+// Package tracing configures the OpenTelemetry exporter of the traces of the
+// chart loads, e.g. to print them or to send them to an OTLP collector.
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The exporters of the traces.
+const (
+	// ExporterNone discards the traces.
+	ExporterNone = "none"
+	// ExporterStdout writes the spans as JSON lines.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends the traces to an OTLP collector over gRPC, configured
+	// with the OTEL_EXPORTER_OTLP_* environment variables.
+	ExporterOTLP = "otlp"
+)
+
+// NewTracerProvider returns a TracerProvider exporting the traces with the
+// given exporter, and the function to flush and stop it. The stdout exporter
+// writes to out.
+func NewTracerProvider(ctx context.Context, serviceName, exporter string, out io.Writer) (trace.TracerProvider, func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone, "":
+		return trace.NewNoopTracerProvider(), func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter = NewWriterExporter(out)
+	case ExporterOTLP:
+		e, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		spanExporter = e
+	default:
+		return nil, nil, fmt.Errorf("unsupported trace exporter %q", exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(serviceName))),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+// writerExporter is a SpanExporter writing the spans as JSON lines.
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns a SpanExporter writing every span as a JSON line
+// to w, e.g. to print the traces for debugging.
+func NewWriterExporter(w io.Writer) sdktrace.SpanExporter {
+	return &writerExporter{w: w}
+}
+
+// span is the JSON representation of a span written by the writerExporter.
+type span struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"traceID"`
+	SpanID     string            `json:"spanID"`
+	ParentID   string            `json:"parentID,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   string            `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func (e *writerExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		out := span{
+			Name:     s.Name(),
+			TraceID:  s.SpanContext().TraceID().String(),
+			SpanID:   s.SpanContext().SpanID().String(),
+			Start:    s.StartTime(),
+			Duration: s.EndTime().Sub(s.StartTime()).String(),
+			Error:    s.Status().Description,
+		}
+		if s.Parent().IsValid() {
+			out.ParentID = s.Parent().SpanID().String()
+		}
+		if code := s.Status().Code.String(); code != "Unset" {
+			out.Status = code
+		}
+		if attrs := s.Attributes(); len(attrs) > 0 {
+			out.Attributes = make(map[string]string, len(attrs))
+			for _, kv := range attrs {
+				out.Attributes[string(kv.Key)] = kv.Value.Emit()
+			}
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *writerExporter) Shutdown(context.Context) error {
+	return nil
+}